@@ -0,0 +1,283 @@
+package apksign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Java KeyStore format (sun.security.provider.JavaKeyStore).
+const (
+	jksMagic      = 0xFEEDFEED
+	jksVersion    = 2
+	jksPrivateKey = 1
+	jksTrusted    = 2
+)
+
+// oidJKSKeyProtector is the proprietary Sun key protection algorithm
+// (sun.security.provider.KeyProtector).
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+type jksEncryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// isJKS checks if buf looks like a JKS keystore.
+func isJKS(buf []byte) bool {
+	return len(buf) >= 4 && binary.BigEndian.Uint32(buf) == jksMagic
+}
+
+// readJKS reads the private key entry with the specified alias from a JKS
+// keystore. The key password must be the same as the store password.
+func readJKS(buf []byte, alias, password string) (*Key, error) {
+	if len(buf) < 12+sha1.Size || !isJKS(buf) {
+		return nil, errors.New("jks: invalid keystore")
+	}
+	pw := jksPassword(password)
+
+	body, sum := buf[:len(buf)-sha1.Size], buf[len(buf)-sha1.Size:]
+	if subtle.ConstantTimeCompare(jksDigest(pw, body), sum) != 1 {
+		return nil, errors.New("jks: keystore was tampered with, or password was incorrect")
+	}
+
+	r := bytes.NewReader(body[4:])
+	var version, count uint32
+	if err := jksRead(r, &version, &count); err != nil {
+		return nil, err
+	}
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("jks: unsupported version %d", version)
+	}
+
+	var aliases []string
+	for range count {
+		var tag uint32
+		if err := jksRead(r, &tag); err != nil {
+			return nil, err
+		}
+		name, err := jksReadUTF(r)
+		if err != nil {
+			return nil, err
+		}
+		var ts uint64
+		if err := jksRead(r, &ts); err != nil {
+			return nil, err
+		}
+		switch tag {
+		case jksPrivateKey:
+			protected, err := jksReadBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			var n uint32
+			if err := jksRead(r, &n); err != nil {
+				return nil, err
+			}
+			certs := make([][]byte, n)
+			for i := range certs {
+				if certs[i], err = jksReadCert(r, version); err != nil {
+					return nil, err
+				}
+			}
+			aliases = append(aliases, name)
+			if !strings.EqualFold(name, alias) {
+				continue
+			}
+			if len(certs) == 0 {
+				return nil, fmt.Errorf("jks: alias %q: no certificate", name)
+			}
+			der, err := jksRecoverKey(protected, pw)
+			if err != nil {
+				return nil, fmt.Errorf("jks: alias %q: %w", name, err)
+			}
+			return newKey(der, certs[0])
+		case jksTrusted:
+			if _, err := jksReadCert(r, version); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("jks: unsupported entry type %d", tag)
+		}
+	}
+	return nil, fmt.Errorf("jks: alias %q not found (keystore contains %q)", alias, aliases)
+}
+
+// writeJKS writes a JKS keystore with a single private key entry. The key
+// password is the same as the store password.
+func writeJKS(w io.Writer, k *Key, alias, password string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("jks: %w", err)
+	}
+	pw := jksPassword(password)
+
+	protected, err := jksProtectKey(der, pw)
+	if err != nil {
+		return fmt.Errorf("jks: %w", err)
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(jksMagic))
+	binary.Write(&b, binary.BigEndian, uint32(jksVersion))
+	binary.Write(&b, binary.BigEndian, uint32(1))
+	binary.Write(&b, binary.BigEndian, uint32(jksPrivateKey))
+	alias = strings.ToLower(alias) // aliases are case-insensitive
+	binary.Write(&b, binary.BigEndian, uint16(len(alias)))
+	b.WriteString(alias)
+	binary.Write(&b, binary.BigEndian, uint64(time.Now().UnixMilli()))
+	binary.Write(&b, binary.BigEndian, uint32(len(protected)))
+	b.Write(protected)
+	binary.Write(&b, binary.BigEndian, uint32(1))
+	binary.Write(&b, binary.BigEndian, uint16(len("X.509")))
+	b.WriteString("X.509")
+	binary.Write(&b, binary.BigEndian, uint32(len(k.Certificate.Raw)))
+	b.Write(k.Certificate.Raw)
+	b.Write(jksDigest(pw, b.Bytes()))
+
+	_, err = b.WriteTo(w)
+	return err
+}
+
+// jksRecoverKey decrypts a private key protected by the Sun key protector.
+func jksRecoverKey(protected, pw []byte) ([]byte, error) {
+	var info jksEncryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(protected, &info); err != nil {
+		return nil, fmt.Errorf("parse protected key: %w", err)
+	} else if len(rest) != 0 {
+		return nil, errors.New("parse protected key: trailing data")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidJKSKeyProtector) {
+		return nil, fmt.Errorf("unsupported key protection algorithm %s", info.Algorithm.Algorithm)
+	}
+	enc := info.EncryptedData
+	if len(enc) < 2*sha1.Size {
+		return nil, errors.New("parse protected key: too short")
+	}
+	salt, key, check := enc[:sha1.Size], enc[sha1.Size:len(enc)-sha1.Size], enc[len(enc)-sha1.Size:]
+
+	der := jksKeystream(key, salt, pw)
+	h := sha1.New()
+	h.Write(pw)
+	h.Write(der)
+	if subtle.ConstantTimeCompare(h.Sum(nil), check) != 1 {
+		return nil, errors.New("incorrect key password")
+	}
+	return der, nil
+}
+
+// jksProtectKey encrypts a private key with the Sun key protector.
+func jksProtectKey(der, pw []byte) ([]byte, error) {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	h := sha1.New()
+	h.Write(pw)
+	h.Write(der)
+
+	enc := append(salt, jksKeystream(der, salt, pw)...)
+	enc = h.Sum(enc)
+
+	return asn1.Marshal(jksEncryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidJKSKeyProtector,
+			Parameters: asn1.NullRawValue,
+		},
+		EncryptedData: enc,
+	})
+}
+
+// jksKeystream XORs buf with the key protector's keystream.
+func jksKeystream(buf, salt, pw []byte) []byte {
+	var (
+		out    = make([]byte, len(buf))
+		digest = salt
+	)
+	for i := 0; i < len(buf); i += sha1.Size {
+		h := sha1.New()
+		h.Write(pw)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		subtle.XORBytes(out[i:], buf[i:], digest)
+	}
+	return out
+}
+
+// jksDigest computes the keystore integrity check.
+func jksDigest(pw, body []byte) []byte {
+	h := sha1.New()
+	h.Write(pw)
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// jksPassword encodes the password like a Java char array.
+func jksPassword(password string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(password)) {
+		b = binary.BigEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+func jksRead(r io.Reader, v ...any) error {
+	for _, x := range v {
+		if err := binary.Read(r, binary.BigEndian, x); err != nil {
+			return fmt.Errorf("jks: %w", err)
+		}
+	}
+	return nil
+}
+
+func jksReadUTF(r io.Reader) (string, error) {
+	var n uint16
+	if err := jksRead(r, &n); err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", fmt.Errorf("jks: %w", err)
+	}
+	return string(b), nil
+}
+
+func jksReadBytes(r *bytes.Reader) ([]byte, error) {
+	var n uint32
+	if err := jksRead(r, &n); err != nil {
+		return nil, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, fmt.Errorf("jks: %w", io.ErrUnexpectedEOF)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("jks: %w", err)
+	}
+	return b, nil
+}
+
+func jksReadCert(r *bytes.Reader, version uint32) ([]byte, error) {
+	if version == 2 {
+		typ, err := jksReadUTF(r)
+		if err != nil {
+			return nil, err
+		}
+		if typ != "X.509" {
+			return nil, fmt.Errorf("jks: unsupported certificate type %q", typ)
+		}
+	}
+	return jksReadBytes(r)
+}
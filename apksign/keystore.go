@@ -0,0 +1,170 @@
+// Package apksign signs APKs using the APK Signature Scheme v2 and v3.
+package apksign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Key is a signing key.
+type Key struct {
+	PrivateKey  crypto.Signer
+	Certificate *x509.Certificate
+}
+
+// newKey parses a PKCS#8 private key and the corresponding certificate.
+func newKey(key, cert []byte) (*Key, error) {
+	pk, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	c, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate: %w", err)
+	}
+	return checkKey(pk, c)
+}
+
+// checkKey ensures the private key is supported and matches the certificate.
+func checkKey(pk any, cert *x509.Certificate) (*Key, error) {
+	switch pk := pk.(type) {
+	case *rsa.PrivateKey:
+		if !pk.PublicKey.Equal(cert.PublicKey) {
+			return nil, errors.New("private key does not match certificate")
+		}
+		return &Key{pk, cert}, nil
+	case *ecdsa.PrivateKey:
+		if !pk.PublicKey.Equal(cert.PublicKey) {
+			return nil, errors.New("private key does not match certificate")
+		}
+		return &Key{pk, cert}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T (must be RSA or ECDSA)", pk)
+	}
+}
+
+// LoadKeystore loads a key from a JKS or PKCS#12 keystore. The key password
+// must be the same as the store password.
+func LoadKeystore(name, alias, password string) (*Key, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if isJKS(buf) {
+		return readJKS(buf, alias, password)
+	}
+	return readPKCS12(buf, alias, password)
+}
+
+// readPKCS12 reads the private key from a PKCS#12 keystore. Only keystores
+// with a single private key are supported.
+func readPKCS12(buf []byte, alias, password string) (*Key, error) {
+	pk, cert, _, err := pkcs12.DecodeChain(buf, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// DecodeChain doesn't expose the aliases, but we can still check them
+	// if the entries can be converted to PEM
+	if bs, err := pkcs12.ToPEM(buf, password); err == nil {
+		var aliases []string
+		for _, b := range bs {
+			if n, ok := b.Headers["friendlyName"]; ok && b.Type == "PRIVATE KEY" {
+				aliases = append(aliases, n)
+			}
+		}
+		if len(aliases) != 0 && !strings.EqualFold(aliases[0], alias) {
+			return nil, fmt.Errorf("pkcs12: alias %q not found (keystore contains %q)", alias, aliases)
+		}
+	}
+
+	k, err := checkKey(pk, cert)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs12: %w", err)
+	}
+	return k, nil
+}
+
+// GenerateKeystore generates a self-signed 2048-bit RSA key with the specified
+// subject common name and validity, then writes it to a new JKS keystore.
+func GenerateKeystore(name, alias, password, cn string, validity time.Duration) (*Key, error) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63))
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:       serial,
+		Subject:            pkix.Name{CommonName: cn},
+		NotBefore:          now,
+		NotAfter:           now.Add(validity),
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &pk.PublicKey, pk)
+	if err != nil {
+		return nil, fmt.Errorf("generate certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("generate certificate: %w", err)
+	}
+	k := &Key{pk, cert}
+
+	var b bytes.Buffer
+	if err := writeJKS(&b, k, alias, password); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := b.WriteTo(f); err != nil {
+		return nil, err
+	}
+	return k, f.Close()
+}
+
+// Fingerprint gets the SHA1 fingerprint of the certificate in the same format
+// as keytool (e.g., 06:7D:43:...).
+func (k *Key) Fingerprint() string {
+	sum := sha1.Sum(k.Certificate.Raw)
+	return fingerprint(sum[:])
+}
+
+// FingerprintSHA256 is like Fingerprint, but gets the SHA-256 fingerprint.
+func (k *Key) FingerprintSHA256() string {
+	sum := sha256.Sum256(k.Certificate.Raw)
+	return fingerprint(sum[:])
+}
+
+func fingerprint(sum []byte) string {
+	var b strings.Builder
+	for i, x := range sum {
+		if i != 0 {
+			b.WriteByte(':')
+		}
+		fmt.Fprintf(&b, "%02X", x)
+	}
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package apksign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestKeystore(t *testing.T) {
+	dir := t.TempDir()
+
+	rk, err := GenerateKeystore(filepath.Join(dir, "rsa.jks"), "Default", "password", "Test", time.Hour)
+	if err != nil {
+		t.Fatalf("generate keystore: %v", err)
+	}
+	if _, err := GenerateKeystore(filepath.Join(dir, "rsa.jks"), "default", "password", "Test", time.Hour); err == nil {
+		t.Errorf("expected error when generating over an existing keystore")
+	}
+
+	ek := testECDSAKey(t)
+	writeFile := func(name string, fn func() ([]byte, error)) {
+		buf, err := fn()
+		if err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), buf, 0600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	writeFile("ecdsa.jks", func() ([]byte, error) {
+		var b bytes.Buffer
+		err := writeJKS(&b, ek, "default", "password")
+		return b.Bytes(), err
+	})
+	writeFile("rsa.p12", func() ([]byte, error) {
+		return pkcs12.Modern.Encode(rk.PrivateKey, rk.Certificate, nil, "password")
+	})
+	writeFile("ecdsa.p12", func() ([]byte, error) {
+		return pkcs12.Modern.Encode(ek.PrivateKey, ek.Certificate, nil, "password")
+	})
+
+	for _, tc := range []struct {
+		name     string
+		file     string
+		alias    string
+		password string
+		key      *Key
+		err      bool
+	}{
+		{"JKS RSA", "rsa.jks", "default", "password", rk, false},
+		{"JKS RSA alias case", "rsa.jks", "DEFAULT", "password", rk, false},
+		{"JKS RSA wrong password", "rsa.jks", "default", "wrong", nil, true},
+		{"JKS RSA wrong alias", "rsa.jks", "other", "password", nil, true},
+		{"JKS ECDSA", "ecdsa.jks", "default", "password", ek, false},
+		{"PKCS12 RSA", "rsa.p12", "default", "password", rk, false},
+		{"PKCS12 ECDSA", "ecdsa.p12", "default", "password", ek, false},
+		{"PKCS12 wrong password", "rsa.p12", "default", "wrong", nil, true},
+		{"missing", "missing.jks", "default", "password", nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			k, err := LoadKeystore(filepath.Join(dir, tc.file), tc.alias, tc.password)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("load keystore: %v", err)
+			}
+			if !k.Certificate.Equal(tc.key.Certificate) {
+				t.Errorf("certificate mismatch")
+			}
+			if !k.PrivateKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(tc.key.PrivateKey.Public()) {
+				t.Errorf("private key mismatch")
+			}
+			if a, b := k.FingerprintSHA256(), tc.key.FingerprintSHA256(); a != b {
+				t.Errorf("fingerprint mismatch: %s != %s", a, b)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	for _, tc := range []struct {
+		sum []byte
+		exp string
+	}{
+		{nil, ""},
+		{[]byte{0x06}, "06"},
+		{[]byte{0x06, 0x7d, 0x43, 0xff}, "06:7D:43:FF"},
+	} {
+		if act := fingerprint(tc.sum); act != tc.exp {
+			t.Errorf("fingerprint(%x): expected %q, got %q", tc.sum, tc.exp, act)
+		}
+	}
+}
+
+// testECDSAKey generates a self-signed P-256 key.
+func testECDSAKey(t *testing.T) *Key {
+	t.Helper()
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &pk.PublicKey, pk)
+	if err != nil {
+		t.Fatalf("generate certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("generate certificate: %v", err)
+	}
+	return &Key{pk, cert}
+}
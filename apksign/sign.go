@@ -0,0 +1,245 @@
+package apksign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// https://source.android.com/docs/security/features/apksigning/v2
+// https://source.android.com/docs/security/features/apksigning/v3
+
+const (
+	blockMagic = "APK Sig Block 42"
+
+	blockIDv2 = 0x7109871a
+	blockIDv3 = 0xf05368c0
+
+	sigRSAPKCS1SHA256 = 0x0103
+	sigECDSASHA256    = 0x0201
+
+	attrStrippingProtection = 0xbeeff00d
+
+	chunkSize = 1 << 20
+
+	v3MinSDK = 28 // v3 is only verified on Android 9+
+	v3MaxSDK = 0x7fffffff
+)
+
+// SignFile signs an APK, writing the signed APK to out. Any existing v2+
+// signatures are replaced. The APK should already be zipaligned.
+func SignFile(out, in string, k *Key) error {
+	buf, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := Sign(f, buf, k); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Sign signs an APK using the APK Signature Scheme v2 and v3. Any existing v2+
+// signatures are replaced. The APK should already be zipaligned.
+func Sign(w io.Writer, apk []byte, k *Key) error {
+	entries, cd, eocd, err := splitZip(apk)
+	if err != nil {
+		return err
+	}
+
+	alg, err := sigAlgorithm(k)
+	if err != nil {
+		return err
+	}
+
+	// the digest is computed as if the central directory immediately
+	// followed the entries
+	eocd = bytes.Clone(eocd)
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(len(entries)))
+	digest := contentDigest(entries, cd, eocd)
+
+	v2, err := k.signer(alg, digest, false)
+	if err != nil {
+		return fmt.Errorf("sign v2: %w", err)
+	}
+	v3, err := k.signer(alg, digest, true)
+	if err != nil {
+		return fmt.Errorf("sign v3: %w", err)
+	}
+	block := signingBlock(map[uint32][]byte{
+		blockIDv2: lp(lp(v2)),
+		blockIDv3: lp(lp(v3)),
+	})
+
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(len(entries)+len(block)))
+
+	for _, b := range [][]byte{entries, block, cd, eocd} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitZip splits a zip file into the entries, central directory, and end of
+// central directory, removing the APK signing block if present.
+func splitZip(buf []byte) (entries, cd, eocd []byte, err error) {
+	off := -1
+	for i := len(buf) - 22; i >= 0 && i >= len(buf)-22-0xffff; i-- {
+		if binary.LittleEndian.Uint32(buf[i:]) == 0x06054b50 && i+22+int(binary.LittleEndian.Uint16(buf[i+20:])) == len(buf) {
+			off = i
+			break
+		}
+	}
+	if off == -1 {
+		return nil, nil, nil, errors.New("invalid zip: end of central directory not found")
+	}
+	eocd = buf[off:]
+
+	var (
+		cdSize = int64(binary.LittleEndian.Uint32(eocd[12:]))
+		cdOff  = int64(binary.LittleEndian.Uint32(eocd[16:]))
+	)
+	if cdSize == 0xffffffff || cdOff == 0xffffffff {
+		return nil, nil, nil, errors.New("invalid zip: zip64 is not supported")
+	}
+	if cdOff+cdSize != int64(off) {
+		return nil, nil, nil, errors.New("invalid zip: central directory is not immediately followed by the end of central directory")
+	}
+	cd = buf[cdOff:off]
+	entries = buf[:cdOff]
+
+	if n := len(entries); n >= 32 && string(entries[n-16:]) == blockMagic {
+		size := binary.LittleEndian.Uint64(entries[n-24:])
+		if size < 24 || size+8 > uint64(n) || binary.LittleEndian.Uint64(entries[n-int(size)-8:]) != size {
+			return nil, nil, nil, errors.New("invalid apk: invalid signing block")
+		}
+		entries = entries[:n-int(size)-8]
+	}
+	return entries, cd, eocd, nil
+}
+
+// contentDigest computes the chunked SHA-256 digest of the APK contents.
+func contentDigest(sections ...[]byte) []byte {
+	var (
+		n    uint32
+		sums []byte
+		tmp  [5]byte
+	)
+	for _, s := range sections {
+		for len(s) != 0 {
+			c := s[:min(len(s), chunkSize)]
+			s = s[len(c):]
+
+			tmp[0] = 0xa5
+			binary.LittleEndian.PutUint32(tmp[1:], uint32(len(c)))
+
+			h := sha256.New()
+			h.Write(tmp[:])
+			h.Write(c)
+			sums = h.Sum(sums)
+			n++
+		}
+	}
+	tmp[0] = 0x5a
+	binary.LittleEndian.PutUint32(tmp[1:], n)
+
+	h := sha256.New()
+	h.Write(tmp[:])
+	h.Write(sums)
+	return h.Sum(nil)
+}
+
+// sigAlgorithm gets the signature algorithm ID for the key.
+func sigAlgorithm(k *Key) (uint32, error) {
+	switch k.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		return sigRSAPKCS1SHA256, nil
+	case *ecdsa.PrivateKey:
+		return sigECDSASHA256, nil
+	default:
+		return 0, fmt.Errorf("unsupported private key type %T", k.PrivateKey)
+	}
+}
+
+// signer generates a v2 or v3 signer.
+func (k *Key) signer(alg uint32, digest []byte, v3 bool) ([]byte, error) {
+	var attrs []byte
+	if !v3 {
+		// tells v2 verifiers that the APK is also signed with v3, so the v3
+		// signature can't be stripped
+		attrs = lp(u32(attrStrippingProtection), u32(3))
+	}
+	data := lp(lp(u32(alg), lp(digest)))
+	data = append(data, lp(lp(k.Certificate.Raw))...)
+	if v3 {
+		data = append(data, u32(v3MinSDK)...)
+		data = append(data, u32(v3MaxSDK)...)
+	}
+	data = append(data, lp(attrs)...)
+
+	h := sha256.Sum256(data)
+	sig, err := k.PrivateKey.Sign(rand.Reader, h[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	s := lp(data)
+	if v3 {
+		s = append(s, u32(v3MinSDK)...)
+		s = append(s, u32(v3MaxSDK)...)
+	}
+	s = append(s, lp(lp(u32(alg), lp(sig)))...)
+	s = append(s, lp(k.Certificate.RawSubjectPublicKeyInfo)...)
+	return s, nil
+}
+
+// signingBlock generates an APK signing block.
+func signingBlock(values map[uint32][]byte) []byte {
+	var pairs []byte
+	for _, id := range []uint32{blockIDv2, blockIDv3} {
+		if v, ok := values[id]; ok {
+			pairs = binary.LittleEndian.AppendUint64(pairs, uint64(4+len(v)))
+			pairs = append(pairs, u32(id)...)
+			pairs = append(pairs, v...)
+		}
+	}
+	size := uint64(len(pairs) + 8 + len(blockMagic))
+
+	var b []byte
+	b = binary.LittleEndian.AppendUint64(b, size)
+	b = append(b, pairs...)
+	b = binary.LittleEndian.AppendUint64(b, size)
+	b = append(b, blockMagic...)
+	return b
+}
+
+// lp concatenates bs and prefixes the result with its uint32 length.
+func lp(bs ...[]byte) []byte {
+	var n int
+	for _, b := range bs {
+		n += len(b)
+	}
+	r := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+n), uint32(n))
+	for _, b := range bs {
+		r = append(r, b...)
+	}
+	return r
+}
+
+func u32(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
@@ -0,0 +1,299 @@
+package apksign
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	rk, err := GenerateKeystore(filepath.Join(t.TempDir(), "test.jks"), "default", "password", "Test", time.Hour)
+	if err != nil {
+		t.Fatalf("generate keystore: %v", err)
+	}
+	ek := testECDSAKey(t)
+
+	for _, tc := range []struct {
+		name  string
+		key   *Key
+		files map[string]string
+		large bool
+	}{
+		{"RSA", rk, map[string]string{"AndroidManifest.xml": "manifest", "classes.dex": "dex"}, false},
+		{"ECDSA", ek, map[string]string{"AndroidManifest.xml": "manifest", "classes.dex": "dex"}, false},
+		{"Empty", rk, nil, false},
+		{"MultipleChunks", rk, map[string]string{"AndroidManifest.xml": "manifest"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			apk := testZip(t, tc.files, tc.large)
+
+			var signed bytes.Buffer
+			if err := Sign(&signed, apk, tc.key); err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+			if err := verifyAPK(signed.Bytes(), tc.key); err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if _, err := zip.NewReader(bytes.NewReader(signed.Bytes()), int64(signed.Len())); err != nil {
+				t.Errorf("signed apk is not a valid zip: %v", err)
+			}
+
+			// re-signing should replace the existing signature
+			var resigned bytes.Buffer
+			if err := Sign(&resigned, signed.Bytes(), tc.key); err != nil {
+				t.Fatalf("re-sign: %v", err)
+			}
+			if err := verifyAPK(resigned.Bytes(), tc.key); err != nil {
+				t.Fatalf("verify re-signed: %v", err)
+			}
+			n := binary.LittleEndian.Uint32(apk[len(apk)-6:]) // central directory offset
+			if a, b := signed.Bytes()[:n], resigned.Bytes()[:n]; !bytes.Equal(a, b) {
+				t.Errorf("re-signed apk has different entries")
+			}
+
+			// the signature should be for the key
+			other := rk
+			if tc.key == rk {
+				other = ek
+			}
+			if err := verifyAPK(signed.Bytes(), other); err == nil {
+				t.Errorf("expected verification with a different key to fail")
+			}
+
+			// modifying the contents should invalidate the signature
+			tampered := bytes.Clone(signed.Bytes())
+			tampered[0] ^= 0xff
+			if err := verifyAPK(tampered, tc.key); err == nil {
+				t.Errorf("expected tampered apk to fail verification")
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		if err := Sign(io.Discard, []byte("not a zip"), rk); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
+
+// testZip creates a zip file with the specified files (and a large file which
+// spans multiple digest chunks, if requested).
+func testZip(t *testing.T, files map[string]string, large bool) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, data := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatalf("create zip: %v", err)
+		}
+		io.WriteString(w, data)
+	}
+	if large {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "assets/large", Method: zip.Store})
+		if err != nil {
+			t.Fatalf("create zip: %v", err)
+		}
+		w.Write(bytes.Repeat([]byte{0x55}, chunkSize*2+123))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("create zip: %v", err)
+	}
+	return b.Bytes()
+}
+
+// verifyAPK checks the v2 and v3 signatures of an APK according to the spec.
+func verifyAPK(apk []byte, k *Key) error {
+	if len(apk) < 22 {
+		return errors.New("too short")
+	}
+	eocdOff := len(apk) - 22 // no comment
+	if binary.LittleEndian.Uint32(apk[eocdOff:]) != 0x06054b50 {
+		return errors.New("eocd not found")
+	}
+	eocd := apk[eocdOff:]
+	cdOff := int(binary.LittleEndian.Uint32(eocd[16:]))
+	if cdOff < 32 || string(apk[cdOff-16:cdOff]) != blockMagic {
+		return errors.New("signing block not found")
+	}
+	size := int(binary.LittleEndian.Uint64(apk[cdOff-24:]))
+	blockOff := cdOff - size - 8
+	if blockOff < 0 || int(binary.LittleEndian.Uint64(apk[blockOff:])) != size {
+		return errors.New("invalid signing block size")
+	}
+
+	// digest the contents as if the block wasn't there
+	eocd = bytes.Clone(eocd)
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(blockOff))
+	digest := testDigest(apk[:blockOff], apk[cdOff:eocdOff], eocd)
+
+	pairs := map[uint32][]byte{}
+	for p := apk[blockOff+8 : cdOff-24]; len(p) != 0; {
+		if len(p) < 12 {
+			return errors.New("invalid signing block pair")
+		}
+		n := int(binary.LittleEndian.Uint64(p))
+		if n < 4 || 8+n > len(p) {
+			return errors.New("invalid signing block pair length")
+		}
+		pairs[binary.LittleEndian.Uint32(p[8:])] = p[12 : 8+n]
+		p = p[8+n:]
+	}
+	for _, id := range []uint32{blockIDv2, blockIDv3} {
+		v, ok := pairs[id]
+		if !ok {
+			return fmt.Errorf("block %#x: not found", id)
+		}
+		if err := verifySigners(v, id == blockIDv3, digest, k); err != nil {
+			return fmt.Errorf("block %#x: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func verifySigners(v []byte, v3 bool, digest []byte, k *Key) error {
+	r := &lpReader{v}
+	signers := &lpReader{r.next()}
+	signer := &lpReader{signers.next()}
+	if signers.err() != nil || len(signers.b) != 0 {
+		return errors.New("expected exactly one signer")
+	}
+
+	signedData := signer.next()
+	if v3 {
+		if min, max := signer.u32(), signer.u32(); min != v3MinSDK || max != v3MaxSDK {
+			return fmt.Errorf("unexpected sdk range %d-%d", min, max)
+		}
+	}
+	sigs := &lpReader{signer.next()}
+	pub := signer.next()
+	if signer.err() != nil {
+		return signer.err()
+	}
+	if !bytes.Equal(pub, k.Certificate.RawSubjectPublicKeyInfo) {
+		return errors.New("public key mismatch")
+	}
+
+	sig := &lpReader{sigs.next()}
+	alg, sigData := sig.u32(), sig.next()
+	if sig.err() != nil {
+		return sig.err()
+	}
+	h := sha256.Sum256(signedData)
+	switch pk := k.Certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if alg != sigRSAPKCS1SHA256 {
+			return fmt.Errorf("unexpected algorithm %#x", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pk, crypto.SHA256, h[:], sigData); err != nil {
+			return fmt.Errorf("verify signature: %w", err)
+		}
+	case *ecdsa.PublicKey:
+		if alg != sigECDSASHA256 {
+			return fmt.Errorf("unexpected algorithm %#x", alg)
+		}
+		if !ecdsa.VerifyASN1(pk, h[:], sigData) {
+			return errors.New("verify signature: invalid signature")
+		}
+	}
+
+	sd := &lpReader{signedData}
+	digests := &lpReader{sd.next()}
+	d := &lpReader{digests.next()}
+	dalg, dval := d.u32(), d.next()
+	certs := &lpReader{sd.next()}
+	cert := certs.next()
+	if v3 {
+		if min, max := sd.u32(), sd.u32(); min != v3MinSDK || max != v3MaxSDK {
+			return fmt.Errorf("unexpected signed sdk range %d-%d", min, max)
+		}
+	}
+	attrs := sd.next()
+	if err := errors.Join(sd.err(), d.err(), certs.err()); err != nil {
+		return err
+	}
+	if dalg != alg {
+		return fmt.Errorf("digest algorithm %#x doesn't match signature algorithm %#x", dalg, alg)
+	}
+	if !bytes.Equal(dval, digest) {
+		return errors.New("content digest mismatch")
+	}
+	if !bytes.Equal(cert, k.Certificate.Raw) {
+		return errors.New("certificate mismatch")
+	}
+	if !v3 {
+		a := &lpReader{attrs}
+		attr := &lpReader{a.next()}
+		if id, ver := attr.u32(), attr.u32(); attr.err() != nil || id != attrStrippingProtection || ver != 3 {
+			return errors.New("missing stripping protection attribute")
+		}
+	}
+	return nil
+}
+
+// testDigest computes the chunked SHA-256 content digest.
+func testDigest(sections ...[]byte) []byte {
+	var sums [][]byte
+	for _, s := range sections {
+		for i := 0; i < len(s); i += chunkSize {
+			c := s[i:min(i+chunkSize, len(s))]
+			h := sha256.New()
+			h.Write([]byte{0xa5})
+			binary.Write(h, binary.LittleEndian, uint32(len(c)))
+			h.Write(c)
+			sums = append(sums, h.Sum(nil))
+		}
+	}
+	h := sha256.New()
+	h.Write([]byte{0x5a})
+	binary.Write(h, binary.LittleEndian, uint32(len(sums)))
+	for _, s := range sums {
+		h.Write(s)
+	}
+	return h.Sum(nil)
+}
+
+// lpReader reads uint32 length-prefixed values.
+type lpReader struct {
+	b []byte
+}
+
+var errShort = errors.New("unexpected end of data")
+
+func (r *lpReader) u32() uint32 {
+	if len(r.b) < 4 {
+		r.b = nil
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *lpReader) next() []byte {
+	n := int(r.u32())
+	if r.b == nil || n > len(r.b) {
+		r.b = nil
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *lpReader) err() error {
+	if r.b == nil {
+		return errShort
+	}
+	return nil
+}
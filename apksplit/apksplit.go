@@ -0,0 +1,274 @@
+// Package apksplit reads split APK sets and merges them into a single APK.
+package apksplit
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Set is a base APK and its config splits.
+type Set struct {
+	Package string
+	Base    *APK
+	Splits  []*APK // sorted by split name
+}
+
+// APK is an APK in a Set.
+type APK struct {
+	Name  string // filename in the bundle or directory
+	Split string // split name, empty for the base APK
+	Data  []byte
+}
+
+// IsBundle checks if name is a directory or has the extension of a split APK
+// bundle (.apks, .apkm, or .xapk).
+func IsBundle(name string) bool {
+	if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".apks", ".apkm", ".xapk":
+		return true
+	}
+	return false
+}
+
+// Open reads a split APK bundle (a zip file containing the APKs, like .apks,
+// .apkm, or .xapk) or a directory of split APKs. The base APK is identified by
+// its manifest. Only config splits of the base APK are supported, and
+// standalone APKs in bundletool's .apks files are ignored.
+func Open(name string) (*Set, error) {
+	var apks []*APK
+	if fi, err := os.Stat(name); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		es, err := os.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range es {
+			if e.Type().IsRegular() && strings.EqualFold(filepath.Ext(e.Name()), ".apk") {
+				buf, err := os.ReadFile(filepath.Join(name, e.Name()))
+				if err != nil {
+					return nil, err
+				}
+				apks = append(apks, &APK{Name: e.Name(), Data: buf})
+			}
+		}
+	} else {
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, fmt.Errorf("read bundle: %w", err)
+		}
+		defer zr.Close()
+
+		for _, zf := range zr.File {
+			if !strings.EqualFold(path.Ext(zf.Name), ".apk") || strings.HasPrefix(zf.Name, "standalones/") {
+				continue
+			}
+			buf, err := readZipFile(zf)
+			if err != nil {
+				return nil, fmt.Errorf("read bundle: %w", err)
+			}
+			apks = append(apks, &APK{Name: path.Base(zf.Name), Data: buf})
+		}
+	}
+	if len(apks) == 0 {
+		return nil, fmt.Errorf("no apks found in %q", name)
+	}
+	return newSet(apks)
+}
+
+func newSet(apks []*APK) (*Set, error) {
+	s := new(Set)
+	for _, a := range apks {
+		zr, err := zip.NewReader(bytes.NewReader(a.Data), int64(len(a.Data)))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", a.Name, err)
+		}
+		buf, err := readZip(zr, "AndroidManifest.xml")
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", a.Name, err)
+		}
+		pkg, split, err := manifestInfo(buf)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: manifest: %w", a.Name, err)
+		}
+		if s.Package == "" {
+			s.Package = pkg
+		} else if pkg != s.Package {
+			return nil, fmt.Errorf("%s is for package %q, not %q", a.Name, pkg, s.Package)
+		}
+		switch a.Split = split; {
+		case split == "":
+			if s.Base != nil {
+				return nil, fmt.Errorf("multiple base apks (%s, %s)", s.Base.Name, a.Name)
+			}
+			s.Base = a
+		case strings.HasPrefix(split, "config."):
+			s.Splits = append(s.Splits, a)
+		default:
+			return nil, fmt.Errorf("%s: feature split %q is not supported", a.Name, split)
+		}
+	}
+	if s.Base == nil {
+		return nil, fmt.Errorf("no base apk found")
+	}
+	slices.SortFunc(s.Splits, func(a, b *APK) int {
+		return cmp.Compare(a.Split, b.Split)
+	})
+	return s, nil
+}
+
+// Merge writes a single APK containing the base APK and all config splits. The
+// resource tables are merged, split-related attributes are removed from the
+// manifest, and the files from the splits are added. Existing signatures are
+// removed, and the output is not zipaligned.
+func (s *Set) Merge(w io.Writer) error {
+	base, err := zip.NewReader(bytes.NewReader(s.Base.Data), int64(len(s.Base.Data)))
+	if err != nil {
+		return fmt.Errorf("read %s: %w", s.Base.Name, err)
+	}
+	splits := make([]*zip.Reader, len(s.Splits))
+	for i, a := range s.Splits {
+		if splits[i], err = zip.NewReader(bytes.NewReader(a.Data), int64(len(a.Data))); err != nil {
+			return fmt.Errorf("read %s: %w", a.Name, err)
+		}
+	}
+
+	zw := zip.NewWriter(w)
+	seen := map[string]bool{}
+	for _, zf := range base.File {
+		if isSignature(zf.Name) || seen[zf.Name] {
+			continue
+		}
+		seen[zf.Name] = true
+
+		var buf []byte
+		switch zf.Name {
+		case "AndroidManifest.xml":
+			if buf, err = readZipFile(zf); err == nil {
+				buf, err = fixManifest(buf)
+			}
+		case "resources.arsc":
+			if buf, err = readZipFile(zf); err == nil {
+				buf, err = mergeTables(buf, s.Splits, splits)
+			}
+		default:
+			if err := copyZipFile(zw, zf); err != nil {
+				return fmt.Errorf("copy %s: %w", zf.Name, err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("merge %s: %w", zf.Name, err)
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     zf.Name,
+			Method:   zf.Method,
+			Modified: zf.Modified,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(buf); err != nil {
+			return err
+		}
+	}
+	for i, zr := range splits {
+		for _, zf := range zr.File {
+			if zf.Name == "AndroidManifest.xml" || zf.Name == "resources.arsc" || strings.HasPrefix(zf.Name, "META-INF/") || isSignature(zf.Name) || seen[zf.Name] {
+				continue
+			}
+			seen[zf.Name] = true
+			if err := copyZipFile(zw, zf); err != nil {
+				return fmt.Errorf("copy %s from %s: %w", zf.Name, s.Splits[i].Name, err)
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// mergeTables merges the resource tables from the splits into the base table.
+func mergeTables(buf []byte, apks []*APK, splits []*zip.Reader) ([]byte, error) {
+	t, err := readTable(buf)
+	if err != nil {
+		return nil, err
+	}
+	for i, zr := range splits {
+		buf, err := readZip(zr, "resources.arsc")
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // e.g., abi splits
+			}
+			return nil, fmt.Errorf("%s: %w", apks[i].Name, err)
+		}
+		st, err := readTable(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", apks[i].Name, err)
+		}
+		if err := t.merge(st); err != nil {
+			return nil, fmt.Errorf("%s: %w", apks[i].Name, err)
+		}
+	}
+	return t.bytes()
+}
+
+// isSignature checks if name is part of a JAR signature or source stamp.
+func isSignature(name string) bool {
+	if name == "stamp-cert-sha256" {
+		return true
+	}
+	dir, base := path.Split(name)
+	if dir != "META-INF/" {
+		return false
+	}
+	switch ext := strings.ToUpper(path.Ext(base)); {
+	case base == "MANIFEST.MF":
+		return true
+	case ext == ".SF", ext == ".RSA", ext == ".DSA", ext == ".EC":
+		return true
+	case strings.HasPrefix(strings.ToUpper(base), "SIG-"):
+		return true
+	}
+	return false
+}
+
+func readZip(zr *zip.Reader, name string) ([]byte, error) {
+	for _, zf := range zr.File {
+		if zf.Name == name {
+			return readZipFile(zf)
+		}
+	}
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+func readZipFile(zf *zip.File) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func copyZipFile(zw *zip.Writer, zf *zip.File) error {
+	rc, err := zf.OpenRaw()
+	if err != nil {
+		return err
+	}
+	w, err := zw.CreateRaw(&zf.FileHeader)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, rc)
+	return err
+}
@@ -0,0 +1,259 @@
+package apksplit
+
+import (
+	"archive/zip"
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testAPK builds a zip with the specified files. Entries ending in .so are
+// stored, and the rest are compressed.
+func testAPK(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		method := zip.Deflate
+		if strings.HasSuffix(name, ".so") {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatalf("create apk: %v", err)
+		}
+		w.Write(files[name])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("create apk: %v", err)
+	}
+	return b.Bytes()
+}
+
+func testBundle(t *testing.T) map[string][]byte {
+	t.Helper()
+	return map[string][]byte{
+		"base.apk": testAPK(t, map[string][]byte{
+			"AndroidManifest.xml":  testManifest(""),
+			"resources.arsc":       testTable(testTableBase()),
+			"classes.dex":          []byte("dex"),
+			"res/icon.png":         []byte("png"),
+			"META-INF/MANIFEST.MF": []byte("mf"),
+			"META-INF/CERT.SF":     []byte("sf"),
+			"META-INF/CERT.RSA":    []byte("rsa"),
+			"META-INF/services/x":  []byte("service"),
+			"stamp-cert-sha256":    []byte("stamp"),
+		}),
+		"split_config.fr.apk": testAPK(t, map[string][]byte{
+			"AndroidManifest.xml": testManifest("config.fr"),
+			"resources.arsc":      testTable(testTableSplit("fr")),
+			"META-INF/CERT.RSA":   []byte("rsa"),
+		}),
+		"split_config.arm64_v8a.apk": testAPK(t, map[string][]byte{
+			"AndroidManifest.xml":      testManifest("config.arm64_v8a"),
+			"lib/arm64-v8a/libtest.so": []byte("so"),
+			"META-INF/services/y":      []byte("ignored"),
+			"res/icon.png":             []byte("ignored"),
+		}),
+	}
+}
+
+func TestOpen(t *testing.T) {
+	apks := testBundle(t)
+
+	dir := t.TempDir()
+	for name, buf := range apks {
+		if err := os.WriteFile(filepath.Join(dir, name), buf, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "README.txt"), []byte("not an apk"), 0666)
+
+	var zb bytes.Buffer
+	zw := zip.NewWriter(&zb)
+	for _, name := range []string{"base.apk", "split_config.fr.apk", "split_config.arm64_v8a.apk"} {
+		w, _ := zw.Create("splits/" + name)
+		w.Write(apks[name])
+	}
+	w, _ := zw.Create("standalones/standalone.apk")
+	w.Write(apks["base.apk"])
+	w, _ = zw.Create("toc.pb")
+	w.Write([]byte("toc"))
+	zw.Close()
+	bundle := filepath.Join(t.TempDir(), "test.apks")
+	if err := os.WriteFile(bundle, zb.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		path string
+	}{
+		{"Dir", dir},
+		{"Bundle", bundle},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if !IsBundle(tc.path) {
+				t.Errorf("expected %q to be a bundle", tc.path)
+			}
+			s, err := Open(tc.path)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			if s.Package != "com.example" {
+				t.Errorf("expected package com.example, got %q", s.Package)
+			}
+			if s.Base.Name != "base.apk" || s.Base.Split != "" {
+				t.Errorf("unexpected base %q (split %q)", s.Base.Name, s.Base.Split)
+			}
+			var splits []string
+			for _, a := range s.Splits {
+				splits = append(splits, a.Split)
+			}
+			if exp := []string{"config.arm64_v8a", "config.fr"}; !slices.Equal(splits, exp) {
+				t.Errorf("expected splits %q, got %q", exp, splits)
+			}
+		})
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	apks := testBundle(t)
+	for _, tc := range []struct {
+		name string
+		apks []string
+		err  string
+	}{
+		{"NoBase", []string{"split_config.fr.apk"}, "no base apk"},
+		{"MultipleBase", []string{"base.apk", "base2.apk"}, "multiple base apks"},
+		{"Empty", nil, "no apks found"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tc.apks {
+				buf, ok := apks[name]
+				if !ok {
+					buf = apks["base.apk"]
+				}
+				os.WriteFile(filepath.Join(dir, name), buf, 0666)
+			}
+			if _, err := Open(dir); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var apks []*APK
+	for name, buf := range testBundle(t) {
+		apks = append(apks, &APK{Name: name, Data: buf})
+	}
+	s, err := newSet(apks)
+	if err != nil {
+		t.Fatalf("new set: %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := s.Merge(&b); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("read merged apk: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, zf := range zr.File {
+		if _, ok := files[zf.Name]; ok {
+			t.Errorf("duplicate file %q", zf.Name)
+		}
+		buf, err := readZipFile(zf)
+		if err != nil {
+			t.Fatalf("read %s: %v", zf.Name, err)
+		}
+		files[zf.Name] = buf
+	}
+
+	var names []string
+	for _, zf := range zr.File {
+		names = append(names, zf.Name)
+	}
+	if exp := []string{
+		"AndroidManifest.xml",
+		"META-INF/services/x",
+		"classes.dex",
+		"res/icon.png",
+		"resources.arsc",
+		"lib/arm64-v8a/libtest.so",
+	}; !slices.Equal(names, exp) {
+		t.Errorf("expected files:\n\t%s\ngot:\n\t%s", strings.Join(exp, "\n\t"), strings.Join(names, "\n\t"))
+	}
+	if string(files["res/icon.png"]) != "png" {
+		t.Errorf("expected the base file to take precedence")
+	}
+	if zf := zr.File[slices.Index(names, "lib/arm64-v8a/libtest.so")]; zf.Method != zip.Store {
+		t.Errorf("expected the native library to remain stored")
+	}
+
+	if m, err := dumpXML(files["AndroidManifest.xml"]); err != nil {
+		t.Errorf("read merged manifest: %v", err)
+	} else if strings.Contains(m, "isSplitRequired") || strings.Contains(m, "com.android.vending.splits") {
+		t.Errorf("expected split requirements to be removed from the manifest, got %s", m)
+	} else if !strings.Contains(m, ` x="x"`) || !strings.Contains(m, `name="other"`) {
+		t.Errorf("expected other attributes and elements to be preserved, got %s", m)
+	}
+
+	res, err := dumpTable(files["resources.arsc"])
+	if err != nil {
+		t.Fatalf("read merged resources: %v", err)
+	}
+	if !slices.Contains(res, `7f string[fr] 0 a="Afr"`) || !slices.Contains(res, `7f string[] 0 a="A"`) {
+		t.Errorf("expected the resources from the base and config split, got:\n\t%s", strings.Join(res, "\n\t"))
+	}
+}
+
+func TestIsSignature(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		sig  bool
+	}{
+		{"META-INF/MANIFEST.MF", true},
+		{"META-INF/CERT.SF", true},
+		{"META-INF/CERT.RSA", true},
+		{"META-INF/key.dsa", true},
+		{"META-INF/KEY.EC", true},
+		{"META-INF/SIG-TEST", true},
+		{"stamp-cert-sha256", true},
+		{"META-INF/services/x", false},
+		{"META-INF/com/android/build/gradle/app-metadata.properties", false},
+		{"META-INF/kotlin.kotlin_module", false},
+		{"assets/META-INF/CERT.RSA", false},
+		{"classes.dex", false},
+	} {
+		if act := isSignature(tc.name); act != tc.sig {
+			t.Errorf("isSignature(%q): expected %t, got %t", tc.name, tc.sig, act)
+		}
+	}
+}
+
+func TestIsBundle(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		bundle bool
+	}{
+		{"test.apks", true},
+		{"test.APKM", true},
+		{"test.xapk", true},
+		{"test.apk", false},
+		{"test.zip", false},
+		{t.TempDir(), true},
+	} {
+		if act := IsBundle(tc.name); act != tc.bundle {
+			t.Errorf("IsBundle(%q): expected %t, got %t", tc.name, tc.bundle, act)
+		}
+	}
+}
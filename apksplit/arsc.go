@@ -0,0 +1,344 @@
+package apksplit
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+const (
+	typeFlagSparse   = 0x01
+	typeFlagOffset16 = 0x02
+
+	entryFlagComplex = 0x0001
+	entryFlagCompact = 0x0008
+)
+
+// table is a decoded resources.arsc. Only the parts needed for merging splits
+// are decoded.
+type table struct {
+	Strings  *stringPool
+	Packages []*tablePackage
+}
+
+// tablePackage is a package in a table.
+type tablePackage struct {
+	ID     uint32
+	Header []byte // ResTable_package header
+	Types  *stringPool
+	Keys   *stringPool
+	Chunks []chunk // type specs, types, and anything else
+}
+
+func readTable(buf []byte) (*table, error) {
+	c, err := readChunk(buf)
+	if err != nil {
+		return nil, err
+	}
+	if c.Type != chunkTable || c.HeaderSize < 12 {
+		return nil, fmt.Errorf("not a resource table")
+	}
+	cs, err := readChunks(c.Body())
+	if err != nil {
+		return nil, err
+	}
+	t := new(table)
+	for _, c := range cs {
+		switch c.Type {
+		case chunkStringPool:
+			if t.Strings != nil {
+				return nil, fmt.Errorf("multiple global string pools")
+			}
+			if t.Strings, err = readStringPool(c); err != nil {
+				return nil, err
+			}
+		case chunkTablePackage:
+			p, err := readTablePackage(c)
+			if err != nil {
+				return nil, err
+			}
+			t.Packages = append(t.Packages, p)
+		default:
+			return nil, fmt.Errorf("unsupported table chunk 0x%04x", c.Type)
+		}
+	}
+	if t.Strings == nil {
+		return nil, fmt.Errorf("missing global string pool")
+	}
+	return t, nil
+}
+
+func readTablePackage(c chunk) (*tablePackage, error) {
+	if c.HeaderSize < 284 {
+		return nil, fmt.Errorf("package: header too short")
+	}
+	var (
+		h           = c.Header()
+		p           = &tablePackage{ID: le.Uint32(h[8:]), Header: h}
+		typeStrings = int(le.Uint32(h[268:]))
+		keyStrings  = int(le.Uint32(h[276:]))
+	)
+	cs, err := readChunks(c.Body())
+	if err != nil {
+		return nil, fmt.Errorf("package 0x%02x: %w", p.ID, err)
+	}
+	off := c.HeaderSize
+	for _, x := range cs {
+		switch {
+		case off == typeStrings:
+			if p.Types, err = readStringPool(x); err != nil {
+				return nil, fmt.Errorf("package 0x%02x: type strings: %w", p.ID, err)
+			}
+		case off == keyStrings:
+			if p.Keys, err = readStringPool(x); err != nil {
+				return nil, fmt.Errorf("package 0x%02x: key strings: %w", p.ID, err)
+			}
+		default:
+			p.Chunks = append(p.Chunks, x)
+		}
+		off += len(x.Data)
+	}
+	if p.Types == nil || p.Keys == nil {
+		return nil, fmt.Errorf("package 0x%02x: missing type or key strings", p.ID)
+	}
+	return p, nil
+}
+
+func (t *table) bytes() ([]byte, error) {
+	strs, err := t.Strings.bytes()
+	if err != nil {
+		return nil, err
+	}
+	body := [][]byte{strs}
+	for _, p := range t.Packages {
+		b, err := p.bytes()
+		if err != nil {
+			return nil, fmt.Errorf("package 0x%02x: %w", p.ID, err)
+		}
+		body = append(body, b)
+	}
+	h := make([]byte, 12)
+	le.PutUint32(h[8:], uint32(len(t.Packages)))
+	return makeChunk(chunkTable, h, body...), nil
+}
+
+func (p *tablePackage) bytes() ([]byte, error) {
+	types, err := p.Types.bytes()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := p.Keys.bytes()
+	if err != nil {
+		return nil, err
+	}
+	h := bytes.Clone(p.Header)
+	le.PutUint32(h[268:], uint32(len(h)))
+	le.PutUint32(h[276:], uint32(len(h)+len(types)))
+	body := [][]byte{types, keys}
+	for _, c := range p.Chunks {
+		body = append(body, c.Data)
+	}
+	return makeChunk(chunkTablePackage, h, body...), nil
+}
+
+// merge adds the resource configurations from a config split to the table.
+// Configurations already in the table are left as-is.
+func (t *table) merge(split *table) error {
+	for _, sp := range split.Packages {
+		i := slices.IndexFunc(t.Packages, func(p *tablePackage) bool {
+			return p.ID == sp.ID
+		})
+		if i == -1 {
+			return fmt.Errorf("package 0x%02x is not in the base", sp.ID)
+		}
+		if err := t.Packages[i].merge(t.Strings, sp, split.Strings); err != nil {
+			return fmt.Errorf("package 0x%02x: %w", sp.ID, err)
+		}
+	}
+	return nil
+}
+
+func (p *tablePackage) merge(strs *stringPool, sp *tablePackage, spStrs *stringPool) error {
+	for _, c := range sp.Chunks {
+		switch c.Type {
+		case chunkTableTypeSpec:
+			if len(c.Data) < 16 {
+				return fmt.Errorf("type spec: too short")
+			}
+			id := c.Data[8]
+			i := slices.IndexFunc(p.Chunks, func(x chunk) bool {
+				return x.Type == chunkTableTypeSpec && x.Data[8] == id
+			})
+			if i == -1 {
+				return fmt.Errorf("type %d is not in the base", id)
+			}
+			// the flags indicate which configurations the entries vary by
+			var (
+				base = bytes.Clone(p.Chunks[i].Data)
+				bn   = int(le.Uint32(base[12:]))
+				sn   = int(le.Uint32(c.Data[12:]))
+			)
+			for j := 0; j < bn && j < sn; j++ {
+				bo, so := p.Chunks[i].HeaderSize+4*j, c.HeaderSize+4*j
+				if bo+4 > len(base) || so+4 > len(c.Data) {
+					return fmt.Errorf("type spec %d: truncated", id)
+				}
+				le.PutUint32(base[bo:], le.Uint32(base[bo:])|le.Uint32(c.Data[so:]))
+			}
+			p.Chunks[i].Data = base
+
+		case chunkTableType:
+			if c.HeaderSize < 24 || len(c.Data) < 24 {
+				return fmt.Errorf("type: too short")
+			}
+			id := c.Data[8]
+			config := typeConfig(c)
+			last := -1
+			for i, x := range p.Chunks {
+				if (x.Type == chunkTableTypeSpec || x.Type == chunkTableType) && x.Data[8] == id {
+					if x.Type == chunkTableType && bytes.Equal(typeConfig(x), config) {
+						last = -2 // already exists
+						break
+					}
+					last = i
+				}
+			}
+			switch last {
+			case -1:
+				return fmt.Errorf("type %d is not in the base", id)
+			case -2:
+				continue
+			}
+			nc, err := remapType(c, strs, spStrs, p.Keys, sp.Keys)
+			if err != nil {
+				return fmt.Errorf("type %d: %w", id, err)
+			}
+			p.Chunks = slices.Insert(p.Chunks, last+1, nc)
+
+			// update the number of types in the type spec, if set
+			for i, x := range p.Chunks {
+				if x.Type == chunkTableTypeSpec && x.Data[8] == id {
+					if n := le.Uint16(x.Data[10:]); n != 0 {
+						b := bytes.Clone(x.Data)
+						le.PutUint16(b[10:], n+1)
+						p.Chunks[i].Data = b
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// typeConfig gets the ResTable_config of a type chunk.
+func typeConfig(c chunk) []byte {
+	n := int(le.Uint32(c.Data[20:]))
+	if 20+n > c.HeaderSize {
+		n = c.HeaderSize - 20
+	}
+	return c.Data[20 : 20+n]
+}
+
+// remapType copies a type chunk from a split, changing the key and string
+// value indexes to the ones in the base.
+func remapType(c chunk, strs, spStrs, keys, spKeys *stringPool) (chunk, error) {
+	var (
+		b            = bytes.Clone(c.Data)
+		flags        = b[9]
+		entryCount   = int(le.Uint32(b[12:]))
+		entriesStart = int(le.Uint32(b[16:]))
+		offsets      []int
+	)
+	for i := range entryCount {
+		switch {
+		case flags&typeFlagSparse != 0:
+			if o := c.HeaderSize + 4*i + 4; o <= len(b) {
+				offsets = append(offsets, 4*int(le.Uint16(b[o-2:])))
+			} else {
+				return c, fmt.Errorf("truncated")
+			}
+		case flags&typeFlagOffset16 != 0:
+			if o := c.HeaderSize + 2*i + 2; o > len(b) {
+				return c, fmt.Errorf("truncated")
+			} else if x := le.Uint16(b[o-2:]); x != 0xffff {
+				offsets = append(offsets, 4*int(x))
+			}
+		default:
+			if o := c.HeaderSize + 4*i + 4; o > len(b) {
+				return c, fmt.Errorf("truncated")
+			} else if x := le.Uint32(b[o-4:]); x != noEntry {
+				offsets = append(offsets, int(x))
+			}
+		}
+	}
+	remapString := func(v []byte) error {
+		if v[3] == typeString {
+			x, err := strs.copyString(spStrs, le.Uint32(v[4:]))
+			if err != nil {
+				return err
+			}
+			le.PutUint32(v[4:], x)
+		}
+		return nil
+	}
+	remapKey := func(k uint32) (uint32, error) {
+		if int(k) >= len(spKeys.Strings) {
+			return 0, fmt.Errorf("key %d out of range", k)
+		}
+		return keys.index(spKeys.Strings[k]), nil
+	}
+	for _, off := range offsets {
+		e := entriesStart + off
+		if e < 0 || e+8 > len(b) {
+			return c, fmt.Errorf("entry out of bounds")
+		}
+		eflags := le.Uint16(b[e+2:])
+		if eflags&entryFlagCompact != 0 {
+			k, err := remapKey(uint32(le.Uint16(b[e:])))
+			if err != nil {
+				return c, err
+			}
+			if k > 0xffff {
+				return c, fmt.Errorf("key %d too large for compact entry", k)
+			}
+			le.PutUint16(b[e:], uint16(k))
+			if eflags>>8 == typeString {
+				x, err := strs.copyString(spStrs, le.Uint32(b[e+4:]))
+				if err != nil {
+					return c, err
+				}
+				le.PutUint32(b[e+4:], x)
+			}
+			continue
+		}
+		size := int(le.Uint16(b[e:]))
+		k, err := remapKey(le.Uint32(b[e+4:]))
+		if err != nil {
+			return c, err
+		}
+		le.PutUint32(b[e+4:], k)
+		if eflags&entryFlagComplex != 0 {
+			if e+16 > len(b) {
+				return c, fmt.Errorf("entry out of bounds")
+			}
+			count := int(le.Uint32(b[e+12:]))
+			for j := range count {
+				m := e + size + 12*j
+				if m+12 > len(b) {
+					return c, fmt.Errorf("map entry out of bounds")
+				}
+				if err := remapString(b[m+4 : m+12]); err != nil {
+					return c, err
+				}
+			}
+		} else {
+			if e+size+8 > len(b) {
+				return c, fmt.Errorf("value out of bounds")
+			}
+			if err := remapString(b[e+size : e+size+8]); err != nil {
+				return c, err
+			}
+		}
+	}
+	return chunk{c.Type, c.HeaderSize, b}, nil
+}
@@ -0,0 +1,338 @@
+package apksplit
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testTableDef describes a resource table with a single package.
+type testTableDef struct {
+	Package uint32
+	Types   []string        // type names, the ID is the index + 1
+	Specs   map[byte]uint32 // type ID -> flags for every entry
+	Configs []testTableType // in order
+}
+
+// testTableType is a type chunk. Entries with an empty key are missing.
+type testTableType struct {
+	ID      byte
+	Lang    string
+	Entries [][2]string // key, string value
+}
+
+func testTableBase() testTableDef {
+	return testTableDef{
+		Package: 0x7f,
+		Types:   []string{"string", "drawable"},
+		Specs:   map[byte]uint32{1: 0, 2: 0},
+		Configs: []testTableType{
+			{ID: 1, Entries: [][2]string{{"a", "A"}, {"b", "B"}, {"c", "C"}}},
+			{ID: 2, Entries: [][2]string{{"icon", "res/icon.png"}}},
+			{ID: 1, Lang: "de", Entries: [][2]string{{"a", "Ade"}, {"", ""}, {"c", "C"}}},
+		},
+	}
+}
+
+func testTableSplit(lang string) testTableDef {
+	return testTableDef{
+		Package: 0x7f,
+		Types:   []string{"string", "drawable"},
+		Specs:   map[byte]uint32{1: 0x4, 2: 0},
+		Configs: []testTableType{
+			{ID: 1, Lang: lang, Entries: [][2]string{{"a", "A" + lang}, {"b", "B" + lang}, {"", ""}}},
+		},
+	}
+}
+
+// testTable builds a resources.arsc. The strings are added to the pools in
+// reverse order, so the indexes don't match between tables.
+func testTable(def testTableDef) []byte {
+	var (
+		strs = &stringPool{UTF8: true}
+		keys = &stringPool{UTF8: true}
+	)
+	for i := len(def.Configs) - 1; i >= 0; i-- {
+		for j := len(def.Configs[i].Entries) - 1; j >= 0; j-- {
+			if e := def.Configs[i].Entries[j]; e[0] != "" {
+				keys.index(e[0])
+				strs.index(e[1])
+			}
+		}
+	}
+
+	var chunks [][]byte
+	for id := range byte(len(def.Types)) {
+		id++
+		flags, ok := def.Specs[id]
+		if !ok {
+			continue
+		}
+		var n int
+		for _, c := range def.Configs {
+			if c.ID == id {
+				n = max(n, len(c.Entries))
+			}
+		}
+		h := make([]byte, 16)
+		h[8] = id
+		le.PutUint32(h[12:], uint32(n))
+		var body []byte
+		for range n {
+			body = le.AppendUint32(body, flags)
+		}
+		chunks = append(chunks, makeChunk(chunkTableTypeSpec, h, body))
+
+		for _, c := range def.Configs {
+			if c.ID != id {
+				continue
+			}
+			h := make([]byte, 20+64)
+			h[8] = id
+			le.PutUint32(h[12:], uint32(len(c.Entries)))
+			le.PutUint32(h[16:], uint32(len(h)+4*len(c.Entries)))
+			le.PutUint32(h[20:], 64)
+			copy(h[20+8:], c.Lang)
+
+			var offsets, entries []byte
+			for _, e := range c.Entries {
+				if e[0] == "" {
+					offsets = le.AppendUint32(offsets, noEntry)
+					continue
+				}
+				offsets = le.AppendUint32(offsets, uint32(len(entries)))
+				entries = le.AppendUint16(entries, 8)
+				entries = le.AppendUint16(entries, 0)
+				entries = le.AppendUint32(entries, keys.index(e[0]))
+				entries = le.AppendUint16(entries, 8)
+				entries = append(entries, 0, typeString)
+				entries = le.AppendUint32(entries, strs.index(e[1]))
+			}
+			chunks = append(chunks, makeChunk(chunkTableType, h, offsets, entries))
+		}
+	}
+
+	types, err := (&stringPool{UTF8: false, Strings: def.Types}).bytes()
+	if err != nil {
+		panic(err)
+	}
+	kb, err := keys.bytes()
+	if err != nil {
+		panic(err)
+	}
+	sb, err := strs.bytes()
+	if err != nil {
+		panic(err)
+	}
+
+	ph := make([]byte, 288)
+	le.PutUint32(ph[8:], def.Package)
+	le.PutUint32(ph[268:], uint32(len(ph)))
+	le.PutUint32(ph[276:], uint32(len(ph)+len(types)))
+	pkg := makeChunk(chunkTablePackage, ph, append([][]byte{types, kb}, chunks...)...)
+
+	th := make([]byte, 12)
+	le.PutUint32(th[8:], 1)
+	return makeChunk(chunkTable, th, sb, pkg)
+}
+
+// dumpTable describes the type specs and string resources in a table.
+func dumpTable(buf []byte) ([]string, error) {
+	t, err := readTable(buf)
+	if err != nil {
+		return nil, err
+	}
+	var r []string
+	for _, p := range t.Packages {
+		for _, c := range p.Chunks {
+			typ := p.Types.Strings[c.Data[8]-1]
+			switch c.Type {
+			case chunkTableTypeSpec:
+				var flags []uint32
+				for b := c.Body(); len(b) >= 4; b = b[4:] {
+					flags = append(flags, le.Uint32(b))
+				}
+				r = append(r, fmt.Sprintf("%02x %s spec types=%d flags=%x", p.ID, typ, le.Uint16(c.Data[10:]), flags))
+			case chunkTableType:
+				var (
+					n     = int(le.Uint32(c.Data[12:]))
+					start = int(le.Uint32(c.Data[16:]))
+					lang  = strings.TrimRight(string(c.Data[20+8:20+10]), "\x00")
+				)
+				for i := range n {
+					off := le.Uint32(c.Data[c.HeaderSize+4*i:])
+					if off == noEntry {
+						continue
+					}
+					e := c.Data[start+int(off):]
+					if e[8+3] != typeString {
+						return nil, fmt.Errorf("unexpected value type %d", e[8+3])
+					}
+					r = append(r, fmt.Sprintf("%02x %s[%s] %d %s=%q", p.ID, typ, lang, i, p.Keys.Strings[le.Uint32(e[4:])], t.Strings.Strings[le.Uint32(e[12:])]))
+				}
+			}
+		}
+	}
+	return r, nil
+}
+
+func TestTable(t *testing.T) {
+	// round trip
+	buf := testTable(testTableBase())
+	tbl, err := readTable(buf)
+	if err != nil {
+		t.Fatalf("read table: %v", err)
+	}
+	out, err := tbl.bytes()
+	if err != nil {
+		t.Fatalf("encode table: %v", err)
+	}
+	if string(out) != string(buf) {
+		t.Errorf("encoded table doesn't match the original")
+	}
+
+	for _, tc := range []struct {
+		name string
+		buf  []byte
+	}{
+		{"NotTable", testManifest("")},
+		{"Truncated", buf[:len(buf)-1]},
+		{"Empty", makeChunk(chunkTable, make([]byte, 12))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := readTable(tc.buf); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestMergeTable(t *testing.T) {
+	base := []string{
+		`7f string spec types=0 flags=[0 0 0]`,
+		`7f string[] 0 a="A"`,
+		`7f string[] 1 b="B"`,
+		`7f string[] 2 c="C"`,
+		`7f string[de] 0 a="Ade"`,
+		`7f string[de] 2 c="C"`,
+		`7f drawable spec types=0 flags=[0]`,
+		`7f drawable[] 0 icon="res/icon.png"`,
+	}
+	for _, tc := range []struct {
+		name   string
+		splits []testTableDef
+		exp    []string
+		err    string
+	}{
+		{
+			name:   "None",
+			splits: nil,
+			exp:    base,
+		},
+		{
+			name:   "Single",
+			splits: []testTableDef{testTableSplit("fr")},
+			exp: slices.Concat(
+				[]string{`7f string spec types=0 flags=[4 4 4]`},
+				base[1:6],
+				[]string{
+					`7f string[fr] 0 a="Afr"`,
+					`7f string[fr] 1 b="Bfr"`,
+				},
+				base[6:],
+			),
+		},
+		{
+			name:   "Multiple",
+			splits: []testTableDef{testTableSplit("fr"), testTableSplit("es")},
+			exp: slices.Concat(
+				[]string{`7f string spec types=0 flags=[4 4 4]`},
+				base[1:6],
+				[]string{
+					`7f string[fr] 0 a="Afr"`,
+					`7f string[fr] 1 b="Bfr"`,
+					`7f string[es] 0 a="Aes"`,
+					`7f string[es] 1 b="Bes"`,
+				},
+				base[6:],
+			),
+		},
+		{
+			name:   "Existing",
+			splits: []testTableDef{testTableSplit("de")},
+			exp:    slices.Concat([]string{`7f string spec types=0 flags=[4 4 4]`}, base[1:]),
+		},
+		{
+			name: "NewKey",
+			splits: []testTableDef{func() testTableDef {
+				def := testTableSplit("fr")
+				def.Configs[0].Entries[2] = [2]string{"d", "Dfr"}
+				return def
+			}()},
+			exp: slices.Concat(
+				[]string{`7f string spec types=0 flags=[4 4 4]`},
+				base[1:6],
+				[]string{
+					`7f string[fr] 0 a="Afr"`,
+					`7f string[fr] 1 b="Bfr"`,
+					`7f string[fr] 2 d="Dfr"`,
+				},
+				base[6:],
+			),
+		},
+		{
+			name: "MissingPackage",
+			splits: []testTableDef{func() testTableDef {
+				def := testTableSplit("fr")
+				def.Package = 0x80
+				return def
+			}()},
+			err: "package 0x80 is not in the base",
+		},
+		{
+			name: "MissingType",
+			splits: []testTableDef{func() testTableDef {
+				def := testTableSplit("fr")
+				def.Types = append(def.Types, "raw")
+				def.Specs = map[byte]uint32{3: 0}
+				def.Configs[0].ID = 3
+				return def
+			}()},
+			err: "type 3 is not in the base",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tbl, err := readTable(testTable(testTableBase()))
+			if err != nil {
+				t.Fatalf("read base table: %v", err)
+			}
+			for _, def := range tc.splits {
+				st, err := readTable(testTable(def))
+				if err != nil {
+					t.Fatalf("read split table: %v", err)
+				}
+				if err = tbl.merge(st); err != nil {
+					if tc.err == "" || !strings.Contains(err.Error(), tc.err) {
+						t.Fatalf("merge: %v", err)
+					}
+					return
+				}
+			}
+			if tc.err != "" {
+				t.Fatalf("expected error containing %q", tc.err)
+			}
+			buf, err := tbl.bytes()
+			if err != nil {
+				t.Fatalf("encode merged table: %v", err)
+			}
+			act, err := dumpTable(buf)
+			if err != nil {
+				t.Fatalf("read merged table: %v", err)
+			}
+			if !slices.Equal(act, tc.exp) {
+				t.Errorf("expected:\n\t%s\ngot:\n\t%s", strings.Join(tc.exp, "\n\t"), strings.Join(act, "\n\t"))
+			}
+		})
+	}
+}
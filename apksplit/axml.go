@@ -0,0 +1,239 @@
+package apksplit
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Attribute resource IDs.
+const (
+	attrName               = 0x01010003
+	attrIsSplitRequired    = 0x01010591
+	attrRequiredSplitTypes = 0x0101064e
+	attrSplitTypes         = 0x0101064f
+)
+
+// splitAttrs are the manifest attributes which prevent a merged APK from
+// being installed on its own.
+var splitAttrs = []uint32{attrIsSplitRequired, attrRequiredSplitTypes, attrSplitTypes}
+
+// splitMetaData are the names of the manifest meta-data elements which prevent
+// a merged APK from being installed on its own.
+var splitMetaData = []string{
+	"com.android.vending.splits",
+	"com.android.vending.splits.required",
+	"com.android.dynamic.apk.fused.modules",
+}
+
+// xmlDoc is a binary XML document.
+type xmlDoc struct {
+	Strings *stringPool
+	ResMap  []uint32 // string index -> attribute resource ID
+	Chunks  []chunk
+}
+
+// xmlAttr is an attribute of a start element.
+type xmlAttr struct {
+	Name  string
+	ID    uint32 // attribute resource ID, if any
+	Value string // if it is a string
+	Off   int    // offset in the chunk
+}
+
+func readXML(buf []byte) (*xmlDoc, error) {
+	c, err := readChunk(buf)
+	if err != nil {
+		return nil, err
+	}
+	if c.Type != chunkXML {
+		return nil, fmt.Errorf("not a binary xml document")
+	}
+	cs, err := readChunks(c.Body())
+	if err != nil {
+		return nil, err
+	}
+	d := new(xmlDoc)
+	for _, c := range cs {
+		switch c.Type {
+		case chunkStringPool:
+			if d.Strings, err = readStringPool(c); err != nil {
+				return nil, err
+			}
+		case chunkXMLResourceMap:
+			for b := c.Body(); len(b) >= 4; b = b[4:] {
+				d.ResMap = append(d.ResMap, le.Uint32(b))
+			}
+		default:
+			d.Chunks = append(d.Chunks, c)
+		}
+	}
+	if d.Strings == nil {
+		return nil, fmt.Errorf("missing string pool")
+	}
+	return d, nil
+}
+
+func (d *xmlDoc) str(i uint32) string {
+	if int(i) < len(d.Strings.Strings) {
+		return d.Strings.Strings[i]
+	}
+	return ""
+}
+
+// element gets the name and attributes of a start element chunk.
+func (d *xmlDoc) element(c chunk) (string, []xmlAttr, error) {
+	b := c.Data
+	if c.HeaderSize < 16 || len(b) < c.HeaderSize+20 {
+		return "", nil, fmt.Errorf("start element: too short")
+	}
+	var (
+		x     = c.HeaderSize
+		name  = le.Uint32(b[x+4:])
+		start = int(le.Uint16(b[x+8:]))
+		size  = int(le.Uint16(b[x+10:]))
+		count = int(le.Uint16(b[x+12:]))
+		attrs []xmlAttr
+	)
+	if size < 20 || x+start+size*count > len(b) {
+		return "", nil, fmt.Errorf("start element: attributes out of bounds")
+	}
+	for i := range count {
+		var (
+			off = x + start + size*i
+			a   = xmlAttr{Name: d.str(le.Uint32(b[off+4:])), Off: off}
+		)
+		if n := le.Uint32(b[off+4:]); int(n) < len(d.ResMap) {
+			a.ID = d.ResMap[n]
+		}
+		if raw := le.Uint32(b[off+8:]); raw != noEntry {
+			a.Value = d.str(raw)
+		} else if b[off+15] == typeString {
+			a.Value = d.str(le.Uint32(b[off+16:]))
+		}
+		attrs = append(attrs, a)
+	}
+	return d.str(name), attrs, nil
+}
+
+// manifestInfo gets the package and split name from a binary
+// AndroidManifest.xml.
+func manifestInfo(buf []byte) (pkg, split string, err error) {
+	d, err := readXML(buf)
+	if err != nil {
+		return "", "", err
+	}
+	for _, c := range d.Chunks {
+		if c.Type != chunkXMLStartElement {
+			continue
+		}
+		name, attrs, err := d.element(c)
+		if err != nil {
+			return "", "", err
+		}
+		if name != "manifest" {
+			return "", "", fmt.Errorf("root element is %q, not manifest", name)
+		}
+		for _, a := range attrs {
+			switch {
+			case a.ID == 0 && a.Name == "package":
+				pkg = a.Value
+			case a.ID == 0 && a.Name == "split":
+				split = a.Value
+			}
+		}
+		return pkg, split, nil
+	}
+	return "", "", fmt.Errorf("missing root element")
+}
+
+// fixManifest removes the attributes and elements which require splits from a
+// binary AndroidManifest.xml.
+func fixManifest(buf []byte) ([]byte, error) {
+	root, err := readChunk(buf)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := readChunks(root.Body())
+	if err != nil {
+		return nil, err
+	}
+	d, err := readXML(buf)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		body [][]byte
+		skip int // depth of the element being removed
+	)
+	for _, c := range cs {
+		switch c.Type {
+		case chunkXMLStartElement:
+			if skip != 0 {
+				skip++
+				continue
+			}
+			name, attrs, err := d.element(c)
+			if err != nil {
+				return nil, err
+			}
+			if name == "meta-data" && slices.ContainsFunc(attrs, func(a xmlAttr) bool {
+				return a.ID == attrName && slices.Contains(splitMetaData, a.Value)
+			}) {
+				skip = 1
+				continue
+			}
+			if slices.ContainsFunc(attrs, func(a xmlAttr) bool {
+				return slices.Contains(splitAttrs, a.ID)
+			}) {
+				c = removeAttrs(c, attrs, func(a xmlAttr) bool {
+					return slices.Contains(splitAttrs, a.ID)
+				})
+			}
+		case chunkXMLEndElement:
+			if skip != 0 {
+				skip--
+				continue
+			}
+		default:
+			if skip != 0 {
+				continue
+			}
+		}
+		body = append(body, c.Data)
+	}
+	return makeChunk(chunkXML, root.Header(), body...), nil
+}
+
+// removeAttrs removes attributes from a start element chunk. The id, class,
+// and style attribute indexes are updated.
+func removeAttrs(c chunk, attrs []xmlAttr, fn func(xmlAttr) bool) chunk {
+	var (
+		b    = c.Data
+		x    = c.HeaderSize
+		ext  = append([]byte(nil), b[x:x+int(le.Uint16(b[x+8:]))]...)
+		vals [][]byte
+	)
+	for _, idx := range []int{14, 16, 18} {
+		if i := int(le.Uint16(ext[idx:])); i != 0 && i <= len(attrs) {
+			if fn(attrs[i-1]) {
+				le.PutUint16(ext[idx:], 0)
+			} else {
+				n := 0
+				for _, a := range attrs[:i-1] {
+					if fn(a) {
+						n++
+					}
+				}
+				le.PutUint16(ext[idx:], uint16(i-n))
+			}
+		}
+	}
+	size := int(le.Uint16(ext[10:]))
+	for _, a := range attrs {
+		if !fn(a) {
+			vals = append(vals, b[a.Off:a.Off+size])
+		}
+	}
+	le.PutUint16(ext[12:], uint16(len(vals)))
+	return chunk{c.Type, c.HeaderSize, makeChunk(c.Type, c.Header(), append([][]byte{ext}, vals...)...)}
+}
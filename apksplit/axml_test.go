@@ -0,0 +1,209 @@
+package apksplit
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testAttr is an attribute for testStart. If Str is noEntry, it is a boolean.
+type testAttr struct {
+	Name uint32
+	Str  uint32
+}
+
+// testManifest builds a binary AndroidManifest.xml. If split is not empty, the
+// manifest is for a config split. Otherwise, it is a base manifest which
+// requires splits.
+func testManifest(split string) []byte {
+	strs := []string{"name", "isSplitRequired", "value"} // for the resource map
+	str := func(s string) uint32 {
+		if i := slices.Index(strs, s); i != -1 {
+			return uint32(i)
+		}
+		strs = append(strs, s)
+		return uint32(len(strs) - 1)
+	}
+	var manifest, meta []byte
+	if split != "" {
+		manifest = testStart(str("manifest"), 0, testAttr{str("package"), str("com.example")}, testAttr{str("split"), str(split)})
+	} else {
+		manifest = testStart(str("manifest"), 3,
+			testAttr{str("package"), str("com.example")},
+			testAttr{str("isSplitRequired"), noEntry},
+			testAttr{str("x"), str("x")},
+		)
+		meta = slices.Concat(
+			testStart(str("meta-data"), 0, testAttr{str("name"), str("com.android.vending.splits")}, testAttr{str("value"), str("x")}),
+			testStart(str("x"), 0),
+			testEnd(str("x")),
+			testEnd(str("meta-data")),
+			testStart(str("meta-data"), 0, testAttr{str("name"), str("other")}, testAttr{str("value"), str("x")}),
+			testEnd(str("meta-data")),
+		)
+	}
+	body := slices.Concat(
+		manifest,
+		testStart(str("application"), 0),
+		meta,
+		testEnd(str("application")),
+		testEnd(str("manifest")),
+	)
+	pool, err := (&stringPool{UTF8: true, Strings: strs}).bytes()
+	if err != nil {
+		panic(err)
+	}
+	resMap := makeChunk(chunkXMLResourceMap, make([]byte, 8), u32s(attrName, attrIsSplitRequired, 0x01010024))
+	return makeChunk(chunkXML, make([]byte, 8), pool, resMap, body)
+}
+
+// testStart builds a start element chunk. The id attribute index is 1-based.
+func testStart(name uint32, id uint16, attrs ...testAttr) []byte {
+	ext := make([]byte, 20)
+	le.PutUint32(ext[0:], noEntry)
+	le.PutUint32(ext[4:], name)
+	le.PutUint16(ext[8:], 20)
+	le.PutUint16(ext[10:], 20)
+	le.PutUint16(ext[12:], uint16(len(attrs)))
+	le.PutUint16(ext[14:], id)
+	for _, a := range attrs {
+		b := make([]byte, 20)
+		le.PutUint32(b[0:], noEntry)
+		le.PutUint32(b[4:], a.Name)
+		le.PutUint16(b[12:], 8)
+		if a.Str == noEntry {
+			le.PutUint32(b[8:], noEntry)
+			b[15] = 0x12 // boolean
+			le.PutUint32(b[16:], 0xffffffff)
+		} else {
+			le.PutUint32(b[8:], a.Str)
+			b[15] = typeString
+			le.PutUint32(b[16:], a.Str)
+		}
+		ext = append(ext, b...)
+	}
+	return makeChunk(chunkXMLStartElement, make([]byte, 16), ext)
+}
+
+// testEnd builds an end element chunk.
+func testEnd(name uint32) []byte {
+	ext := make([]byte, 8)
+	le.PutUint32(ext[0:], noEntry)
+	le.PutUint32(ext[4:], name)
+	return makeChunk(chunkXMLEndElement, make([]byte, 16), ext)
+}
+
+func u32s(vs ...uint32) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = le.AppendUint32(b, v)
+	}
+	return b
+}
+
+// dumpXML describes the elements and attributes in a binary XML document.
+func dumpXML(buf []byte) (string, error) {
+	d, err := readXML(buf)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, c := range d.Chunks {
+		switch c.Type {
+		case chunkXMLStartElement:
+			name, attrs, err := d.element(c)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString("<" + name)
+			for _, a := range attrs {
+				fmt.Fprintf(&b, " %s=%q", a.Name, a.Value)
+			}
+			if id := le.Uint16(c.Data[c.HeaderSize+14:]); id != 0 {
+				fmt.Fprintf(&b, " #id=%d", id)
+			}
+			b.WriteString(">")
+		case chunkXMLEndElement:
+			b.WriteString("</" + d.str(le.Uint32(c.Data[c.HeaderSize+4:])) + ">")
+		}
+	}
+	return b.String(), nil
+}
+
+func TestManifestInfo(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		buf   []byte
+		pkg   string
+		split string
+		err   bool
+	}{
+		{"Base", testManifest(""), "com.example", "", false},
+		{"Split", testManifest("config.fr"), "com.example", "config.fr", false},
+		{"NotXML", testTable(testTableBase()), "", "", true},
+		{"Empty", makeChunk(chunkXML, make([]byte, 8)), "", "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pkg, split, err := manifestInfo(tc.buf)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("manifest info: %v", err)
+			}
+			if pkg != tc.pkg || split != tc.split {
+				t.Errorf("expected package %q split %q, got %q %q", tc.pkg, tc.split, pkg, split)
+			}
+		})
+	}
+}
+
+func TestFixManifest(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		buf  []byte
+		exp  string
+	}{
+		{
+			"Base",
+			testManifest(""),
+			`<manifest package="com.example" x="x" #id=2>` +
+				`<application>` +
+				`<meta-data name="other" value="x"></meta-data>` +
+				`</application>` +
+				`</manifest>`,
+		},
+		{
+			"Split",
+			testManifest("config.fr"),
+			`<manifest package="com.example" split="config.fr">` +
+				`<application></application>` +
+				`</manifest>`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, err := fixManifest(tc.buf)
+			if err != nil {
+				t.Fatalf("fix manifest: %v", err)
+			}
+			act, err := dumpXML(buf)
+			if err != nil {
+				t.Fatalf("read fixed manifest: %v", err)
+			}
+			if act != tc.exp {
+				t.Errorf("expected:\n\t%s\ngot:\n\t%s", tc.exp, act)
+			}
+			again, err := fixManifest(buf)
+			if err != nil {
+				t.Fatalf("fix fixed manifest: %v", err)
+			}
+			if string(again) != string(buf) {
+				t.Errorf("fixing a fixed manifest changed it")
+			}
+		})
+	}
+}
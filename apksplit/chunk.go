@@ -0,0 +1,335 @@
+package apksplit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// https://android.googlesource.com/platform/frameworks/base/+/refs/heads/main/libs/androidfw/include/androidfw/ResourceTypes.h
+
+const (
+	chunkStringPool = 0x0001
+	chunkTable      = 0x0002
+	chunkXML        = 0x0003
+
+	chunkXMLStartElement = 0x0102
+	chunkXMLEndElement   = 0x0103
+	chunkXMLResourceMap  = 0x0180
+
+	chunkTablePackage  = 0x0200
+	chunkTableType     = 0x0201
+	chunkTableTypeSpec = 0x0202
+)
+
+const (
+	noEntry = 0xffffffff
+	endSpan = 0xffffffff
+
+	poolUTF8 = 1 << 8
+
+	typeString = 0x03 // Res_value dataType
+)
+
+var le = binary.LittleEndian
+
+// chunk is a resource chunk.
+type chunk struct {
+	Type       uint16
+	HeaderSize int
+	Data       []byte // the entire chunk, including the header
+}
+
+// Header gets the chunk header, including the type and size.
+func (c chunk) Header() []byte {
+	return c.Data[:c.HeaderSize]
+}
+
+// Body gets the data after the chunk header.
+func (c chunk) Body() []byte {
+	return c.Data[c.HeaderSize:]
+}
+
+// readChunk reads the chunk at the start of buf.
+func readChunk(buf []byte) (chunk, error) {
+	if len(buf) < 8 {
+		return chunk{}, fmt.Errorf("truncated chunk header")
+	}
+	var (
+		typ  = le.Uint16(buf[0:])
+		hsz  = int(le.Uint16(buf[2:]))
+		size = int64(le.Uint32(buf[4:]))
+	)
+	if hsz < 8 || int64(hsz) > size || size > int64(len(buf)) {
+		return chunk{}, fmt.Errorf("invalid chunk 0x%04x (header size %d, size %d, available %d)", typ, hsz, size, len(buf))
+	}
+	return chunk{typ, hsz, buf[:size:size]}, nil
+}
+
+// readChunks reads consecutive chunks.
+func readChunks(buf []byte) ([]chunk, error) {
+	var cs []chunk
+	for len(buf) != 0 {
+		c, err := readChunk(buf)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+		buf = buf[len(c.Data):]
+	}
+	return cs, nil
+}
+
+// makeChunk builds a chunk from a header (the chunk header fields are
+// overwritten) and body parts.
+func makeChunk(typ uint16, header []byte, body ...[]byte) []byte {
+	b := append([]byte(nil), header...)
+	for _, x := range body {
+		b = append(b, x...)
+	}
+	le.PutUint16(b[0:], typ)
+	le.PutUint16(b[2:], uint16(len(header)))
+	le.PutUint32(b[4:], uint32(len(b)))
+	return b
+}
+
+// stringPool is a decoded ResStringPool.
+type stringPool struct {
+	UTF8    bool
+	Strings []string
+	Styles  [][]span // for the first len(Styles) strings
+
+	unstyled map[string]uint32 // lazily built index of unstyled strings
+}
+
+// span is a style span in a stringPool. The name is an index into the same
+// pool.
+type span struct {
+	Name, FirstChar, LastChar uint32
+}
+
+func readStringPool(c chunk) (*stringPool, error) {
+	if c.Type != chunkStringPool || c.HeaderSize < 28 {
+		return nil, fmt.Errorf("not a string pool")
+	}
+	var (
+		h            = c.Data
+		stringCount  = int(le.Uint32(h[8:]))
+		styleCount   = int(le.Uint32(h[12:]))
+		flags        = le.Uint32(h[16:])
+		stringsStart = int(le.Uint32(h[20:]))
+		stylesStart  = int(le.Uint32(h[24:]))
+		p            = &stringPool{UTF8: flags&poolUTF8 != 0}
+	)
+	if c.HeaderSize+4*(stringCount+styleCount) > len(c.Data) || styleCount > stringCount {
+		return nil, fmt.Errorf("string pool: invalid count")
+	}
+	for i := range stringCount {
+		off := stringsStart + int(le.Uint32(c.Data[c.HeaderSize+4*i:]))
+		s, err := p.readString(c.Data, off)
+		if err != nil {
+			return nil, fmt.Errorf("string pool: string %d: %w", i, err)
+		}
+		p.Strings = append(p.Strings, s)
+	}
+	for i := range styleCount {
+		off := stylesStart + int(le.Uint32(c.Data[c.HeaderSize+4*(stringCount+i):]))
+		var spans []span
+		for {
+			if off < 0 || off+4 > len(c.Data) {
+				return nil, fmt.Errorf("string pool: style %d: out of bounds", i)
+			}
+			if le.Uint32(c.Data[off:]) == endSpan {
+				break
+			}
+			if off+12 > len(c.Data) {
+				return nil, fmt.Errorf("string pool: style %d: out of bounds", i)
+			}
+			spans = append(spans, span{le.Uint32(c.Data[off:]), le.Uint32(c.Data[off+4:]), le.Uint32(c.Data[off+8:])})
+			off += 12
+		}
+		p.Styles = append(p.Styles, spans)
+	}
+	return p, nil
+}
+
+func (p *stringPool) readString(b []byte, off int) (string, error) {
+	if off < 0 || off > len(b) {
+		return "", fmt.Errorf("out of bounds")
+	}
+	b = b[off:]
+	if p.UTF8 {
+		_, n1, ok1 := readLen8(b)
+		if !ok1 {
+			return "", fmt.Errorf("out of bounds")
+		}
+		n, n2, ok2 := readLen8(b[n1:])
+		if !ok2 || n1+n2+n > len(b) {
+			return "", fmt.Errorf("out of bounds")
+		}
+		return string(b[n1+n2 : n1+n2+n]), nil
+	}
+	n, n1, ok := readLen16(b)
+	if !ok || n1+2*n > len(b) {
+		return "", fmt.Errorf("out of bounds")
+	}
+	u := make([]uint16, n)
+	for i := range u {
+		u[i] = le.Uint16(b[n1+2*i:])
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+func readLen8(b []byte) (n, size int, ok bool) {
+	if len(b) < 1 {
+		return 0, 0, false
+	}
+	if b[0]&0x80 == 0 {
+		return int(b[0]), 1, true
+	}
+	if len(b) < 2 {
+		return 0, 0, false
+	}
+	return int(b[0]&0x7f)<<8 | int(b[1]), 2, true
+}
+
+func readLen16(b []byte) (n, size int, ok bool) {
+	if len(b) < 2 {
+		return 0, 0, false
+	}
+	if x := le.Uint16(b); x&0x8000 == 0 {
+		return int(x), 2, true
+	}
+	if len(b) < 4 {
+		return 0, 0, false
+	}
+	return int(le.Uint16(b)&0x7fff)<<16 | int(le.Uint16(b[2:])), 4, true
+}
+
+// styled checks if string i has any style spans.
+func (p *stringPool) styled(i int) bool {
+	return i < len(p.Styles) && len(p.Styles[i]) != 0
+}
+
+// index gets the index of an unstyled string, adding it if it doesn't exist.
+func (p *stringPool) index(s string) uint32 {
+	if p.unstyled == nil {
+		p.unstyled = map[string]uint32{}
+		for i, x := range p.Strings {
+			if _, ok := p.unstyled[x]; !ok && !p.styled(i) {
+				p.unstyled[x] = uint32(i)
+			}
+		}
+	}
+	if i, ok := p.unstyled[s]; ok {
+		return i
+	}
+	i := uint32(len(p.Strings))
+	p.Strings = append(p.Strings, s)
+	p.unstyled[s] = i
+	return i
+}
+
+// copyString copies string i from another pool (including styles), returning
+// the new index.
+func (p *stringPool) copyString(from *stringPool, i uint32) (uint32, error) {
+	if int(i) >= len(from.Strings) {
+		return 0, fmt.Errorf("string %d out of range", i)
+	}
+	if !from.styled(int(i)) {
+		return p.index(from.Strings[i]), nil
+	}
+	var spans []span
+	for _, sp := range from.Styles[i] {
+		if int(sp.Name) >= len(from.Strings) {
+			return 0, fmt.Errorf("string %d: style name %d out of range", i, sp.Name)
+		}
+		sp.Name = p.index(from.Strings[sp.Name])
+		spans = append(spans, sp)
+	}
+	j := uint32(len(p.Strings))
+	p.Strings = append(p.Strings, from.Strings[i])
+	for len(p.Styles) < len(p.Strings)-1 {
+		p.Styles = append(p.Styles, nil)
+	}
+	p.Styles = append(p.Styles, spans)
+	return j, nil
+}
+
+// bytes encodes the string pool. The sorted flag is never set.
+func (p *stringPool) bytes() ([]byte, error) {
+	var (
+		offsets = make([]byte, 4*(len(p.Strings)+len(p.Styles)))
+		strs    []byte
+		styles  []byte
+	)
+	for i, s := range p.Strings {
+		le.PutUint32(offsets[4*i:], uint32(len(strs)))
+		if p.UTF8 {
+			n16 := 0
+			for _, r := range s {
+				if r == utf8.RuneError {
+					n16++ // invalid bytes are preserved as-is
+				} else {
+					n16 += utf16.RuneLen(r)
+				}
+			}
+			if n16 > 0x7fff || len(s) > 0x7fff {
+				return nil, fmt.Errorf("string pool: string %d too long", i)
+			}
+			strs = appendLen8(strs, n16)
+			strs = appendLen8(strs, len(s))
+			strs = append(strs, s...)
+			strs = append(strs, 0)
+		} else {
+			u := utf16.Encode([]rune(s))
+			if len(u) > 0x7fffffff {
+				return nil, fmt.Errorf("string pool: string %d too long", i)
+			}
+			if len(u) > 0x7fff {
+				strs = le.AppendUint16(strs, uint16(len(u)>>16)|0x8000)
+			}
+			strs = le.AppendUint16(strs, uint16(len(u)))
+			for _, x := range u {
+				strs = le.AppendUint16(strs, x)
+			}
+			strs = le.AppendUint16(strs, 0)
+		}
+	}
+	for len(strs)%4 != 0 {
+		strs = append(strs, 0)
+	}
+	for i, spans := range p.Styles {
+		le.PutUint32(offsets[4*(len(p.Strings)+i):], uint32(len(styles)))
+		for _, sp := range spans {
+			styles = le.AppendUint32(styles, sp.Name)
+			styles = le.AppendUint32(styles, sp.FirstChar)
+			styles = le.AppendUint32(styles, sp.LastChar)
+		}
+		styles = le.AppendUint32(styles, endSpan)
+	}
+	if len(p.Styles) != 0 {
+		styles = le.AppendUint32(styles, endSpan)
+		styles = le.AppendUint32(styles, endSpan)
+	}
+
+	h := make([]byte, 28)
+	le.PutUint32(h[8:], uint32(len(p.Strings)))
+	le.PutUint32(h[12:], uint32(len(p.Styles)))
+	if p.UTF8 {
+		le.PutUint32(h[16:], poolUTF8)
+	}
+	le.PutUint32(h[20:], uint32(len(h)+len(offsets)))
+	if len(p.Styles) != 0 {
+		le.PutUint32(h[24:], uint32(len(h)+len(offsets)+len(strs)))
+	}
+	return makeChunk(chunkStringPool, h, offsets, strs, styles), nil
+}
+
+func appendLen8(b []byte, n int) []byte {
+	if n > 0x7f {
+		return append(b, byte(n>>8)|0x80, byte(n))
+	}
+	return append(b, byte(n))
+}
@@ -0,0 +1,117 @@
+package apksplit
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStringPool(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		utf8    bool
+		strings []string
+		styles  [][]span
+	}{
+		{"Empty", false, nil, nil},
+		{"UTF8", true, []string{"", "a", "hello", "héllo", "日本語", "😀"}, nil},
+		{"UTF16", false, []string{"", "a", "hello", "héllo", "日本語", "😀"}, nil},
+		{"UTF8Long", true, []string{strings.Repeat("a", 0x80), strings.Repeat("é", 0x100)}, nil},
+		{"UTF16Long", false, []string{strings.Repeat("a", 0x80), strings.Repeat("a", 0x8000)}, nil},
+		{"Styled", true, []string{"<b>bold</b>", "b", "plain"}, [][]span{{{Name: 1, FirstChar: 0, LastChar: 3}}}},
+		{"StyledUTF16", false, []string{"bold italic", "b", "i"}, [][]span{{{Name: 1, FirstChar: 0, LastChar: 3}, {Name: 2, FirstChar: 5, LastChar: 10}}, nil, nil}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf, err := (&stringPool{UTF8: tc.utf8, Strings: tc.strings, Styles: tc.styles}).bytes()
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if len(buf)%4 != 0 {
+				t.Errorf("chunk size %d is not a multiple of 4", len(buf))
+			}
+			c, err := readChunk(buf)
+			if err != nil {
+				t.Fatalf("read chunk: %v", err)
+			}
+			p, err := readStringPool(c)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if p.UTF8 != tc.utf8 {
+				t.Errorf("expected utf8=%t, got %t", tc.utf8, p.UTF8)
+			}
+			if !slices.Equal(p.Strings, tc.strings) {
+				t.Errorf("strings mismatch: expected %q, got %q", tc.strings, p.Strings)
+			}
+			if !slices.EqualFunc(p.Styles, tc.styles, slices.Equal) {
+				t.Errorf("styles mismatch: expected %v, got %v", tc.styles, p.Styles)
+			}
+		})
+	}
+}
+
+func TestStringPoolIndex(t *testing.T) {
+	p := &stringPool{
+		Strings: []string{"styled", "b", "plain"},
+		Styles:  [][]span{{{Name: 1, FirstChar: 0, LastChar: 1}}},
+	}
+	for _, tc := range []struct {
+		s string
+		i uint32
+	}{
+		{"plain", 2},
+		{"b", 1},
+		{"styled", 3}, // the existing one is styled
+		{"new", 4},
+		{"styled", 3},
+	} {
+		if i := p.index(tc.s); i != tc.i {
+			t.Errorf("index(%q): expected %d, got %d", tc.s, tc.i, i)
+		}
+	}
+
+	q := &stringPool{Strings: []string{"b"}}
+	i, err := q.copyString(p, 0)
+	if err != nil {
+		t.Fatalf("copy styled string: %v", err)
+	}
+	if i != 1 || q.Strings[i] != "styled" || !q.styled(int(i)) {
+		t.Fatalf("expected styled string to be copied to a new index, got %d", i)
+	}
+	if sp := q.Styles[i]; len(sp) != 1 || q.Strings[sp[0].Name] != "b" {
+		t.Errorf("expected style name to be remapped, got %v", sp)
+	}
+	if _, err := q.copyString(p, 100); err == nil {
+		t.Errorf("expected error for out of range string")
+	}
+}
+
+func TestReadChunk(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		buf  []byte
+		err  bool
+	}{
+		{"Valid", makeChunk(chunkXML, make([]byte, 8), []byte{1, 2, 3, 4}), false},
+		{"Short", []byte{3, 0, 8, 0}, true},
+		{"HeaderTooSmall", []byte{3, 0, 4, 0, 8, 0, 0, 0}, true},
+		{"HeaderTooLarge", []byte{3, 0, 16, 0, 8, 0, 0, 0}, true},
+		{"Truncated", []byte{3, 0, 8, 0, 16, 0, 0, 0}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := readChunk(tc.buf)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read chunk: %v", err)
+			}
+			if c.Type != chunkXML || len(c.Header()) != 8 || len(c.Body()) != 4 {
+				t.Errorf("unexpected chunk %+v", c)
+			}
+		})
+	}
+}
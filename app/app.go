@@ -3,9 +3,60 @@ package app
 
 //go:generate go run app_generate.go
 
+// Version is a known version of the Lithium APK.
+type Version struct {
+	Name     string // versionName from the manifest
+	Code     int    // versionCode from the manifest
+	APK      string // filename to download the APK to
+	URL_APKM string // APKMirror release page
+	URL_IA   string // Internet Archive URL (may contain wildcards)
+	SHA256   string // hash of the original APK
+	Apktool  string // apktool version the patches were tested with
+}
+
+// Versions contains the known versions of the Lithium APK, newest first.
+var Versions = []Version{
+	{
+		Name:     "0.24.5",
+		Code:     93,
+		APK:      "Lithium_0.24.5.apk",
+		URL_APKM: "https://www.apkmirror.com/apk/faultexception/lithium-epub-reader/lithium-epub-reader-0-24-5-release/",
+		URL_IA:   "https://downloadr2.apkmirror.com/wp-content/uploads/2023/07/84/64a90b0d2cf42/com.faultexception.reader_0.24.5-93_minAPI16(nodpi)_apkmirror.com.apk?*",
+		SHA256:   "455cc8371a69ba0cd1f77906f799de751cf74c2974c4c86a60d986ed0070642e",
+		Apktool:  "2.8.1",
+	},
+}
+
+// The latest version (downloaded by go generate).
 var (
-	LithiumAPK      = "Lithium_0.24.5.apk"
-	LithiumURL_APKM = "https://www.apkmirror.com/apk/faultexception/lithium-epub-reader/lithium-epub-reader-0-24-5-release/"
-	LithiumURL_IA   = "https://downloadr2.apkmirror.com/wp-content/uploads/2023/07/84/64a90b0d2cf42/com.faultexception.reader_0.24.5-93_minAPI16(nodpi)_apkmirror.com.apk?*"
-	LithiumSHA      = "455cc8371a69ba0cd1f77906f799de751cf74c2974c4c86a60d986ed0070642e"
+	LithiumAPK      = Versions[0].APK
+	LithiumURL_APKM = Versions[0].URL_APKM
+	LithiumURL_IA   = Versions[0].URL_IA
+	LithiumSHA      = Versions[0].SHA256
 )
+
+// Latest gets the latest known version.
+func Latest() Version {
+	return Versions[0]
+}
+
+// ByHash gets the version of the original APK with the specified hex-encoded
+// SHA-256 hash.
+func ByHash(sha string) (Version, bool) {
+	for _, v := range Versions {
+		if v.SHA256 == sha {
+			return v, true
+		}
+	}
+	return Version{}, false
+}
+
+// ByName gets the version with the specified versionName.
+func ByName(name string) (Version, bool) {
+	for _, v := range Versions {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Version{}, false
+}
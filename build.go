@@ -0,0 +1,291 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pgaskin/lithiumpatch/patches/patchdef"
+)
+
+// treeHashes contains the hashes of the parts of a decompiled APK which are
+// built separately.
+type treeHashes struct {
+	Res   string            `json:"res"`   // everything built by apktool other than the dex files
+	Smali map[string]string `json:"smali"` // smali directory -> hash
+}
+
+// hashTree hashes a decompiled APK. Files which are copied as-is (assets, native
+// libraries, and unknown files) aren't included.
+func hashTree(dis string) (treeHashes, error) {
+	var (
+		res   = sha256.New()
+		smali = map[string]hash.Hash{}
+	)
+	if err := filepath.WalkDir(dis, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dis, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		dir, _, _ := strings.Cut(rel, "/")
+		switch dir {
+		case "assets", "lib", "unknown", "build", "dist":
+			return fs.SkipDir // build and dist are created by apktool b
+		}
+		if d.IsDir() {
+			return nil
+		}
+		h := res
+		if isDexDir(dir) {
+			if smali[dir] == nil {
+				smali[dir] = sha256.New()
+			}
+			h = smali[dir]
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		fh := sha256.New()
+		if _, err := io.Copy(fh, f); err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%x\n", rel, fh.Sum(nil))
+		return nil
+	}); err != nil {
+		return treeHashes{}, fmt.Errorf("hash decompiled apk: %w", err)
+	}
+	t := treeHashes{
+		Res:   hex.EncodeToString(res.Sum(nil)),
+		Smali: map[string]string{},
+	}
+	for dir, h := range smali {
+		t.Smali[dir] = hex.EncodeToString(h.Sum(nil))
+	}
+	return t, nil
+}
+
+// isDexDir checks if name is a smali directory for a dex file in the root of
+// the APK.
+func isDexDir(name string) bool {
+	return name == "smali" || strings.HasPrefix(name, "smali_classes")
+}
+
+// dexName gets the name of the dex file for a smali directory.
+func dexName(dir string) string {
+	if dir == "smali" {
+		return "classes.dex"
+	}
+	return "classes" + strings.TrimPrefix(dir, "smali_classes") + ".dex"
+}
+
+// cacheKey hashes the parts of a cache key.
+func cacheKey(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		io.WriteString(h, p+"\x00")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// decompile decompiles the APK to dis (or copies it from the cache), then gets
+// the hashes of the unmodified decompiled APK.
+func decompile(ctx context.Context, c *cache, apk, apkHash, apktoolHash, dis string) (treeHashes, error) {
+	var (
+		t   treeHashes
+		key = cacheKey(apkHash, apktoolHash)
+	)
+	if p, ok := c.lookup("decompiled", key); ok && c.readJSON("decompiled", key+".json", &t) {
+		fmt.Printf("> Copying cached decompiled APK %q to %q\n", p, dis)
+		if err := os.CopyFS(dis, os.DirFS(p)); err != nil {
+			return t, fmt.Errorf("copy cached decompiled apk: %w", err)
+		}
+		return t, nil
+	}
+
+	fmt.Printf("> Decompiling APK %q to %q\n", apk, dis)
+	if err := jar(ctx, *Apktool, "d", "-f", apk, "-o", dis); err != nil {
+		return t, fmt.Errorf("apktool: %w", err)
+	}
+	t, err := hashTree(dis)
+	if err != nil {
+		return t, err
+	}
+	if err := c.store("decompiled", key, func(tmp string) error {
+		return os.CopyFS(tmp, os.DirFS(dis))
+	}); err != nil {
+		return t, err
+	}
+	if err := c.storeJSON("decompiled", key+".json", t); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// buildAPK builds the decompiled APK. If the resources weren't changed from the
+// original APK (or a previous build), it splices the changed files and dex
+// files into it (assembling only the changed smali directories) rather than
+// rebuilding everything with apktool.
+func buildAPK(ctx context.Context, c *cache, apk, dis, out string, orig treeHashes, apktoolHash string) error {
+	if *FullRebuild {
+		fmt.Printf("> Compiling APK to %q\n", out)
+		if err := jar(ctx, *Apktool, "b", "-f", dis, "-o", out); err != nil {
+			return fmt.Errorf("apktool: %w", err)
+		}
+		return nil
+	}
+
+	cur, err := hashTree(dis)
+	if err != nil {
+		return err
+	}
+	api, err := patchdef.MinSdkVersion(dis)
+	if err != nil {
+		return err
+	}
+
+	base, baseHashes := apk, orig
+	if cur.Res != orig.Res {
+		var (
+			t   treeHashes
+			key = cacheKey(apktoolHash, cur.Res)
+		)
+		if p, ok := c.lookup("built", key+".apk"); ok && c.readJSON("built", key+".json", &t) {
+			fmt.Printf("> Using cached APK %q\n", p)
+			base, baseHashes = p, t
+		} else {
+			base, baseHashes = filepath.Join(filepath.Dir(out), "apktool.apk"), cur
+			fmt.Printf("> Compiling APK to %q\n", base)
+			if err := jar(ctx, *Apktool, "b", "-f", dis, "-o", base); err != nil {
+				return fmt.Errorf("apktool: %w", err)
+			}
+			if err := c.store("built", key+".apk", func(tmp string) error {
+				return copyFile(tmp, base)
+			}); err != nil {
+				return err
+			}
+			if err := c.storeJSON("built", key+".json", cur); err != nil {
+				return err
+			}
+			if err := cacheDex(c, base, cur, apktoolHash, api); err != nil {
+				return err
+			}
+		}
+		fmt.Println()
+	}
+
+	dex := map[string]string{}
+	for dir, h := range cur.Smali {
+		if baseHashes.Smali[dir] == h {
+			continue
+		}
+		key := cacheKey(apktoolHash, strconv.Itoa(api), h)
+		if p, ok := c.lookup("dex", key); ok {
+			fmt.Printf("> Using cached %s for %s\n", dexName(dir), dir)
+			dex[dexName(dir)] = p
+			continue
+		}
+		p := filepath.Join(filepath.Dir(out), dexName(dir))
+		fmt.Printf("> Assembling %s to %s\n", dir, dexName(dir))
+		if err := javaMain(ctx, *Apktool, "com.android.tools.smali.smali.Main",
+			"assemble",
+			"--api", strconv.Itoa(api),
+			"-o", p,
+			filepath.Join(dis, dir),
+		); err != nil {
+			return fmt.Errorf("smali: %w", err)
+		}
+		if err := c.store("dex", key, func(tmp string) error {
+			return copyFile(tmp, p)
+		}); err != nil {
+			return err
+		}
+		dex[dexName(dir)] = p
+	}
+	for dir := range baseHashes.Smali {
+		if _, ok := cur.Smali[dir]; !ok {
+			dex[dexName(dir)] = ""
+		}
+	}
+
+	fmt.Printf("> Splicing changes into %q to %q\n", base, out)
+	if err := spliceAPK(base, dis, out, dex); err != nil {
+		return fmt.Errorf("splice apk: %w", err)
+	}
+	return nil
+}
+
+// cacheDex adds the dex files from an APK built by apktool to the cache.
+func cacheDex(c *cache, apk string, t treeHashes, apktoolHash string, api int) error {
+	if c == nil {
+		return nil
+	}
+	zr, err := zip.OpenReader(apk)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for dir, h := range t.Smali {
+		rc, err := zr.Open(dexName(dir))
+		if err != nil {
+			return err
+		}
+		err = c.store("dex", cacheKey(apktoolHash, strconv.Itoa(api), h), func(tmp string) error {
+			f, err := os.Create(tmp)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(f, rc); err != nil {
+				return err
+			}
+			return f.Close()
+		})
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(dst, src string) error {
+	buf, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, buf, 0666)
+}
+
+func javaMain(ctx context.Context, jarfile, class string, args ...string) error {
+	if _, err := exec.LookPath("java"); err != nil {
+		return fmt.Errorf("could not find java: %v", err)
+	}
+
+	if _, err := os.Stat(jarfile); err != nil {
+		return fmt.Errorf("could not access jarfile %q: %v", jarfile, err)
+	}
+
+	cmd := exec.CommandContext(ctx, "java", append([]string{"-cp", jarfile, class}, args...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
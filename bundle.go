@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pgaskin/lithiumpatch/apksign"
+	"github.com/pgaskin/lithiumpatch/apksplit"
+	"github.com/pgaskin/lithiumpatch/zipalign"
+)
+
+// openInput gets the APK to patch. If the input is a split APK bundle, the
+// splits are merged into a single APK in dir (or if --keep-splits is set, only
+// the base APK is extracted), and the split set is returned.
+func openInput(input, dir string) (string, *apksplit.Set, error) {
+	if !apksplit.IsBundle(input) {
+		return input, nil, nil
+	}
+	fmt.Printf("> Reading split APKs from %q\n", input)
+	set, err := apksplit.Open(input)
+	if err != nil {
+		return "", nil, fmt.Errorf("read split apks: %w", err)
+	}
+	fmt.Printf("... %s (base)\n", set.Base.Name)
+	for _, s := range set.Splits {
+		fmt.Printf("... %s (%s)\n", s.Name, s.Split)
+	}
+	fmt.Println()
+
+	if *KeepSplits {
+		apk := filepath.Join(dir, "base.apk")
+		fmt.Printf("> Extracting base APK to %q\n", apk)
+		if err := os.WriteFile(apk, set.Base.Data, 0666); err != nil {
+			return "", nil, fmt.Errorf("extract base apk: %w", err)
+		}
+		fmt.Println()
+		return apk, set, nil
+	}
+
+	apk := filepath.Join(dir, "merged.apk")
+	fmt.Printf("> Merging split APKs to %q\n", apk)
+	var buf bytes.Buffer
+	if err := set.Merge(&buf); err != nil {
+		return "", nil, fmt.Errorf("merge split apks: %w", err)
+	}
+	if err := os.WriteFile(apk, buf.Bytes(), 0666); err != nil {
+		return "", nil, fmt.Errorf("merge split apks: %w", err)
+	}
+	fmt.Println()
+	return apk, set, nil
+}
+
+// writeSplits writes the signed base APK and the config splits (re-signed with
+// the same key) to a zip file which can be installed like an .apks file.
+func writeSplits(out, base string, set *apksplit.Set, k *apksign.Key) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	add := func(name string, buf []byte) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   name,
+			Method: zip.Store,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(buf)
+		return err
+	}
+
+	buf, err := os.ReadFile(base)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("... %s\n", set.Base.Name)
+	if err := add(set.Base.Name, buf); err != nil {
+		return err
+	}
+	for _, s := range set.Splits {
+		fmt.Printf("... %s\n", s.Name)
+		var unsigned, aligned, signed bytes.Buffer
+		if err := stripV1Signature(&unsigned, s.Data); err != nil {
+			return fmt.Errorf("%s: %w", s.Name, err)
+		}
+		if err := zipalign.Align(&aligned, unsigned.Bytes()); err != nil {
+			return fmt.Errorf("%s: zipalign: %w", s.Name, err)
+		}
+		if err := apksign.Sign(&signed, aligned.Bytes(), k); err != nil {
+			return fmt.Errorf("%s: sign: %w", s.Name, err)
+		}
+		if err := add(s.Name, signed.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// stripV1Signature copies an APK without the JAR signature files.
+func stripV1Signature(w io.Writer, apk []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	for _, zf := range zr.File {
+		if isV1Signature(zf.Name) {
+			continue
+		}
+		rc, err := zf.OpenRaw()
+		if err != nil {
+			return err
+		}
+		fw, err := zw.CreateRaw(&zf.FileHeader)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(fw, rc); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
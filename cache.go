@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cache stores build artifacts between runs. A nil cache does not store
+// anything.
+type cache struct {
+	dir string
+}
+
+// defaultCacheDir gets the default cache directory, or an empty string if the
+// user cache directory is unavailable.
+func defaultCacheDir() string {
+	if d, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(d, "lithiumpatch")
+	}
+	return ""
+}
+
+// openCache opens the cache specified by --cache, creating it if needed. If
+// --no-cache is set, or the default cache directory is unavailable, caching is
+// disabled.
+func openCache() (*cache, error) {
+	dir := *Cache
+	if dir == "" {
+		dir = defaultCacheDir()
+	}
+	if dir == "" || *NoCache {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+	return &cache{dir}, nil
+}
+
+// path gets the path of a cache entry.
+func (c *cache) path(kind, key string) string {
+	return filepath.Join(c.dir, kind, key)
+}
+
+// lookup checks if a cache entry exists, returning its path if it does.
+func (c *cache) lookup(kind, key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	p := c.path(kind, key)
+	if _, err := os.Stat(p); err != nil {
+		return "", false
+	}
+	return p, true
+}
+
+// store atomically adds a cache entry by calling fn with a temporary path to
+// write the file or directory to. If c is nil, it does nothing.
+func (c *cache) store(kind, key string, fn func(tmp string) error) error {
+	if c == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(c.dir, kind), 0777); err != nil {
+		return fmt.Errorf("store cache entry: %w", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Join(c.dir, kind), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("store cache entry: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	p := filepath.Join(tmp, "entry")
+	if err := fn(p); err != nil {
+		return fmt.Errorf("store cache entry: %w", err)
+	}
+	if err := os.RemoveAll(c.path(kind, key)); err != nil {
+		return fmt.Errorf("store cache entry: %w", err)
+	}
+	if err := os.Rename(p, c.path(kind, key)); err != nil {
+		return fmt.Errorf("store cache entry: %w", err)
+	}
+	return nil
+}
+
+// readJSON reads a JSON cache entry.
+func (c *cache) readJSON(kind, key string, v any) bool {
+	p, ok := c.lookup(kind, key)
+	if !ok {
+		return false
+	}
+	buf, err := os.ReadFile(p)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(buf, v) == nil
+}
+
+// storeJSON adds a JSON cache entry.
+func (c *cache) storeJSON(kind, key string, v any) error {
+	return c.store(kind, key, func(tmp string) error {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(tmp, buf, 0666)
+	})
+}
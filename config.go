@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pgaskin/lithiumpatch/patches/patchdef"
+	"github.com/spf13/pflag"
+)
+
+// configExclude are the flags which cannot be set from a config file (--set is
+// replaced by the patch table).
+var configExclude = []string{"config", "print-config", "help", "list-patches", "set", "watch", "watch-tree"}
+
+// configSecret are the flags which are never printed by printConfig.
+var configSecret = []string{"keystore-passphrase"}
+
+// configPath are the flags which are paths relative to the config file.
+var configPath = []string{"keystore", "output", "diff", "report", "output-bundle", "add-fonts", "apktool", "android-jar", "cache"}
+
+// configCommand are the flags which are executables relative to the config
+// file if they contain a path separator, or searched for in PATH otherwise.
+var configCommand = []string{"zipalign", "javac", "d8"}
+
+// loadConfig loads flags, the input, and patch options from a TOML (or JSON if
+// the extension is .json) config file. Flags set on the command line take
+// precedence. The input is returned if set. Relative paths (including the
+// input) are resolved relative to the directory containing the config file.
+//
+//	input = "Lithium_0.24.5.apk"
+//	output = "Lithium.patched.apk"
+//	disable = ["coversize"]
+//
+//	[patch.color]
+//	primary = "#ff104068"
+func loadConfig(name string) (string, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+
+	var cfg map[string]any
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		d := json.NewDecoder(bytes.NewReader(buf))
+		d.UseNumber()
+		if err := d.Decode(&cfg); err != nil {
+			return "", fmt.Errorf("parse %q: %w", name, err)
+		}
+	} else {
+		if err := toml.Unmarshal(buf, &cfg); err != nil {
+			return "", fmt.Errorf("parse %q: %w", name, err)
+		}
+	}
+
+	var input string
+	dir := filepath.Dir(name)
+	for _, k := range slices.Sorted(maps.Keys(cfg)) {
+		v := cfg[k]
+		switch k {
+		case "input":
+			s, ok := v.(string)
+			if !ok {
+				return "", fmt.Errorf("%s: input: expected string, got %T", name, v)
+			}
+			input = configResolve(dir, s)
+		case "patch":
+			m, ok := v.(map[string]any)
+			if !ok {
+				return "", fmt.Errorf("%s: patch: expected table, got %T", name, v)
+			}
+			for _, p := range slices.Sorted(maps.Keys(m)) {
+				opts, ok := m[p].(map[string]any)
+				if !ok {
+					return "", fmt.Errorf("%s: patch.%s: expected table, got %T", name, p, m[p])
+				}
+				for _, o := range slices.Sorted(maps.Keys(opts)) {
+					if err := patchdef.SetOption(p, o, opts[o]); err != nil {
+						return "", fmt.Errorf("%s: %w", name, err)
+					}
+				}
+			}
+		default:
+			f := pflag.Lookup(k)
+			if f == nil || slices.Contains(configExclude, k) {
+				return "", fmt.Errorf("%s: unknown option %q", name, k)
+			}
+			if f.Changed {
+				continue
+			}
+			switch {
+			case slices.Contains(configPath, k):
+				v = configResolveValue(dir, v)
+			case slices.Contains(configCommand, k):
+				if s, ok := v.(string); ok && strings.ContainsRune(filepath.ToSlash(s), '/') {
+					v = configResolve(dir, s)
+				}
+			}
+			if err := setFlag(f, v); err != nil {
+				return "", fmt.Errorf("%s: %s: %w", name, k, err)
+			}
+		}
+	}
+	return input, nil
+}
+
+// configResolve resolves a relative path against dir. Empty and absolute paths
+// are returned as-is.
+func configResolve(dir, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// configResolveValue resolves the path (or array of paths) in a decoded config
+// value against dir. Other values are returned as-is (setFlag will reject
+// them).
+func configResolveValue(dir string, v any) any {
+	switch v := v.(type) {
+	case string:
+		return configResolve(dir, v)
+	case []any:
+		xs := make([]any, len(v))
+		for i, x := range v {
+			if s, ok := x.(string); ok {
+				xs[i] = configResolve(dir, s)
+			} else {
+				xs[i] = x
+			}
+		}
+		return xs
+	}
+	return v
+}
+
+// setFlag sets a flag from a decoded config value.
+func setFlag(f *pflag.Flag, v any) error {
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		xs, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("expected array, got %T", v)
+		}
+		ss := make([]string, len(xs))
+		for i, x := range xs {
+			s, ok := x.(string)
+			if !ok {
+				return fmt.Errorf("expected array of strings, got %T element", x)
+			}
+			ss[i] = s
+		}
+		return sv.Replace(ss)
+	}
+	switch v.(type) {
+	case string, bool, int64, float64, json.Number:
+		return f.Value.Set(fmt.Sprint(v))
+	default:
+		return fmt.Errorf("expected %s, got %T", f.Value.Type(), v)
+	}
+}
+
+// printConfig writes the effective config (the flags which differ from their
+// defaults, other than secrets, the input, and the options for all patches) as
+// TOML.
+func printConfig(input string) error {
+	cfg := map[string]any{}
+	pflag.VisitAll(func(f *pflag.Flag) {
+		if slices.Contains(configExclude, f.Name) || slices.Contains(configSecret, f.Name) || f.Value.String() == f.DefValue {
+			return
+		}
+		switch f.Value.Type() {
+		case "bool":
+			cfg[f.Name] = f.Value.String() == "true"
+		case "stringSlice":
+			cfg[f.Name] = f.Value.(pflag.SliceValue).GetSlice()
+		default:
+			cfg[f.Name] = f.Value.String()
+		}
+	})
+	if input != "" {
+		cfg["input"] = input
+	}
+
+	ps, err := patchdef.Patches()
+	if err != nil {
+		return err
+	}
+	popts := map[string]any{}
+	for _, p := range ps {
+		if opts := p.Options(); len(opts) != 0 {
+			m := map[string]any{}
+			for _, o := range opts {
+				m[o.Name] = o.Value
+			}
+			popts[p.Name()] = m
+		}
+	}
+	if len(popts) != 0 {
+		cfg["patch"] = popts
+	}
+
+	e := toml.NewEncoder(os.Stdout)
+	e.Indent = ""
+	return e.Encode(cfg)
+}
@@ -107,18 +107,29 @@ func Parse(verbose bool) error {
 			dictParsed[d.Name] = p
 		}
 		if verbose {
-			seen := map[string]struct{}{}
-			for _, x := range dictParsed[d.Name] {
-				for _, t := range x.Terms {
-					seen[Normalize(t)] = struct{}{}
-				}
-			}
-			fmt.Printf("... %s (%d terms, %d entries)\n", d.Name, len(seen), len(dictParsed[d.Name]))
+			terms, entries, _ := Size(d.Name)
+			fmt.Printf("... %s (%d terms, %d entries)\n", d.Name, terms, entries)
 		}
 	}
 	return nil
 }
 
+// Size gets the number of unique normalized terms and entries in a parsed
+// dictionary.
+func Size(name string) (terms, entries int, ok bool) {
+	p, ok := dictParsed[name]
+	if !ok {
+		return 0, 0, false
+	}
+	seen := map[string]struct{}{}
+	for _, x := range p {
+		for _, t := range x.Terms {
+			seen[Normalize(t)] = struct{}{}
+		}
+	}
+	return len(seen), len(p), true
+}
+
 // Build builds all dictionaries into subdirectories of the provided path, which
 // should be empty.
 func Build(path string) error {
@@ -5,6 +5,7 @@ go 1.25.0
 tool github.com/pgaskin/edgedict/cmd/edgedict-fetch
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/andybalholm/cascadia v1.3.3
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
 	github.com/hexops/gotextdiff v1.0.3
@@ -13,12 +14,14 @@ require (
 	github.com/spf13/pflag v1.0.10
 	golang.org/x/net v0.44.0
 	golang.org/x/text v0.29.0
+	software.sslmate.com/src/go-pkcs12 v0.7.3
 )
 
 require (
 	github.com/ncruces/julianday v1.0.0 // indirect
 	github.com/pgaskin/xmlwriter v0.0.4 // indirect
 	github.com/tetratelabs/wazero v1.9.0 // indirect
+	golang.org/x/crypto v0.42.0 // indirect
 	golang.org/x/image v0.31.0 // indirect
 	golang.org/x/sys v0.36.0 // indirect
 )
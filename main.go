@@ -3,55 +3,154 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/pgaskin/lithiumpatch/apksign"
+	"github.com/pgaskin/lithiumpatch/apksplit"
 	"github.com/pgaskin/lithiumpatch/dict"
 	_ "github.com/pgaskin/lithiumpatch/dict/edgedict"
 	_ "github.com/pgaskin/lithiumpatch/dict/webster1913"
 	"github.com/pgaskin/lithiumpatch/fonts"
-	"github.com/pgaskin/lithiumpatch/patches"
+	_ "github.com/pgaskin/lithiumpatch/patches"
 	"github.com/pgaskin/lithiumpatch/patches/patchdef"
+	"github.com/pgaskin/lithiumpatch/zipalign"
 
 	"github.com/spf13/pflag"
 
 	_ "github.com/ncruces/go-sqlite3/embed"
 )
 
+// input is the APK to patch, from the command line or the config file.
+var input string
+
 var defaultKeySig = "06:7D:43:11:08:F8:ED:AF:24:71:7D:CE:D1:A3:01:D9:55:A3:A2:90"
 
 var (
-	Keystore           = pflag.StringP("keystore", "k", "default.jks", "Path to keystore for signing (will be created if does not exist)")
+	Keystore           = pflag.StringP("keystore", "k", "default.jks", "Path to JKS or PKCS#12 keystore for signing (will be created if does not exist)")
 	KeystoreAlias      = pflag.String("keystore-alias", "default", "Keystore alias")
 	KeystorePassphrase = pflag.String("keystore-passphrase", "default", "Keystore passphrase")
 	Output             = pflag.StringP("output", "o", "", "Output APK path (default: {basename}.patched.resigned.apk)")
 	Diff               = pflag.StringP("diff", "d", "", "Write diff to the specified file (default: disabled)")
+	Report             = pflag.String("report", "", "Write a JSON report of the files changed by each patch and instruction to the specified file (default: disabled)")
+	OutputBundle       = pflag.String("output-bundle", "", "Also write the output APK, the diff, and a provenance.json describing the build (patches, fonts, dictionaries, hashes, and signing certificate) to the specified directory, or zip file if it ends with .zip (default: disabled)")
+	KeepSplits         = pflag.Bool("keep-splits", false, "When patching a split APK bundle, only patch the base APK, and write it with the re-signed config splits to an .apks file instead of merging them into a single APK")
 
 	AddFonts = pflag.StringSlice("add-fonts", nil, "Add extra TTF fonts from a directory (Regular/Roman, Bold, Italic, and BoldItalic variants should be provided) (can be specified multiple times)")
 
-	Apktool   = pflag.String("apktool", "lib/apktool-2.8.1.jar", "Path to apktool.jar (2.8.1)")
-	Apksigner = pflag.String("apksigner", "lib/apksigner-0.9.jar", "Path to apksigner.jar (0.9 or later)")
-	Zipalign  = pflag.String("zipalign", "zipalign", "zipalign executable (will search PATH)")
-	Keytool   = pflag.String("keytool", "keytool", "keytool executable (will search PATH)")
+	Enable      = pflag.StringSlice("enable", nil, "Only apply patches matching the specified glob patterns (required patches are always applied, and patches which are disabled by default are applied in addition to the others if specified by name) (can be specified multiple times)")
+	Disable     = pflag.StringSlice("disable", nil, "Do not apply patches matching the specified glob patterns (can be specified multiple times)")
+	ListPatches = pflag.Bool("list-patches", false, "List the available patches and exit")
+	SkipFailing = pflag.Bool("skip-failing", false, "Skip optional patches which fail to apply (and patches requiring them) instead of stopping")
+	Set         = pflag.StringArray("set", nil, "Set a patch option as PATCH.OPTION=VALUE, where VALUE is parsed as JSON unless the option is a string (see --list-patches for the available options) (can be specified multiple times)")
+
+	Apktool    = pflag.String("apktool", "", "Path to apktool.jar (default: lib/apktool-{version}.jar, where version is the apktool version the patches were tested with for the input APK)")
+	Zipalign   = pflag.String("zipalign", "", "zipalign executable to use instead of the built-in implementation (will search PATH)")
+	Javac      = pflag.String("javac", "javac", "javac executable for patches containing Java code (will search PATH)")
+	D8         = pflag.String("d8", "d8", "d8 executable for patches containing Java code (part of the Android build tools) (will search PATH)")
+	AndroidJar = pflag.String("android-jar", "", "Path to android.jar for patches containing Java code and for checking framework references in smali (default: latest platform in $ANDROID_HOME)")
+
+	Cache       = pflag.String("cache", "", "Directory to cache the decompiled APK and build artifacts in (default: lithiumpatch in the user cache directory)")
+	NoCache     = pflag.Bool("no-cache", false, "Do not use the cache")
+	FullRebuild = pflag.Bool("full-rebuild", false, "Always rebuild the APK with apktool instead of splicing the changes into the original APK or a cached build when only assets, native libraries, and smali were changed")
 
-	Quiet = pflag.BoolP("quiet", "q", false, "Do not show the diff")
-	Help  = pflag.Bool("help", false, "Show this help text")
+	Watch     = pflag.Bool("watch", false, "Re-apply the patches (in check mode) to a copy of the decompiled APK whenever the patches change, and build the APK on demand (must be run from the root of the repository)")
+	WatchTree = pflag.String("watch-tree", "", "Apply the patches to an existing copy of the decompiled APK (used by --watch)")
+
+	Config      = pflag.StringP("config", "c", "", "Load flags, the input APK, and patch options from a TOML (or JSON if it ends with .json) config file (flags specified on the command line take precedence)")
+	PrintConfig = pflag.Bool("print-config", false, "Print the effective config (flags, input APK, and patch options) and exit")
+
+	Check    = pflag.Bool("check", false, "Check that all patches apply cleanly and report every failure without building the APK")
+	NoVerify = pflag.Bool("no-verify", false, "Do not check the smali methods added or changed by the patches for references to missing classes, methods, and fields (including framework ones if android.jar is available), invalid descriptors, and out-of-range registers")
+	Quiet    = pflag.BoolP("quiet", "q", false, "Do not show the diff")
+	Help     = pflag.Bool("help", false, "Show this help text")
 )
 
 func main() {
 	pflag.CommandLine.SortFlags = false
+	pflag.CommandLine.MarkHidden("watch-tree")
 	pflag.Parse()
 
-	if *Help || pflag.NArg() != 1 {
-		fmt.Printf("usage: %s [options] APK_PATH\n\noptions:\n%s", os.Args[0], pflag.CommandLine.FlagUsages())
+	if *Config != "" {
+		v, err := loadConfig(*Config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: load config: %v\n", err)
+			os.Exit(1)
+		}
+		input = v
+	}
+	args := pflag.Args()
+	var recordName string
+	if len(args) >= 2 && args[0] == "record" {
+		recordName, args = args[len(args)-1], args[1:len(args)-1]
+	}
+	if len(args) != 0 {
+		input = args[0]
+	}
+	for _, x := range *Set {
+		if err := setOption(x); err != nil {
+			fmt.Fprintf(os.Stderr, "error: set option: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *PrintConfig {
+		if err := printConfig(input); err != nil {
+			fmt.Fprintf(os.Stderr, "error: print config: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *ListPatches {
+		if err := listPatches(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: list patches: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *Help || len(args) > 1 || input == "" || (len(args) != 0 && args[0] == "record") {
+		fmt.Printf("usage: %s [options] [APK_PATH|BUNDLE_PATH|SPLITS_DIR]\n       %s [options] record [APK_PATH|BUNDLE_PATH|SPLITS_DIR] PATCH_NAME\n\noptions:\n%s", os.Args[0], os.Args[0], pflag.CommandLine.FlagUsages())
+		os.Exit(1)
+	}
+
+	if _, err := patchdef.Select(*Enable, *Disable); err != nil {
+		fmt.Fprintf(os.Stderr, "error: select patches: %v\n", err)
 		os.Exit(1)
 	}
 
+	if recordName != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		if err := record(ctx, recordName); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *Watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		if err := watch(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Printf("> Loading extra fonts\n")
 	for _, x := range *AddFonts {
 		n, err := fonts.LoadFrom(os.DirFS(x))
@@ -88,14 +187,17 @@ func main() {
 }
 
 func run(ctx context.Context) error {
-	apk := pflag.Arg(0)
+	input := filepath.Clean(input)
 
-	if _, err := os.Stat(apk); err != nil {
+	if _, err := os.Stat(input); err != nil {
 		return err
 	}
 
 	if *Output == "" {
-		*Output = strings.TrimSuffix(apk, filepath.Ext(apk)) + ".patched.resigned.apk"
+		*Output = strings.TrimSuffix(input, filepath.Ext(input)) + ".patched.resigned.apk"
+		if *KeepSplits && apksplit.IsBundle(input) {
+			*Output += "s"
+		}
 	}
 
 	fmt.Printf("> Creating temp dirs\n")
@@ -116,73 +218,155 @@ func run(ctx context.Context) error {
 	}
 	fmt.Println()
 
-	fmt.Printf("> Looking for keystore %q\n", *Keystore)
-	if _, err := os.Stat(*Keystore); os.IsNotExist(err) {
-		fmt.Printf("> Generating keystore %q\n", *Keystore)
-		cmd := exec.CommandContext(ctx,
-			*Keytool, "-genkeypair", "-v",
-			"-keystore", *Keystore,
-			"-keyalg", "RSA",
-			"-storepass", *KeystorePassphrase,
-			"-alias", *KeystoreAlias,
-			"-validity", "3652",
-			"-dname", "CN=lithiumpatch",
-		)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("keytool: %w", err)
-		}
-	} else if err != nil {
-		return fmt.Errorf("access keystore: %w", err)
+	apk, set, err := openInput(input, apkTmpDir)
+	if err != nil {
+		return err
 	}
+
+	fmt.Printf("> Checking APK %q\n", apk)
+	if err := checkPatched(apk); err != nil {
+		return err
+	}
+	apkHash, err := hashFile(apk)
+	if err != nil {
+		return fmt.Errorf("hash apk: %w", err)
+	}
+	ver, known := detectVersion(apkHash)
 	fmt.Println()
 
-	fmt.Printf("> Reading keystore %q\n", *Keystore)
-	var b bytes.Buffer
-	cmd := exec.Command(
-		"keytool", "-list", "-v",
-		"-keystore", *Keystore,
-		"-keyalg", "RSA",
-		"-storepass", *KeystorePassphrase,
-		"-alias", *KeystoreAlias,
+	var (
+		key        *apksign.Key
+		defaultKey bool
+		enable     = *Enable
+		disable    = *Disable
 	)
-	cmd.Stdout = &b
-	cmd.Stderr = &b
-	if err := cmd.Run(); err != nil {
-		fmt.Println(b.String())
-		return fmt.Errorf("keytool: %w", err)
-	}
-	m := regexp.MustCompile(`SHA1: *([A-Fa-f0-9:]+)`).FindStringSubmatch(b.String())
-	if len(m) != 2 {
-		fmt.Println(b.String())
-		return fmt.Errorf("could not find fingerprint in keytool output")
-	}
-	if m[1] == defaultKeySig {
-		patches.NoSync()
-		fmt.Fprintf(os.Stderr, "Found default signing key. This is insecure and will not support sync. You can specify a custom keystore using the --keystore option.\n")
+	if !*Check {
+		key, err = loadKeystore()
+		if err != nil {
+			return err
+		}
+		if sig := key.Fingerprint(); sig == defaultKeySig {
+			fmt.Fprintf(os.Stderr, "Found default signing key. This is insecure and will not support sync. You can specify a custom keystore using the --keystore option.\n")
+			if len(enable) != 0 {
+				enable = append(slices.Clip(enable), "nosync")
+			}
+			defaultKey = true
+		} else {
+			disable = append(disable, "nosync")
+			fmt.Fprintf(os.Stderr, "Found key with signature %s. This will need to be added as Google APIs app with access to the Drive API for sync to work.\n", sig)
+		}
+		fmt.Println()
+	}
+
+	patchdef.JavaTools.Context = ctx
+	patchdef.JavaTools.Javac = *Javac
+	patchdef.JavaTools.D8 = *D8
+	patchdef.JavaTools.Baksmali = *Apktool
+	patchdef.JavaTools.AndroidJar = *AndroidJar
+	if patchdef.JavaTools.AndroidJar == "" {
+		patchdef.JavaTools.AndroidJar = findAndroidJar()
+	}
+
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	apktoolHash, err := hashFile(*Apktool)
+	if err != nil {
+		return fmt.Errorf("hash apktool: %w", err)
+	}
+	var orig treeHashes
+	if *WatchTree != "" {
+		disTmpDir = *WatchTree
 	} else {
-		fmt.Fprintf(os.Stderr, "Found key with signature %s. This will need to be added as Google APIs app with access to the Drive API for sync to work.\n", m[1])
+		orig, err = decompile(ctx, c, apk, apkHash, apktoolHash, disTmpDir)
+		if err != nil {
+			return err
+		}
+		fmt.Println()
 	}
-	fmt.Println()
 
-	fmt.Printf("> Decompiling APK %q to %q\n", apk, disTmpDir)
-	if err := jar(ctx, *Apktool, "d", "-f", apk, "-o", disTmpDir); err != nil {
-		return fmt.Errorf("apktool: %w", err)
+	if !known {
+		if ver, err = detectVersionFromManifest(disTmpDir); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	patchdef.TargetVersion = ver
+
+	ps, err := patchdef.Select(enable, disable)
+	if err != nil {
+		return fmt.Errorf("select patches: %w", err)
+	}
+	if defaultKey && !slices.ContainsFunc(ps, func(p *patchdef.Patch) bool { return p.Name() == "nosync" }) {
+		return fmt.Errorf("select patches: patch \"nosync\" cannot be disabled when signing with the default key (use --keystore to specify a custom keystore)")
 	}
-	fmt.Println()
 
-	fmt.Printf("> Patching\n")
+	if *Check {
+		fmt.Printf("> Checking patches\n")
+	} else {
+		fmt.Printf("> Patching\n")
+	}
 	diff := new(bytes.Buffer)
-	ps := patchdef.Patches()
+	var (
+		failed  int
+		skipped []string
+		reports []*patchdef.Report
+	)
 	for i, patch := range ps {
+		if *Check {
+			pdiff := new(bytes.Buffer)
+			r, err := patch.CheckReport(disTmpDir, pdiff)
+			reports = append(reports, r)
+			if err != nil {
+				fmt.Printf("[%d/%d] %s: FAIL\n", i+1, len(ps), patch.Name())
+				for _, line := range strings.Split(err.Error(), "\n") {
+					fmt.Printf("    %s\n", line)
+				}
+				failed++
+			} else {
+				fmt.Printf("[%d/%d] %s: ok\n", i+1, len(ps), patch.Name())
+			}
+			if *WatchTree != "" && !*Quiet && pdiff.Len() != 0 {
+				fmt.Println(pdiff.String()) // show it immediately rather than at the end
+			}
+			pdiff.WriteTo(diff)
+			continue
+		}
 		fmt.Printf("[%d/%d] %s\n", i+1, len(ps), patch.Name())
-		if err := patch.Apply(disTmpDir, diff); err != nil {
+		required := patch.Required() || (defaultKey && patch.Name() == "nosync") // sync won't work with the default key
+		if *SkipFailing && !required {
+			if dep := slices.IndexFunc(patch.Requires(), func(n string) bool {
+				return slices.Contains(skipped, n)
+			}); dep != -1 {
+				fmt.Fprintf(os.Stderr, "Warning: skipping patch %q since it requires skipped patch %q\n", patch.Name(), patch.Requires()[dep])
+				skipped = append(skipped, patch.Name())
+				reports = append(reports, &patchdef.Report{Patch: patch.Name(), Skipped: true})
+				continue
+			}
+		}
+		r, err := patch.ApplyReport(disTmpDir, diff)
+		reports = append(reports, r)
+		if err != nil {
+			if *SkipFailing && !required {
+				fmt.Fprintf(os.Stderr, "Warning: skipping patch %q: %v\n", patch.Name(), err)
+				skipped = append(skipped, patch.Name())
+				r.Skipped = true
+				continue
+			}
+			if err := writeReport(apk, reports); err != nil {
+				return err
+			}
 			return fmt.Errorf("apply patch %q: %w", patch.Name(), err)
 		}
 	}
-	if !*Quiet {
+	if err := writeReport(apk, reports); err != nil {
+		return err
+	}
+	if len(skipped) != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d patches: %s\n", len(skipped), strings.Join(skipped, ", "))
+	}
+	if !*Quiet && *WatchTree == "" {
 		fmt.Println(diff.String())
 	}
 	if *Diff != "" {
@@ -192,10 +376,53 @@ func run(ctx context.Context) error {
 	}
 	fmt.Println()
 
+	var verifyFailed bool
+	if !*NoVerify {
+		fmt.Printf("> Verifying smali\n")
+		if err := patchdef.VerifySmali(disTmpDir); err != nil {
+			if !*Check {
+				return fmt.Errorf("verify smali: %w", err)
+			}
+			for _, line := range strings.Split(err.Error(), "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+			verifyFailed = true
+		}
+		fmt.Println()
+	}
+
+	if *Check {
+		if failed != 0 {
+			return fmt.Errorf("%d of %d patches failed", failed, len(ps))
+		}
+		if verifyFailed {
+			return fmt.Errorf("smali verification failed")
+		}
+		fmt.Println("all patches applied successfully")
+		return nil
+	}
+
+	fmt.Printf("> Writing manifest\n")
+	var applied []string
+	for _, patch := range ps {
+		if !slices.Contains(skipped, patch.Name()) {
+			applied = append(applied, patch.Name())
+		}
+	}
+	if err := writeManifest(disTmpDir, manifest{
+		Tool:    toolVersion(),
+		Input:   filepath.Base(input),
+		Version: ver,
+		SHA256:  apkHash,
+		Patches: applied,
+	}); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	fmt.Println()
+
 	apkPatched := filepath.Join(apkTmpDir, "patched.apk")
-	fmt.Printf("> Compiling APK to %q\n", apkPatched)
-	if err := jar(ctx, *Apktool, "b", "-f", disTmpDir, "-o", apkPatched); err != nil {
-		return fmt.Errorf("apktool: %w", err)
+	if err := buildAPK(ctx, c, apk, disTmpDir, apkPatched, orig, apktoolHash); err != nil {
+		return err
 	}
 	fmt.Println()
 
@@ -204,34 +431,163 @@ func run(ctx context.Context) error {
 	if err := os.Rename(apkPatched, apkPatchedBeforeAlign); err != nil {
 		return fmt.Errorf("rename apk: %w", err)
 	}
-	cmd = exec.CommandContext(ctx, *Zipalign, "4", filepath.Join(apkTmpDir, "unaligned.apk"), apkPatched)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if *Zipalign != "" {
+		cmd := exec.CommandContext(ctx, *Zipalign, "-p", "4", apkPatchedBeforeAlign, apkPatched)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("zipalign: %w", err)
+		}
+	} else if err := zipalign.AlignFile(apkPatched, apkPatchedBeforeAlign); err != nil {
+		return fmt.Errorf("zipalign: %w", err)
+	}
+	if err := zipalign.VerifyFile(apkPatched); err != nil {
 		return fmt.Errorf("zipalign: %w", err)
 	}
 	fmt.Println()
 
-	fmt.Printf("> Signing APK %q to %q\n", apkPatched, *Output)
-	if err := jar(ctx,
-		*Apksigner, "sign",
-		"--min-sdk-version", "26",
-		"--ks", *Keystore,
-		"--ks-pass", "pass:"+*KeystorePassphrase,
-		"--pass-encoding", "utf-8",
-		"--ks-key-alias", *KeystoreAlias,
-		"--out", *Output,
-		apkPatched,
-	); err != nil {
-		return fmt.Errorf("apksigner: %w", err)
+	if set != nil && *KeepSplits {
+		apkSigned := filepath.Join(apkTmpDir, "signed.apk")
+		fmt.Printf("> Signing APK %q to %q\n", apkPatched, apkSigned)
+		if err := apksign.SignFile(apkSigned, apkPatched, key); err != nil {
+			return fmt.Errorf("sign apk: %w", err)
+		}
+		fmt.Println()
+
+		fmt.Printf("> Writing APK and re-signed splits to %q\n", *Output)
+		if err := writeSplits(*Output, apkSigned, set, key); err != nil {
+			return fmt.Errorf("write splits: %w", err)
+		}
+		fmt.Println()
+	} else {
+		fmt.Printf("> Signing APK %q to %q\n", apkPatched, *Output)
+		if err := apksign.SignFile(*Output, apkPatched, key); err != nil {
+			return fmt.Errorf("sign apk: %w", err)
+		}
+		fmt.Println()
+	}
+
+	if *OutputBundle != "" {
+		fmt.Printf("> Writing output bundle to %q\n", *OutputBundle)
+		var splits []string
+		if set != nil {
+			for _, s := range set.Splits {
+				splits = append(splits, s.Name)
+			}
+		}
+		if err := writeOutputBundle(*OutputBundle, *Output, diff.Bytes(), newProvenance(input, ver, apkHash, splits, applied, skipped, key)); err != nil {
+			return fmt.Errorf("write output bundle: %w", err)
+		}
+		fmt.Println()
 	}
-	fmt.Println()
 
 	fmt.Println("done")
 	return nil
 }
 
+// writeReport writes the patch reports to the file specified by --report, if
+// any.
+func writeReport(apk string, reports []*patchdef.Report) error {
+	if *Report == "" {
+		return nil
+	}
+	buf, err := json.MarshalIndent(struct {
+		APK     string             `json:"apk"`
+		Check   bool               `json:"check"`
+		Patches []*patchdef.Report `json:"patches"`
+	}{filepath.Base(apk), *Check, reports}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	if err := os.WriteFile(*Report, append(buf, '\n'), 0666); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
+
+// loadKeystore creates the keystore if it doesn't exist, and loads the signing
+// key.
+func loadKeystore() (*apksign.Key, error) {
+	fmt.Printf("> Looking for keystore %q\n", *Keystore)
+	if _, err := os.Stat(*Keystore); os.IsNotExist(err) {
+		fmt.Printf("> Generating keystore %q\n", *Keystore)
+		key, err := apksign.GenerateKeystore(*Keystore, *KeystoreAlias, *KeystorePassphrase, "lithiumpatch", 3652*24*time.Hour)
+		if err != nil {
+			return nil, fmt.Errorf("generate keystore: %w", err)
+		}
+		fmt.Println()
+		return key, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("access keystore: %w", err)
+	}
+	fmt.Println()
+
+	fmt.Printf("> Reading keystore %q\n", *Keystore)
+	key, err := apksign.LoadKeystore(*Keystore, *KeystoreAlias, *KeystorePassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("read keystore: %w", err)
+	}
+	return key, nil
+}
+
+func listPatches() error {
+	ps, err := patchdef.Patches()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, p := range ps {
+		var extra string
+		if p.Required() {
+			extra += " (required)"
+		}
+		if r := p.Requires(); len(r) != 0 {
+			extra += " (requires " + strings.Join(r, ", ") + ")"
+		}
+		if c := p.Conflicts(); len(c) != 0 {
+			extra += " (conflicts with " + strings.Join(c, ", ") + ")"
+		}
+		if v := p.Versions(); len(v) != 0 {
+			extra += " (versions " + strings.Join(v, ", ") + ")"
+		}
+		if p.Disabled() {
+			extra += " (disabled by default)"
+		}
+		fmt.Fprintf(tw, "%s\t%s%s\n", p.Name(), p.Summary(), extra)
+		for _, o := range p.Options() {
+			def, _ := json.Marshal(o.Default)
+			extra := " (default " + elide(string(def), 48) + ")"
+			if cur, _ := json.Marshal(o.Value); !bytes.Equal(cur, def) {
+				extra += " (set to " + elide(string(cur), 48) + ")"
+			}
+			fmt.Fprintf(tw, "  .%s\t%s%s\n", o.Name, o.Help, extra)
+		}
+	}
+	return tw.Flush()
+}
+
+// setOption sets a patch option from a PATCH.OPTION=VALUE string.
+func setOption(x string) error {
+	k, v, ok := strings.Cut(x, "=")
+	if !ok {
+		return fmt.Errorf("%q: expected PATCH.OPTION=VALUE", x)
+	}
+	patch, name, ok := strings.Cut(k, ".")
+	if !ok {
+		return fmt.Errorf("%q: expected PATCH.OPTION=VALUE", x)
+	}
+	return patchdef.SetOptionString(patch, name, v)
+}
+
+// elide truncates s to n characters.
+func elide(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-3]) + "..."
+	}
+	return s
+}
+
 func cleanup(path string, all bool) {
 	fmt.Printf("> Cleaning up %s\n", path)
 	var err error
@@ -245,6 +601,31 @@ func cleanup(path string, all bool) {
 	}
 }
 
+// findAndroidJar finds the android.jar for the latest platform installed in
+// the Android SDK, if any.
+func findAndroidJar() string {
+	for _, env := range []string{"ANDROID_HOME", "ANDROID_SDK_ROOT"} {
+		sdk := os.Getenv(env)
+		if sdk == "" {
+			continue
+		}
+		var (
+			best    string
+			bestVer int
+		)
+		ms, _ := filepath.Glob(filepath.Join(sdk, "platforms", "android-*", "android.jar"))
+		for _, m := range ms {
+			if v, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(filepath.Dir(m)), "android-")); err == nil && v > bestVer {
+				best, bestVer = m, v
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+	return ""
+}
+
 func jar(ctx context.Context, jarfile string, args ...string) error {
 	if _, err := exec.LookPath("java"); err != nil {
 		return fmt.Errorf("could not find java: %v", err)
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+)
+
+// manifestPath is the path of the manifest in the patched APK.
+const manifestPath = "assets/lithiumpatch.json"
+
+// manifest records how an APK was patched.
+type manifest struct {
+	Tool    string   `json:"tool"`    // lithiumpatch version
+	Input   string   `json:"input"`   // input APK filename
+	Version string   `json:"version"` // input APK versionName
+	SHA256  string   `json:"sha256"`  // input APK hash
+	Patches []string `json:"patches"` // applied patches, in order
+}
+
+// toolVersion gets the lithiumpatch version from the build info.
+func toolVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	v := bi.Main.Version
+	var rev, dirty string
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				dirty = "-dirty"
+			}
+		}
+	}
+	if rev != "" && (v == "" || v == "(devel)") {
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		return rev + dirty
+	}
+	if v == "" {
+		return "unknown"
+	}
+	return v
+}
+
+// hashFile gets the hex-encoded SHA-256 of a file.
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeManifest writes the manifest to the decompiled APK.
+func writeManifest(dir string, m manifest) error {
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(dir, filepath.FromSlash(manifestPath))
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(p, append(buf, '\n'), 0666)
+}
+
+// checkPatched returns an error if the APK was already patched.
+func checkPatched(apk string) error {
+	zr, err := zip.OpenReader(apk)
+	if err != nil {
+		return fmt.Errorf("read apk: %w", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name == manifestPath {
+			var m manifest
+			if err := readZipJSON(f, &m); err != nil {
+				return fmt.Errorf("%q was already patched by lithiumpatch (could not read manifest: %v), use the original apk instead", apk, err)
+			}
+			return fmt.Errorf("%q was already patched by lithiumpatch %s from %s (sha256 %s) with patches %s, use the original apk instead", apk, m.Tool, m.Input, m.SHA256, strings.Join(m.Patches, ", "))
+		}
+	}
+
+	// older versions didn't have a manifest, but always added a method to
+	// show the patched version in the settings
+	for _, f := range zr.File {
+		if path.Dir(f.Name) != "." || !strings.HasPrefix(f.Name, "classes") || path.Ext(f.Name) != ".dex" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("read apk: %w", err)
+		}
+		buf, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("read apk: %w", err)
+		}
+		if bytes.Contains(buf, []byte("addPatched")) {
+			return fmt.Errorf("%q was already patched by an older version of lithiumpatch, use the original apk instead", apk)
+		}
+	}
+	return nil
+}
+
+func readZipJSON(f *zip.File, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v)
+}
@@ -1,4 +1,16 @@
 // Package patches contains patches for the Lithium EPUB Reader.
 package patches
 
-import _ "github.com/pgaskin/lithiumpatch/patches/internal" // core patches
+import (
+	"embed"
+
+	_ "github.com/pgaskin/lithiumpatch/patches/internal" // core patches
+	. "github.com/pgaskin/lithiumpatch/patches/patchdef"
+)
+
+//go:embed *.go
+var src embed.FS
+
+func init() {
+	RegisterSource(src)
+}
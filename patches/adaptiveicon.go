@@ -37,7 +37,6 @@ func init() {
 			</vector>
 			`),
 		),
-		DefineR("smali/com/faultexception/reader", "drawable", "ic_launcher_monochrome"),
 	)
 }
 
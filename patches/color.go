@@ -3,23 +3,43 @@
 // Change the app icon color from purple to pale dark blue.
 package patches
 
-import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
+import (
+	"fmt"
+	"io"
+	"regexp"
+
+	. "github.com/pgaskin/lithiumpatch/patches/patchdef"
+)
 
 func init() {
-	Register("color",
-		PatchFile("res/values/colors.xml",
-			ReplaceString(
-				`<color name="app_primary">#ff5f2deb</color>`,
-				`<color name="app_primary">#ff104068</color>`,
-			),
-			ReplaceString(
-				`<color name="app_primary_dark">#ff4a1cc9</color>`,
-				`<color name="app_primary_dark">#ff002b5a</color>`,
-			),
-			ReplaceString(
-				`<color name="ic_launcher_background">#ff784ef1</color>`,
-				`<color name="ic_launcher_background">#ff466a96</color>`,
-			),
-		),
-	)
+	Register("color", &color{
+		Primary:            "#ff104068",
+		PrimaryDark:        "#ff002b5a",
+		LauncherBackground: "#ff466a96",
+	})
+}
+
+type color struct {
+	Primary            string `option:"primary" help:"Primary app color"`
+	PrimaryDark        string `option:"primary_dark" help:"Status bar color"`
+	LauncherBackground string `option:"launcher_background" help:"App icon background color"`
+}
+
+var colorRe = regexp.MustCompile(`^#([0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+func (c *color) Validate() error {
+	for _, x := range []string{c.Primary, c.PrimaryDark, c.LauncherBackground} {
+		if !colorRe.MatchString(x) {
+			return fmt.Errorf("invalid color %q (must be #RRGGBB or #AARRGGBB)", x)
+		}
+	}
+	return nil
+}
+
+func (c *color) Do(apk string, diffwriter io.Writer) error {
+	return PatchFile("res/values/colors.xml",
+		SetText(`color[name=app_primary]`, c.Primary, "#ff5f2deb"),
+		SetText(`color[name=app_primary_dark]`, c.PrimaryDark, "#ff4a1cc9"),
+		SetText(`color[name=ic_launcher_background]`, c.LauncherBackground, "#ff784ef1"),
+	).Do(apk, diffwriter)
 }
@@ -5,24 +5,19 @@
 // view (after splitting by the default width).
 package patches
 
-import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	. "github.com/pgaskin/lithiumpatch/patches/patchdef"
+)
 
 func init() {
 	Register("coversize",
-		// size breakpoints for cover grid
-		// note: padding/gap is 5
-		PatchFile("res/values-sw364dp/dimens.xml",
-			ReplaceString(
-				`<dimen name="bookshelf_cover_width">160.0dip</dimen>`,
-				`<dimen name="bookshelf_cover_width">115.0dip</dimen>`,
-			),
-		),
-		PatchFile("res/values-sw480dp/dimens.xml",
-			ReplaceString(
-				`<dimen name="bookshelf_cover_width">180.0dip</dimen>`,
-				`<dimen name="bookshelf_cover_width">115.0dip</dimen>`,
-			),
-		),
+		&coversize{
+			Width: 115,
+		},
 		// the base width to determine the number of columns is set in code like AutoFitRecyclerView.setSpanWidth(bookshelf_cover_width)
 		PatchFile("smali/com/faultexception/reader/BooksFragment.smali",
 			MustContain(
@@ -134,3 +129,28 @@ func init() {
 		),
 	)
 }
+
+type coversize struct {
+	Width float64 `option:"width" help:"Cover width in dp on screens at least 364dp wide (the number of columns is based on this)"`
+}
+
+func (c *coversize) Validate() error {
+	if c.Width < 50 || c.Width > 500 {
+		return fmt.Errorf("cover width %g is not between 50 and 500", c.Width)
+	}
+	return nil
+}
+
+func (c *coversize) Do(apk string, diffwriter io.Writer) error {
+	// size breakpoints for cover grid
+	// note: padding/gap is 5
+	width := strconv.FormatFloat(c.Width, 'f', 1, 64) + "dip"
+	if err := PatchFile("res/values-sw364dp/dimens.xml",
+		SetText(`dimen[name=bookshelf_cover_width]`, width, "160.0dip"),
+	).Do(apk, diffwriter); err != nil {
+		return err
+	}
+	return PatchFile("res/values-sw480dp/dimens.xml",
+		SetText(`dimen[name=bookshelf_cover_width]`, width, "180.0dip"),
+	).Do(apk, diffwriter)
+}
@@ -8,10 +8,7 @@ import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 func init() {
 	Register("coversonly",
 		PatchFile("res/xml/preferences.xml",
-			ReplaceStringAppend(
-				"\n"+`    <PreferenceCategory android:title="@string/pref_category_advanced">`,
-				"\n"+`        <SwitchPreferenceCompat android:title="Hide footer (grid view)" android:key="only_covers" android:defaultValue="false" />`,
-			),
+			AddPreference(`@string/pref_category_advanced`, `<SwitchPreferenceCompat android:title="Hide footer (grid view)" android:key="only_covers" android:defaultValue="false" />`),
 		),
 		PatchFile("smali/com/faultexception/reader/BooksAdapter.smali",
 			InMethod("onBindViewHolder(Lcom/faultexception/reader/BooksAdapter$ViewHolder;I)V",
@@ -6,9 +6,12 @@ package patches
 import (
 	_ "embed"
 	"encoding/xml"
+	"fmt"
 	"html"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -22,6 +25,7 @@ var dictionaryJS []byte
 
 func init() {
 	Register("dictionary",
+		Requires("public_hcwv_ctx"),
 		Build("assets/dict"),
 		PatchFile("smali/com/faultexception/reader/content/HtmlContentWebView.smali",
 			InMethod("getResponseForUrl(Ljava/lang/String;)Landroid/webkit/WebResourceResponse;",
@@ -187,7 +191,6 @@ func init() {
 				return strings.ReplaceAll(s, "{{dicts}}", x.String()), nil
 			}),
 		),
-		DefineR("smali/com/faultexception/reader", "array", "dict_names"),
 		PatchFile("res/xml/preferences.xml",
 			ReplaceStringPrepend(
 				FixIndent("\n"+`
@@ -318,12 +321,35 @@ func init() {
 type Build string
 
 func (b Build) Do(apk string, diffwriter io.Writer) error {
-	p := filepath.Join(apk, filepath.Clean(filepath.FromSlash(string(b))))
-	if err := os.Mkdir(p, 0777); err != nil {
+	if _, err := os.Stat(filepath.Join(apk, filepath.Clean(filepath.FromSlash(string(b))))); err == nil {
+		return fmt.Errorf("build dictionaries: %q already exists", string(b))
+	}
+
+	// build to a temp dir first so the output gets staged with the rest of the patch
+	tmp, err := os.MkdirTemp("", "lithiumpatch-dict")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := dict.Build(tmp); err != nil {
 		return err
 	}
-	if err := dict.Build(p); err != nil {
+	if err := filepath.WalkDir(tmp, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(tmp, p)
+		if err != nil {
+			return err
+		}
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return WriteFile(path.Join(string(b), filepath.ToSlash(rel)), buf).Do(apk, diffwriter)
+	}); err != nil {
 		return err
 	}
-	return WriteFile(filepath.Join(string(b), "dict.js"), dict.JS()).Do(apk, diffwriter)
+	return WriteFile(path.Join(string(b), "dict.js"), dict.JS()).Do(apk, diffwriter)
 }
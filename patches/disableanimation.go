@@ -8,10 +8,7 @@ import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 func init() {
 	Register("disableanimation",
 		PatchFile("res/xml/preferences.xml",
-			ReplaceStringAppend(
-				"\n"+`    <PreferenceCategory android:title="@string/pref_category_navigation">`,
-				"\n"+`        <SwitchPreferenceCompat android:title="Disable page turn animation" android:key="no_page_turn_animation" android:defaultValue="false" />`,
-			),
+			AddPreference(`@string/pref_category_navigation`, `<SwitchPreferenceCompat android:title="Disable page turn animation" android:key="no_page_turn_animation" android:defaultValue="false" />`),
 		),
 		PatchFile("smali/com/faultexception/reader/content/HtmlContentWebView.smali",
 			ReplaceStringAppend(
@@ -4,13 +4,16 @@
 package patches
 
 import (
+	"fmt"
 	"io"
+	"regexp"
+	"slices"
 
 	. "github.com/pgaskin/lithiumpatch/patches/patchdef"
 )
 
 func init() {
-	Register("extrathemes", extrathemes{
+	Register("extrathemes", &extrathemes{Themes: []theme{
 		/* sync_id                                dark     bg       text      link    name */
 		{"60c8419a-ca5a-427f-b92a-79e69b2745bb", true, 0x000000, 0xC1A23D, 0xF0DE69, "Sepia Dark"},
 		{"f20ec453-c903-4dcd-a61c-d06a1567c37b", true, 0x000000, 0x735E13, 0x7D7121, "Sepia Dark Dimmed"},
@@ -18,30 +21,56 @@ func init() {
 		{"397903d7-b301-45c3-8064-fed7a2618e25", true, 0x1D2021, 0xEBDBB2, 0xFF705D, "Ash"},
 		{"298fb36c-b91e-4e73-9ef5-3a23b0300666", true, 0x013151, 0xE1FFF0, 0x96F069, "Ocean"},
 		{"3ef38676-5531-4351-b20a-a83359e8b546", true, 0xDDF3FF, 0x003193, 0x2D7FFF, "Ice"},
-	})
+	}})
 }
 
 type theme struct {
-	SyncID     string
-	Dark       bool
-	Background uint32
-	Text       uint32
-	Link       uint32
-	Name       string
+	SyncID     string `json:"sync_id"`
+	Dark       bool   `json:"dark"`
+	Background uint32 `json:"background"`
+	Text       uint32 `json:"text"`
+	Link       uint32 `json:"link"`
+	Name       string `json:"name"`
 }
 
-type extrathemes []theme
+type extrathemes struct {
+	Themes []theme `option:"themes" help:"Themes to add (sync_id must be a unique UUID, and colors are RGB integers)"`
+}
+
+var builtinThemeIDs = []string{
+	"04fd477e-bdbb-4dea-8f38-6bead547a00b",
+	"f9715217-d3bb-41e3-974c-71e0ffaeee0b",
+	"4948c360-f7cb-42b7-af6a-cf3431145f41",
+}
 
-func (ts extrathemes) Do(apk string, diffwriter io.Writer) error {
-	builtinThemeIDs := []string{
-		"04fd477e-bdbb-4dea-8f38-6bead547a00b",
-		"f9715217-d3bb-41e3-974c-71e0ffaeee0b",
-		"4948c360-f7cb-42b7-af6a-cf3431145f41",
+var uuidRe = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+func (ts *extrathemes) Validate() error {
+	seen := map[string]bool{}
+	for _, t := range ts.Themes {
+		if !uuidRe.MatchString(t.SyncID) {
+			return fmt.Errorf("theme %q: invalid sync id %q (must be a lowercase UUID)", t.Name, t.SyncID)
+		}
+		if seen[t.SyncID] || slices.Contains(builtinThemeIDs, t.SyncID) {
+			return fmt.Errorf("theme %q: duplicate sync id %q", t.Name, t.SyncID)
+		}
+		seen[t.SyncID] = true
+		if t.Name == "" {
+			return fmt.Errorf("theme %q: missing name", t.SyncID)
+		}
+		for _, c := range []uint32{t.Background, t.Text, t.Link} {
+			if c > 0xFFFFFF {
+				return fmt.Errorf("theme %q: invalid color %#x (must be RGB)", t.Name, c)
+			}
+		}
 	}
+	return nil
+}
 
+func (ts *extrathemes) Do(apk string, diffwriter io.Writer) error {
 	// ThemesTable fixedSyncIds
-	fixedSyncIDs := builtinThemeIDs
-	for _, t := range ts {
+	fixedSyncIDs := slices.Clone(builtinThemeIDs)
+	for _, t := range ts.Themes {
 		fixedSyncIDs = append(fixedSyncIDs, t.SyncID)
 	}
 	PatchFile("smali/com/faultexception/reader/db/ThemesTable.smali",
@@ -203,7 +232,7 @@ func (ts extrathemes) Do(apk string, diffwriter io.Writer) error {
 				invoke-virtual {v0}, Landroid/database/sqlite/SQLiteDatabase;->endTransaction()V
 				throw v1
 			.end method
-			`, map[string]any{"Themes": ts, "SyncIDs": fixedSyncIDs})),
+			`, map[string]any{"Themes": ts.Themes, "SyncIDs": fixedSyncIDs})),
 		),
 		InMethod("getThemes()Ljava/util/List;",
 			ReplaceStringAppend(
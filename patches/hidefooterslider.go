@@ -9,10 +9,7 @@ func init() {
 	Register("hidefooterslider",
 		// add toggle in settings
 		PatchFile("res/xml/preferences.xml",
-			ReplaceStringAppend(
-				"\n"+`    <PreferenceCategory android:title="@string/pref_category_advanced">`,
-				"\n"+`        <SwitchPreferenceCompat android:title="Hide footer slider (reader)" android:key="hide_reader_footer" android:defaultValue="false" />`,
-			),
+			AddPreference(`@string/pref_category_advanced`, `<SwitchPreferenceCompat android:title="Hide footer slider (reader)" android:key="hide_reader_footer" android:defaultValue="false" />`),
 		),
 
 		// hide only the page slider when enabled
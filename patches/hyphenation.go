@@ -262,10 +262,7 @@ func init() {
 		),
 		// note: we don't need the onTextAlignChanged stuff in ReaderActivity$7 since we can only change this from settings, not the display popup
 		PatchFile("res/xml/preferences.xml",
-			ReplaceStringAppend(
-				"\n"+`    <PreferenceCategory android:title="@string/pref_category_advanced">`,
-				"\n"+`        <SwitchPreferenceCompat android:title="Use hyphenation" android:key="hyphenation" android:defaultValue="true" />`,
-			),
+			AddPreference(`@string/pref_category_advanced`, `<SwitchPreferenceCompat android:title="Use hyphenation" android:key="hyphenation" android:defaultValue="true" />`),
 		),
 	)
 }
@@ -1,2 +1,15 @@
 // Package internal contains core patches.
 package internal
+
+import (
+	"embed"
+
+	. "github.com/pgaskin/lithiumpatch/patches/patchdef"
+)
+
+//go:embed *.go
+var src embed.FS
+
+func init() {
+	RegisterSource(src)
+}
@@ -10,6 +10,7 @@ import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 
 func init() {
 	Register("cleanupunused",
+		Required(),
 		DeleteFile("smali/com/google/api/client/testing/json/package-info.smali"),
 		DeleteFile("smali/com/google/api/client/testing/json/MockJsonFactory.smali"),
 		DeleteFile("smali/com/google/api/client/testing/json/MockJsonGenerator.smali"),
@@ -9,6 +9,7 @@ import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 
 func init() {
 	Register("minsdk",
+		Required(),
 		PatchFile("apktool.yml",
 			ReplaceString(
 				`minSdkVersion: '16'`,
@@ -7,6 +7,7 @@ import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 
 func init() {
 	Register("nofeedback",
+		Required(),
 		PatchFile("res/menu/reader.xml",
 			ReplaceString(
 				`<item android:id="@id/feedback" android:title="@string/action_feedback" app:showAsAction="never" />`,
@@ -1,12 +1,14 @@
+// # Pro features
+//
+// Lithium isn't available on the Play Store anymore as of April 2025, so always
+// unlock pro features.
 package internal
 
 import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 
-// Lithium isn't available on the Play Store anymore as of April 2025, so always
-// unlock pro features.
-
 func init() {
 	Register("prv",
+		Required(),
 		PatchFile("smali/com/faultexception/reader/model/ProManager.smali",
 			InMethod("setUnlockedState(Landroid/app/Activity;Z)V",
 				ReplaceWith(FixIndent(`
@@ -7,6 +7,7 @@ import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 
 func init() {
 	Register("public_hcwv_ctx",
+		Required(),
 		PatchFile("smali/com/faultexception/reader/content/HtmlContentWebView.smali",
 			ReplaceString(
 				`.field private mContext:Landroid/content/Context;`,
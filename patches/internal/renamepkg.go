@@ -1,18 +1,51 @@
-//go:build renamepkg
-
 // # Rename package
 //
-// Optionally rename the package based on the renamepkg build tag.
+// Optionally rename the package so the patched app can be installed alongside
+// the original one (disabled by default, enable with --enable renamepkg).
 package internal
 
-import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	. "github.com/pgaskin/lithiumpatch/patches/patchdef"
+)
 
 func init() {
-	const (
-		newpkg = "com.faultexception.reader.patched"
-		bkpdir = "LithiumPatchedBackups"
-	)
 	Register("renamepkg",
+		Disabled(),
+		&renamepkg{
+			Package:   "com.faultexception.reader.patched",
+			BackupDir: "LithiumPatchedBackups",
+		},
+	)
+}
+
+type renamepkg struct {
+	Package   string `option:"package" help:"New package name"`
+	BackupDir string `option:"backup_dir" help:"Backup directory name (so backups don't conflict with the original app)"`
+}
+
+var packageRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*(\.[a-zA-Z][a-zA-Z0-9_]*)+$`)
+
+func (r *renamepkg) Validate() error {
+	if !packageRe.MatchString(r.Package) {
+		return fmt.Errorf("invalid package name %q", r.Package)
+	}
+	if r.Package == "com.faultexception.reader" {
+		return fmt.Errorf("package name must be different from the original one")
+	}
+	if r.BackupDir == "" || r.BackupDir == "LithiumBackups" || strings.ContainsAny(r.BackupDir, `/\"<>&`) {
+		return fmt.Errorf("invalid backup directory name %q", r.BackupDir)
+	}
+	return nil
+}
+
+func (r *renamepkg) Do(apk string, diffwriter io.Writer) error {
+	newpkg, bkpdir := r.Package, r.BackupDir
+	for _, inst := range []Instruction{
 		PatchFile("apktool.yml",
 			ReplaceString(
 				`renameManifestPackage: null`,
@@ -57,5 +90,10 @@ func init() {
 				`"`+newpkg+`"`,
 			),
 		),
-	)
+	} {
+		if err := inst.Do(apk, diffwriter); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,14 @@
+// # Resource IDs
+//
+// Allocate IDs for the resources added by other patches.
+package internal
+
+import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
+
+func init() {
+	Register("resources",
+		Required(),
+		After("*"),
+		AllocateResourceIDs("smali/com/faultexception/reader"),
+	)
+}
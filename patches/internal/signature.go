@@ -8,6 +8,7 @@ import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 
 func init() {
 	Register("signatures",
+		Required(),
 		PatchFile("smali/com/faultexception/reader/model/ProManager.smali",
 			InMethod("checkIfNecessary(Landroid/app/Activity;)V",
 				ReplaceString(
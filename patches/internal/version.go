@@ -7,6 +7,7 @@ import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 
 func init() {
 	Register("version",
+		Required(),
 		PatchFile("smali/com/faultexception/reader/SettingsActivity$PreferencesFragment.smali",
 			InMethod("onCreatePreferences(Landroid/os/Bundle;Ljava/lang/String;)V",
 				ReplaceStringPrepend(
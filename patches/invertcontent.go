@@ -7,19 +7,19 @@ import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 
 func init() {
 	Register("invertcontent",
+		Versions("0.24.5"),
+
 		WriteFileString("res/drawable/ic_image_24dp.xml", FixIndent(`
 			<vector xmlns:android="http://schemas.android.com/apk/res/android" android:width="24dp" android:height="24dp" android:viewportWidth="24" android:viewportHeight="24">
 				<path android:fillColor="#ff000000" android:pathData="M21,19V5c0,-1.1 -0.9,-2 -2,-2H5c-1.1,0 -2,0.9 -2,2v14c0,1.1 0.9,2 2,2h14c1.1,0 2,-0.9 2,-2zM8.5,13.5l2.5,3.01L14.5,12l4.5,6H5l3.5,-4.5z"/>
 			</vector>
 		`)),
-		DefineR("smali/com/faultexception/reader", "drawable", "ic_image_24dp"),
 
 		WriteFileString("res/drawable/ic_article_24dp.xml", FixIndent(`
 			<vector xmlns:android="http://schemas.android.com/apk/res/android" android:width="24dp" android:height="24dp" android:viewportWidth="24" android:viewportHeight="24">
 				<path android:fillColor="#ff000000" android:pathData="M19,3L5,3c-1.1,0 -2,0.9 -2,2v14c0,1.1 0.9,2 2,2h14c1.1,0 2,-0.9 2,-2L21,5c0,-1.1 -0.9,-2 -2,-2zM14,17L7,17v-2h7v2zM17,13L7,13v-2h10v2zM17,9L7,9L7,7h10v2z"/>
 			</vector>
 		`)),
-		DefineR("smali/com/faultexception/reader", "drawable", "ic_article_24dp"),
 
 		PatchFiles([]string{
 			"res/layout/fragment_display_settings.xml",
@@ -46,16 +46,14 @@ func init() {
 					</LinearLayout>
 			`),
 		)),
-		DefineR("smali/com/faultexception/reader", "id", "content_invert"),
-		DefineR("smali/com/faultexception/reader", "id", "content_invert_value"),
-		DefineR("smali/com/faultexception/reader", "id", "content_invert_image"),
-		DefineR("smali/com/faultexception/reader", "id", "content_invert_page"),
-		PatchFile("res/values/ids.xml", ReplaceStringPrepend("\n"+`</resources>`, "\n"+`    <item type="id" name="content_invert" />`)),
-		PatchFile("res/values/ids.xml", ReplaceStringPrepend("\n"+`</resources>`, "\n"+`    <item type="id" name="content_invert_value" />`)),
-		PatchFile("res/values/ids.xml", ReplaceStringPrepend("\n"+`</resources>`, "\n"+`    <item type="id" name="content_invert_image" />`)),
-		PatchFile("res/values/ids.xml", ReplaceStringPrepend("\n"+`</resources>`, "\n"+`    <item type="id" name="content_invert_page" />`)),
-
-		PatchFile("smali/com/faultexception/reader/ReaderActivity.smali",
+		PatchFile("res/values/ids.xml", AddValue("id", "content_invert", "")),
+		PatchFile("res/values/ids.xml", AddValue("id", "content_invert_value", "")),
+		PatchFile("res/values/ids.xml", AddValue("id", "content_invert_image", "")),
+		PatchFile("res/values/ids.xml", AddValue("id", "content_invert_page", "")),
+
+		// the anchor contains the resource ID of the default margin, which
+		// changes between versions
+		ForVersion([]string{"0.24.5"}, PatchFile("smali/com/faultexception/reader/ReaderActivity.smali",
 			InMethod("updateFeaturesForBookView()V",
 				ReplaceStringAppend(
 					// margin is always applied
@@ -104,7 +102,7 @@ func init() {
 					`),
 				),
 			),
-		),
+		)),
 
 		PatchFile("smali/com/faultexception/reader/DisplaySettingsFragment.smali",
 			ReplaceStringAppend(
@@ -15,14 +15,9 @@ func init() {
 			</vector>
 			`),
 		),
-		DefineR("smali/com/faultexception/reader", "drawable", "ic_rotate_alt"),
 		PatchFile("res/values/ids.xml",
-			ReplaceStringPrepend(
-				"\n"+`</resources>`,
-				"\n"+`    <item type="id" name="invert_rotation" />`,
-			),
+			AddValue("id", "invert_rotation", ""),
 		),
-		DefineR("smali/com/faultexception/reader", "id", "invert_rotation"),
 		PatchFile("res/menu/reader.xml",
 			ReplaceStringPrepend(
 				"\n"+`    <item android:id="@id/settings"`,
@@ -3,20 +3,41 @@
 // Allow smaller font sizes to be selected.
 package patches
 
-import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
+import (
+	"fmt"
+	"io"
+
+	. "github.com/pgaskin/lithiumpatch/patches/patchdef"
+)
 
 func init() {
-	Register("minsize",
-		PatchFile("smali/com/faultexception/reader/DisplaySettingsFragment.smali",
-			InConstant("TEXT_SIZE_MIN:I",
-				ReplaceString("0x50", "0x3c"),
-			),
-			InMethod("update()V",
-				ReplaceString("0x50", "0x3c"),
-			),
-			InMethod("onClick(Landroid/view/View;)V",
-				ReplaceString("0x50", "0x3c"),
-			),
+	Register("minsize", &minsize{
+		Min: 60,
+	})
+}
+
+type minsize struct {
+	Min int `option:"min" help:"Minimum font size in percent (the original minimum is 80)"`
+}
+
+func (m *minsize) Validate() error {
+	if m.Min < 10 || m.Min >= 80 {
+		return fmt.Errorf("minimum font size %d is not between 10 and 79", m.Min)
+	}
+	return nil
+}
+
+func (m *minsize) Do(apk string, diffwriter io.Writer) error {
+	size := fmt.Sprintf("%#x", m.Min)
+	return PatchFile("smali/com/faultexception/reader/DisplaySettingsFragment.smali",
+		InConstant("TEXT_SIZE_MIN:I",
+			ReplaceString("0x50", size),
+		),
+		InMethod("update()V",
+			ReplaceString("0x50", size),
+		),
+		InMethod("onClick(Landroid/view/View;)V",
+			ReplaceString("0x50", size),
 		),
-	)
+	).Do(apk, diffwriter)
 }
@@ -0,0 +1,28 @@
+package patches
+
+import (
+	"testing"
+
+	"github.com/pgaskin/lithiumpatch/patches/patchdef"
+)
+
+func TestMinsizeValidate(t *testing.T) {
+	for _, tc := range []struct {
+		min int
+		ok  bool
+	}{
+		{9, false},
+		{10, true},
+		{60, true},
+		{79, true},
+		{80, false}, // the original minimum, so nothing would be replaced
+		{100, false},
+	} {
+		if err := (&minsize{Min: tc.min}).Validate(); (err == nil) != tc.ok {
+			t.Errorf("%d: expected valid=%t, got %v", tc.min, tc.ok, err)
+		}
+	}
+	if err := patchdef.SetOption("minsize", "min", 80); err == nil {
+		t.Errorf("expected error when setting the option to the original minimum")
+	}
+}
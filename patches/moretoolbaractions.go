@@ -9,14 +9,8 @@ import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 func init() {
 	Register("moretoolbaractions",
 		PatchFile("res/menu/reader.xml",
-			ReplaceString(
-				`android:title="@string/action_search" app:showAsAction="ifRoom"`,
-				`android:title="@string/action_search" app:showAsAction="always"`,
-			),
-			ReplaceString(
-				`android:title="@string/action_add_bookmark" app:showAsAction="ifRoom"`,
-				`android:title="@string/action_add_bookmark" app:showAsAction="always"`,
-			),
+			SetAttr(`item[android:title="@string/action_search"]`, "app:showAsAction", "always", "ifRoom"),
+			SetAttr(`item[android:title="@string/action_add_bookmark"]`, "app:showAsAction", "always", "ifRoom"),
 		),
 	)
 }
@@ -1,12 +1,14 @@
 // # Disable sync
 //
 // If built with the default keystore, disables the sync functionality since it
-// isn't available unless a signing key is registered with Google APIs.
+// isn't available unless a signing key is registered with Google APIs. This
+// patch is automatically disabled when using a custom keystore, and is always
+// applied when using the default one.
 package patches
 
 import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 
-func NoSync() {
+func init() {
 	Register("nosync",
 		PatchFile("res/xml/sync.xml",
 			ReplaceString(
@@ -0,0 +1,357 @@
+package patchdef
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// stubClass is the declaration of a smali class.
+type stubClass struct {
+	Name       string // internal name, e.g., com/faultexception/reader/R$id
+	Access     uint16
+	Super      string
+	Interfaces []string
+	Fields     []stubMember
+	Methods    []stubMember
+
+	InnerName   string // empty if not an inner class, "null" if anonymous
+	InnerAccess uint16
+	Outer       string // enclosing class, if a member class
+}
+
+type stubMember struct {
+	Access uint16
+	Name   string
+	Desc   string
+}
+
+var smaliAccessFlags = map[string]uint16{
+	"public":       0x0001,
+	"private":      0x0002,
+	"protected":    0x0004,
+	"static":       0x0008,
+	"final":        0x0010,
+	"synchronized": 0x0020,
+	"volatile":     0x0040,
+	"bridge":       0x0040,
+	"transient":    0x0080,
+	"varargs":      0x0080,
+	"native":       0x0100,
+	"interface":    0x0200,
+	"abstract":     0x0400,
+	"strictfp":     0x0800,
+	"synthetic":    0x1000,
+	"annotation":   0x2000,
+	"enum":         0x4000,
+}
+
+func smaliAccess(flags []string) uint16 {
+	var a uint16
+	for _, f := range flags {
+		a |= smaliAccessFlags[f] // constructor and declared-synchronized are dex-only
+	}
+	return a
+}
+
+// parseStubClass parses the declarations from a smali class.
+func parseStubClass(s string) (*stubClass, error) {
+	var (
+		c     stubClass
+		annot string
+		depth int // 1 inside a method
+	)
+	for _, l := range strings.Split(s, "\n") {
+		lf := strings.Fields(l)
+		if len(lf) == 0 {
+			continue
+		}
+		switch {
+		case lf[0] == ".end" && len(lf) > 1 && (lf[1] == "method" || lf[1] == "field"):
+			depth = 0
+		case depth != 0:
+		case lf[0] == ".class":
+			c.Name = strings.TrimSuffix(strings.TrimPrefix(lf[len(lf)-1], "L"), ";")
+			c.Access = smaliAccess(lf[1 : len(lf)-1])
+		case lf[0] == ".super":
+			c.Super = strings.TrimSuffix(strings.TrimPrefix(lf[1], "L"), ";")
+		case lf[0] == ".implements":
+			c.Interfaces = append(c.Interfaces, strings.TrimSuffix(strings.TrimPrefix(lf[1], "L"), ";"))
+		case lf[0] == ".field":
+			var f stubMember
+			for i, x := range lf[1:] {
+				if name, desc, ok := strings.Cut(x, ":"); ok {
+					f.Access = smaliAccess(lf[1 : i+1])
+					f.Name, f.Desc = name, desc
+					break
+				}
+			}
+			if f.Name == "" {
+				return nil, fmt.Errorf("invalid field %q", l)
+			}
+			c.Fields = append(c.Fields, f)
+		case lf[0] == ".method":
+			name, desc, ok := strings.Cut(lf[len(lf)-1], "(")
+			if !ok {
+				return nil, fmt.Errorf("invalid method %q", l)
+			}
+			c.Methods = append(c.Methods, stubMember{smaliAccess(lf[1 : len(lf)-1]), name, "(" + desc})
+			depth = 1
+		case lf[0] == ".annotation" && len(lf) == 3:
+			annot = lf[2]
+		case lf[0] == ".end" && len(lf) > 1 && lf[1] == "annotation":
+			annot = ""
+		case annot == "Ldalvik/annotation/InnerClass;" && len(lf) == 3 && lf[0] == "name":
+			c.InnerName = strings.Trim(lf[2], `"`)
+		case annot == "Ldalvik/annotation/InnerClass;" && len(lf) == 3 && lf[0] == "accessFlags":
+			v, err := strconv.ParseUint(lf[2], 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid inner class access flags %q", lf[2])
+			}
+			c.InnerAccess = uint16(v)
+		case annot == "Ldalvik/annotation/EnclosingClass;" && len(lf) == 3 && lf[0] == "value":
+			c.Outer = strings.TrimSuffix(strings.TrimPrefix(lf[2], "L"), ";")
+		}
+	}
+	if c.Name == "" {
+		return nil, fmt.Errorf("missing class declaration")
+	}
+	if c.Access&0x0200 == 0 {
+		c.Access |= 0x0020 // ACC_SUPER
+	}
+	if c.Access&0x0004 != 0 {
+		c.Access = c.Access&^0x0004 | 0x0001 // protected inner classes are public at the class file level
+	}
+	c.Access &^= 0x0002 | 0x0008 // neither are private and static
+	return &c, nil
+}
+
+// writeStubJar writes a jar containing class files with the declarations (but
+// not the code) of the classes so Java code can be compiled against them.
+func writeStubJar(w io.Writer, classes []*stubClass) error {
+	members := map[string][]*stubClass{}
+	for _, c := range classes {
+		if c.Outer != "" && c.InnerName != "" && c.InnerName != "null" {
+			members[c.Outer] = append(members[c.Outer], c)
+		}
+	}
+	zw := zip.NewWriter(w)
+	for _, c := range classes {
+		f, err := zw.Create(c.Name + ".class")
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(c.classFile(members[c.Name])); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// classFile generates a class file for the stub.
+func (c *stubClass) classFile(members []*stubClass) []byte {
+	var (
+		pool  bytes.Buffer
+		count uint16 = 1
+		utf8s        = map[string]uint16{}
+		refs         = map[string]uint16{}
+	)
+	utf8 := func(s string) uint16 {
+		if i, ok := utf8s[s]; ok {
+			return i
+		}
+		pool.WriteByte(1)
+		binary.Write(&pool, binary.BigEndian, uint16(len(s)))
+		pool.WriteString(s)
+		utf8s[s] = count
+		count++
+		return utf8s[s]
+	}
+	class := func(s string) uint16 {
+		if s == "" {
+			return 0
+		}
+		if i, ok := refs[s]; ok {
+			return i
+		}
+		n := utf8(s)
+		pool.WriteByte(7)
+		binary.Write(&pool, binary.BigEndian, n)
+		refs[s] = count
+		count++
+		return refs[s]
+	}
+
+	var body bytes.Buffer
+	u16 := func(v uint16) {
+		binary.Write(&body, binary.BigEndian, v)
+	}
+	u16(c.Access)
+	u16(class(c.Name))
+	u16(class(c.Super))
+	u16(uint16(len(c.Interfaces)))
+	for _, x := range c.Interfaces {
+		u16(class(x))
+	}
+	for _, ms := range [][]stubMember{c.Fields, c.Methods} {
+		u16(uint16(len(ms)))
+		for _, m := range ms {
+			u16(m.Access)
+			u16(utf8(m.Name))
+			u16(utf8(m.Desc))
+			u16(0)
+		}
+	}
+
+	var inner []*stubClass
+	if c.InnerName != "" {
+		inner = append(inner, c)
+	}
+	inner = append(inner, members...)
+	slices.SortStableFunc(inner, func(a, b *stubClass) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	if len(inner) == 0 {
+		u16(0)
+	} else {
+		u16(1)
+		u16(utf8("InnerClasses"))
+		binary.Write(&body, binary.BigEndian, uint32(2+8*len(inner)))
+		u16(uint16(len(inner)))
+		for _, x := range inner {
+			u16(class(x.Name))
+			u16(class(x.Outer))
+			if x.InnerName == "null" {
+				u16(0)
+			} else {
+				u16(utf8(x.InnerName))
+			}
+			u16(x.InnerAccess)
+		}
+	}
+
+	var b bytes.Buffer
+	b.Write([]byte{0xCA, 0xFE, 0xBA, 0xBE})
+	binary.Write(&b, binary.BigEndian, uint16(0))  // minor
+	binary.Write(&b, binary.BigEndian, uint16(52)) // major (java 8)
+	binary.Write(&b, binary.BigEndian, count)
+	pool.WriteTo(&b)
+	body.WriteTo(&b)
+	return b.Bytes()
+}
+
+// parseClassFile parses the declarations from a class file.
+func parseClassFile(b []byte) (*stubClass, error) {
+	var err error
+	u1 := func() uint8 {
+		if len(b) < 1 {
+			err = io.ErrUnexpectedEOF
+			return 0
+		}
+		v := b[0]
+		b = b[1:]
+		return v
+	}
+	u2 := func() uint16 {
+		if len(b) < 2 {
+			err = io.ErrUnexpectedEOF
+			return 0
+		}
+		v := binary.BigEndian.Uint16(b)
+		b = b[2:]
+		return v
+	}
+	skip := func(n int) {
+		if len(b) < n {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+		b = b[n:]
+	}
+
+	if len(b) < 8 || !bytes.Equal(b[:4], []byte{0xCA, 0xFE, 0xBA, 0xBE}) {
+		return nil, fmt.Errorf("not a class file")
+	}
+	b = b[8:]
+
+	var (
+		n     = int(u2())
+		utf8s = make([]string, n)
+		class = make([]uint16, n)
+	)
+	for i := 1; i < n && err == nil; i++ {
+		switch tag := u1(); tag {
+		case 1: // Utf8
+			l := int(u2())
+			if len(b) < l {
+				return nil, io.ErrUnexpectedEOF
+			}
+			utf8s[i] = string(b[:l])
+			b = b[l:]
+		case 7: // Class
+			class[i] = u2()
+		case 8, 16, 19, 20: // String, MethodType, Module, Package
+			skip(2)
+		case 15: // MethodHandle
+			skip(3)
+		case 3, 4, 9, 10, 11, 12, 17, 18: // Integer, Float, Fieldref, Methodref, InterfaceMethodref, NameAndType, Dynamic, InvokeDynamic
+			skip(4)
+		case 5, 6: // Long, Double
+			skip(8)
+			i++
+		default:
+			return nil, fmt.Errorf("invalid constant pool tag %d", tag)
+		}
+	}
+	str := func(i uint16) string {
+		if int(i) < n {
+			return utf8s[i]
+		}
+		return ""
+	}
+	cls := func(i uint16) string {
+		if int(i) < n {
+			return str(class[i])
+		}
+		return ""
+	}
+
+	var c stubClass
+	c.Access = u2()
+	c.Name = cls(u2())
+	c.Super = cls(u2())
+	for range u2() {
+		c.Interfaces = append(c.Interfaces, cls(u2()))
+	}
+	for _, ms := range []*[]stubMember{&c.Fields, &c.Methods} {
+		for range u2() {
+			m := stubMember{Access: u2(), Name: str(u2()), Desc: str(u2())}
+			for range u2() {
+				skip(2)
+				if len(b) < 4 {
+					return nil, io.ErrUnexpectedEOF
+				}
+				skip(4 + int(binary.BigEndian.Uint32(b)))
+			}
+			*ms = append(*ms, m)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Name == "" {
+		return nil, fmt.Errorf("missing class name")
+	}
+	return &c, nil
+}
+
+// isSmaliDir returns true if name is a top-level smali directory in a
+// decompiled APK (e.g., smali, smali_classes2).
+func isSmaliDir(name string) bool {
+	return name == "smali" || strings.HasPrefix(name, "smali_")
+}
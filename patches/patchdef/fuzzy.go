@@ -0,0 +1,312 @@
+package patchdef
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSuggestLines is the maximum number of anchor lines to find the closest
+// match for.
+const maxSuggestLines = 500
+
+// linePatcher is a StringPatcher which can include line numbers relative to
+// the file in errors when patching part of it (i.e., in InMethod).
+type linePatcher interface {
+	patchLines(s string, line int) (string, error)
+}
+
+// isDebugDirective checks if a smali line only contains debug information.
+func isDebugDirective(l string) bool {
+	f := strings.Fields(l)
+	if len(f) == 0 {
+		return false
+	}
+	switch f[0] {
+	case ".line", ".local", ".prologue", ".epilogue", ".source":
+		return true
+	case ".end", ".restart":
+		return len(f) >= 2 && f[1] == "local"
+	}
+	return false
+}
+
+// normalizeLine collapses whitespace in a line.
+func normalizeLine(l string) string {
+	return strings.Join(strings.Fields(l), " ")
+}
+
+// fuzzyLine is a line used for fuzzy matching.
+type fuzzyLine struct {
+	N    int    // line number
+	Text string // original text
+	Key  string // normalized text
+}
+
+// fuzzyLines splits s (which starts at the specified line) into lines with the
+// keys returned by key, skipping blank lines and debug directives.
+func fuzzyLines(s string, line int, key func(string) string) []fuzzyLine {
+	var ls []fuzzyLine
+	for _, l := range nonBlankLines(s, line, key) {
+		if !isDebugDirective(l.Text) {
+			ls = append(ls, l)
+		}
+	}
+	return ls
+}
+
+// nonBlankLines splits s (which starts at the specified line) into lines with
+// the keys returned by key, skipping blank lines.
+func nonBlankLines(s string, line int, key func(string) string) []fuzzyLine {
+	var ls []fuzzyLine
+	for i, l := range strings.Split(s, "\n") {
+		if k := key(l); k != "" {
+			ls = append(ls, fuzzyLine{line + i, l, k})
+		}
+	}
+	return ls
+}
+
+// suggest finds the region of s (which starts at the specified line) most
+// similar to find (ignoring debug directives, and comparing lines using the
+// keys returned by key, which must return an empty string for blank lines),
+// and describes the differences. It returns an empty string if nothing similar
+// was found.
+func suggest(s, find string, line int, key func(string) string) string {
+	a := fuzzyLines(find, 1, key)
+	if len(a) == 0 || len(a) > maxSuggestLines {
+		return ""
+	}
+	b := fuzzyLines(s, line, key)
+	if len(b) == 0 {
+		return ""
+	}
+
+	// find the window with the most lines in common (regardless of order)
+	n := min(len(a), len(b))
+	want := map[string]int{}
+	for _, l := range a {
+		want[l.Key]++
+	}
+	var (
+		have      = map[string]int{}
+		cur, best int
+		bestAt    = -1
+	)
+	for i, l := range b {
+		if have[l.Key] < want[l.Key] {
+			cur++
+		}
+		have[l.Key]++
+		if i >= n {
+			r := b[i-n].Key
+			have[r]--
+			if have[r] < want[r] {
+				cur--
+			}
+		}
+		if i >= n-1 && cur > best {
+			best, bestAt = cur, i-n+1
+		}
+	}
+	if bestAt == -1 || best*2 < len(a) {
+		return ""
+	}
+
+	// trim lines not in the anchor from the ends of the window
+	win := b[bestAt : bestAt+n]
+	for len(win) > 1 && want[win[0].Key] == 0 {
+		win = win[1:]
+	}
+	for len(win) > 1 && want[win[len(win)-1].Key] == 0 {
+		win = win[:len(win)-1]
+	}
+
+	// include the debug directives in the region, and the ones before or
+	// after it if the anchor starts or ends with them
+	var (
+		anchor = nonBlankLines(find, 1, key)
+		lines  = strings.Split(s, "\n")
+		first  = win[0].N - line
+		last   = win[len(win)-1].N - line
+	)
+	if isDebugDirective(anchor[0].Text) {
+		for first > 0 && (normalizeLine(lines[first-1]) == "" || isDebugDirective(lines[first-1])) {
+			first--
+		}
+	}
+	if isDebugDirective(anchor[len(anchor)-1].Text) {
+		for last < len(lines)-1 && (normalizeLine(lines[last+1]) == "" || isDebugDirective(lines[last+1])) {
+			last++
+		}
+	}
+	region := nonBlankLines(strings.Join(lines[first:last+1], "\n"), line+first, key)
+	first, last = region[0].N, region[len(region)-1].N
+
+	var b2 strings.Builder
+	if best == len(a) {
+		fmt.Fprintf(&b2, "\nfound a similar match at lines %d-%d (ignoring debug directives and whitespace):", first, last)
+	} else {
+		fmt.Fprintf(&b2, "\nclosest match at lines %d-%d (ignoring debug directives and whitespace):", first, last)
+	}
+	b2.WriteString(sideBySide(anchor, region))
+	return b2.String()
+}
+
+// sideBySide formats a line-by-line comparison of the anchor and the region of
+// the file, aligning lines which are identical other than whitespace.
+func sideBySide(a, b []fuzzyLine) string {
+	// longest common subsequence
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i].Key == b[j].Key {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type row struct {
+		mark        byte
+		left, right string
+		n           int
+	}
+	var rows []row
+	var dela, delb []fuzzyLine
+	flush := func() {
+		for k := range max(len(dela), len(delb)) {
+			r := row{mark: '~'}
+			switch {
+			case k >= len(dela):
+				r.mark = '+'
+			case k >= len(delb):
+				r.mark = '-'
+			}
+			if k < len(dela) {
+				r.left = strings.TrimSpace(dela[k].Text)
+			}
+			if k < len(delb) {
+				r.right, r.n = strings.TrimSpace(delb[k].Text), delb[k].N
+			}
+			rows = append(rows, r)
+		}
+		dela, delb = dela[:0], delb[:0]
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i].Key == b[j].Key:
+			flush()
+			rows = append(rows, row{' ', strings.TrimSpace(a[i].Text), strings.TrimSpace(b[j].Text), b[j].N})
+			i, j = i+1, j+1
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			delb = append(delb, b[j])
+			j++
+		default:
+			dela = append(dela, a[i])
+			i++
+		}
+	}
+	flush()
+
+	const maxWidth = 60
+	width := len("anchor")
+	for _, r := range rows {
+		width = min(max(width, utf8.RuneCountInString(r.left)), maxWidth)
+	}
+	var out strings.Builder
+	fmt.Fprintf(&out, "\n  %-*s | file", width, "anchor")
+	for _, r := range rows {
+		left := r.left
+		if utf8.RuneCountInString(left) > width {
+			left = string([]rune(left)[:width-3]) + "..."
+		}
+		right := r.right
+		if r.n != 0 {
+			right = strconv.Itoa(r.n) + ": " + right
+		}
+		fmt.Fprintf(&out, "\n%c %-*s | %s", r.mark, width, left, right)
+	}
+	return out.String()
+}
+
+// IgnoreDebug makes the string patchers (including ones in [InMethod], [Once],
+// etc) match while ignoring smali debug directives (.line, .local, etc) and
+// differences in whitespace. Appended and prepended strings are inserted
+// relative to the original matched text, but replaced strings (including debug
+// directives in them) are replaced entirely. Other patchers are unchanged.
+func IgnoreDebug(pt StringPatcher) StringPatcher {
+	switch m := pt.(type) {
+	case *stringMatcher:
+		if x := newPatternMatcher(m.find, m.replace, false, true); x != nil {
+			return x
+		}
+	case *containsMatcher:
+		if x := newPatternMatcher(m.find, m.find, false, true); x != nil {
+			return x
+		}
+	case *patternMatcher:
+		if x := newPatternMatcher(m.find, m.replace, m.placeholders, true); x != nil {
+			return x
+		}
+	case *methodMatcher:
+		n := *m
+		n.pt = make([]StringPatcher, len(m.pt))
+		for i, x := range m.pt {
+			n.pt[i] = IgnoreDebug(x)
+		}
+		return &n
+	case *countMatcher:
+		if x, ok := IgnoreDebug(m.m).(matcher); ok {
+			n := *m
+			n.m = x
+			return &n
+		}
+	}
+	return pt
+}
+
+// fuzzyRegexp compiles a regexp matching s while ignoring debug directives and
+// whitespace differences, using quote to convert each whitespace-separated
+// field. It returns nil if s only contains debug directives or whitespace.
+func fuzzyRegexp(s string, quote func(string) string) *regexp.Regexp {
+	const (
+		ws   = `[ \t]*`
+		skip = `(?:[ \t]*(?:\.line|\.local|\.end[ \t]+local|\.restart[ \t]+local|\.prologue|\.epilogue|\.source)(?:[ \t][^\n]*)?\n|[ \t]*\n)*`
+	)
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		if k := normalizeLine(l); k != "" && !isDebugDirective(l) {
+			f := strings.Fields(l)
+			for i := range f {
+				f[i] = quote(f[i])
+			}
+			lines = append(lines, strings.Join(f, `[ \t]+`))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	switch t := strings.TrimLeft(s, " \t"); {
+	case strings.HasPrefix(t, "\n"):
+		b.WriteString(`\n` + skip + ws)
+	case len(t) != len(s):
+		b.WriteString(`[ \t]+`)
+	}
+	b.WriteString(strings.Join(lines, ws+`\n`+skip+ws))
+	switch t := strings.TrimRight(s, " \t"); {
+	case strings.HasSuffix(t, "\n"):
+		b.WriteString(ws + `\n`)
+	case len(t) != len(s):
+		b.WriteString(`[ \t]+`)
+	}
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,284 @@
+package patchdef
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// JavaTools contains the external tools used by [CompileJava]. It should be set
+// by the main program.
+var JavaTools = struct {
+	Context    context.Context // stops the tools when done (if not nil)
+	Java       string          // java executable
+	Javac      string          // javac executable
+	D8         string          // d8 executable (from the Android build tools)
+	Baksmali   string          // jar containing baksmali (apktool bundles it)
+	AndroidJar string          // android.jar (from the Android SDK platform)
+}{
+	Java:  "java",
+	Javac: "javac",
+	D8:    "d8",
+}
+
+type javaInst struct {
+	Smali string
+	FS    fs.FS
+}
+
+// CompileJava compiles the Java source files in fsys (e.g.,
+// com/faultexception/reader/Example.java) against the app's classes, then adds
+// the resulting smali to the specified smali directory (e.g., smali_classes2).
+// It is an error if any of the compiled classes already exist.
+func CompileJava(smali string, fsys fs.FS) Instruction {
+	return &javaInst{smali, fsys}
+}
+
+func (j *javaInst) String() string {
+	return "CompileJava(" + j.Smali + ")"
+}
+
+func (j *javaInst) Do(apk string, diffwriter io.Writer) error {
+	if JavaTools.AndroidJar == "" {
+		return errors.New("compile java: android.jar not set")
+	}
+	if JavaTools.Baksmali == "" {
+		return errors.New("compile java: baksmali not set")
+	}
+
+	tmp, err := os.MkdirTemp("", "lithiumpatch-java")
+	if err != nil {
+		return fmt.Errorf("compile java: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	var srcs []string
+	if err := fs.WalkDir(j.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(p) != ".java" {
+			return err
+		}
+		buf, err := fs.ReadFile(j.FS, p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(tmp, "src", filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+			return err
+		}
+		srcs = append(srcs, dst)
+		return os.WriteFile(dst, buf, 0666)
+	}); err != nil {
+		return fmt.Errorf("compile java: read sources: %w", err)
+	}
+	if len(srcs) == 0 {
+		return errors.New("compile java: no sources found")
+	}
+
+	stubs := filepath.Join(tmp, "stubs.jar")
+	if err := j.stubs(apk, diffwriter, stubs); err != nil {
+		return fmt.Errorf("compile java: generate stubs: %w", err)
+	}
+
+	classes := filepath.Join(tmp, "classes")
+	if err := javaRun(JavaTools.Javac, append([]string{
+		"-source", "8",
+		"-target", "8",
+		"-encoding", "UTF-8",
+		"-g:source,lines",
+		"-nowarn",
+		"-Xlint:-options",
+		"-bootclasspath", JavaTools.AndroidJar,
+		"-classpath", stubs,
+		"-d", classes,
+	}, srcs...)...); err != nil {
+		return fmt.Errorf("compile java: javac: %w", err)
+	}
+
+	var cls []string
+	if err := filepath.WalkDir(classes, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && filepath.Ext(p) == ".class" {
+			cls = append(cls, p)
+		}
+		return err
+	}); err != nil {
+		return fmt.Errorf("compile java: %w", err)
+	}
+
+	minAPI, err := minSdkVersion(apk, diffwriter)
+	if err != nil {
+		return fmt.Errorf("compile java: %w", err)
+	}
+
+	dex := filepath.Join(tmp, "dex")
+	if err := os.Mkdir(dex, 0777); err != nil {
+		return fmt.Errorf("compile java: %w", err)
+	}
+	if err := javaRun(JavaTools.D8, append([]string{
+		"--release",
+		"--min-api", strconv.Itoa(minAPI),
+		"--lib", JavaTools.AndroidJar,
+		"--classpath", stubs,
+		"--output", dex,
+	}, cls...)...); err != nil {
+		return fmt.Errorf("compile java: d8: %w", err)
+	}
+
+	out := filepath.Join(tmp, "smali")
+	if err := javaRun(JavaTools.Java,
+		"-cp", JavaTools.Baksmali, "com.android.tools.smali.baksmali.Main",
+		"d", "-o", out, filepath.Join(dex, "classes.dex"),
+	); err != nil {
+		return fmt.Errorf("compile java: baksmali: %w", err)
+	}
+
+	var errs []error
+	if err := filepath.WalkDir(out, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(out, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if dir, ok := j.exists(apk, diffwriter, rel); ok {
+			errs = append(errs, fmt.Errorf("compile java: class %s already exists in %s", strings.TrimSuffix(rel, ".smali"), dir))
+			return nil
+		}
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return WriteFile(path.Join(j.Smali, rel), buf).Do(apk, diffwriter)
+	}); err != nil {
+		return fmt.Errorf("compile java: %w", err)
+	}
+	return errors.Join(errs...)
+}
+
+// stubs generates a jar with the declarations of the app's classes, including
+// any staged changes.
+func (j *javaInst) stubs(apk string, diffwriter io.Writer, name string) error {
+	var files []string
+	dirs, err := os.ReadDir(apk)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || !isSmaliDir(d.Name()) {
+			continue
+		}
+		if err := filepath.WalkDir(filepath.Join(apk, d.Name()), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || filepath.Ext(p) != ".smali" {
+				return err
+			}
+			rel, err := filepath.Rel(apk, p)
+			if err == nil {
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	if t := txFor(apk, diffwriter); t != nil {
+		for _, f := range t.order {
+			if dir, _, _ := strings.Cut(f, "/"); isSmaliDir(dir) && path.Ext(f) == ".smali" && !slices.Contains(files, f) {
+				files = append(files, f)
+			}
+		}
+	}
+
+	var classes []*stubClass
+	for _, f := range files {
+		buf, err := readFile(apk, diffwriter, f)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // deleted
+		}
+		if err != nil {
+			return err
+		}
+		c, err := parseStubClass(string(buf))
+		if err != nil {
+			return fmt.Errorf("%s: %w", f, err)
+		}
+		classes = append(classes, c)
+	}
+
+	var b bytes.Buffer
+	if err := writeStubJar(&b, classes); err != nil {
+		return err
+	}
+	return os.WriteFile(name, b.Bytes(), 0666)
+}
+
+var apktoolMinSdk = regexp.MustCompile(`(?m)^\s*minSdkVersion:\s*'?(\d+)'?\s*$`)
+
+// MinSdkVersion gets the minimum SDK version from the apktool.yml in the
+// decompiled APK.
+func MinSdkVersion(apk string) (int, error) {
+	return minSdkVersion(apk, nil)
+}
+
+// minSdkVersion is like [MinSdkVersion], but includes any staged changes.
+func minSdkVersion(apk string, diffwriter io.Writer) (int, error) {
+	buf, err := readFile(apk, diffwriter, "apktool.yml")
+	if err != nil {
+		return 0, err
+	}
+	m := apktoolMinSdk.FindSubmatch(buf)
+	if m == nil {
+		return 0, errors.New("could not find minSdkVersion in apktool.yml")
+	}
+	return strconv.Atoi(string(m[1]))
+}
+
+// exists checks if a smali file exists in any smali directory.
+func (j *javaInst) exists(apk string, diffwriter io.Writer, rel string) (string, bool) {
+	dirs, _ := os.ReadDir(apk)
+	for _, d := range dirs {
+		if d.IsDir() && isSmaliDir(d.Name()) {
+			if _, err := readFile(apk, diffwriter, path.Join(d.Name(), rel)); err == nil {
+				return d.Name(), true
+			}
+		}
+	}
+	if t := txFor(apk, diffwriter); t != nil {
+		for _, f := range t.order {
+			if dir, r, _ := strings.Cut(f, "/"); isSmaliDir(dir) && r == rel && t.files[f] != nil {
+				return dir, true
+			}
+		}
+	}
+	return "", false
+}
+
+// javaRun runs a tool, including its output in the error if it fails.
+func javaRun(name string, arg ...string) error {
+	ctx := JavaTools.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var buf bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	if err := cmd.Run(); err != nil {
+		if out := strings.TrimSpace(buf.String()); out != "" {
+			return fmt.Errorf("%w\n%s", err, out)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package patchdef
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// matcher is a StringPatcher which finds matches, then patches them.
+// [Once], [Times], [AtLeast], and [Nth] can be used to restrict which matches
+// are patched.
+type matcher interface {
+	StringPatcher
+
+	// match finds the matches in s, returning their offsets and a function to
+	// patch the matches with the specified indexes. If there aren't any
+	// matches, the error from PatchString should be returned as-is.
+	match(s string) (offsets []int, patch func(idx []int) (string, error))
+
+	// describe describes what is matched for error messages.
+	describe() string
+}
+
+// patchAll patches all matches of m in s, returning the error from notFound if
+// there aren't any.
+func patchAll(m matcher, s string, notFound func() error) (string, error) {
+	offsets, patch := m.match(s)
+	if len(offsets) == 0 {
+		return s, notFound()
+	}
+	idx := make([]int, len(offsets))
+	for i := range idx {
+		idx[i] = i
+	}
+	return patch(idx)
+}
+
+// stringMatcher replaces a string.
+type stringMatcher struct {
+	find, replace string
+}
+
+func (m *stringMatcher) PatchString(s string) (string, error) {
+	return m.patchLines(s, 1)
+}
+
+func (m *stringMatcher) patchLines(s string, line int) (string, error) {
+	return patchAll(m, s, func() error {
+		return fmt.Errorf("could not find %q%s", m.find, suggest(s, m.find, line, normalizeLine))
+	})
+}
+
+func (m *stringMatcher) describe() string {
+	return strconv.Quote(m.find)
+}
+
+func (m *stringMatcher) match(s string) ([]int, func([]int) (string, error)) {
+	var offsets []int
+	for i := 0; i <= len(s); {
+		j := strings.Index(s[i:], m.find)
+		if j == -1 {
+			break
+		}
+		offsets = append(offsets, i+j)
+		if m.find == "" {
+			break
+		}
+		i += j + len(m.find)
+	}
+	return offsets, func(idx []int) (string, error) {
+		var b strings.Builder
+		var last int
+		for _, i := range idx {
+			b.WriteString(s[last:offsets[i]])
+			b.WriteString(m.replace)
+			last = offsets[i] + len(m.find)
+		}
+		b.WriteString(s[last:])
+		return b.String(), nil
+	}
+}
+
+// regexpMatcher replaces a regular expression.
+type regexpMatcher struct {
+	find    *regexp.Regexp
+	replace string
+	literal bool
+}
+
+func (m *regexpMatcher) PatchString(s string) (string, error) {
+	return patchAll(m, s, func() error {
+		return fmt.Errorf("could not find %q", m.find.String())
+	})
+}
+
+func (m *regexpMatcher) describe() string {
+	return strconv.Quote(m.find.String())
+}
+
+func (m *regexpMatcher) match(s string) ([]int, func([]int) (string, error)) {
+	var (
+		ms      = m.find.FindAllStringSubmatchIndex(s, -1)
+		offsets = make([]int, len(ms))
+	)
+	for i, x := range ms {
+		offsets[i] = x[0]
+	}
+	return offsets, func(idx []int) (string, error) {
+		var b []byte
+		var last int
+		for _, i := range idx {
+			b = append(b, s[last:ms[i][0]]...)
+			if m.literal {
+				b = append(b, m.replace...)
+			} else {
+				b = m.find.ExpandString(b, m.replace, s, ms[i])
+			}
+			last = ms[i][1]
+		}
+		return string(append(b, s[last:]...)), nil
+	}
+}
+
+// containsMatcher checks that a string is present.
+type containsMatcher struct {
+	find string
+}
+
+func (m *containsMatcher) PatchString(s string) (string, error) {
+	return m.patchLines(s, 1)
+}
+
+func (m *containsMatcher) patchLines(s string, line int) (string, error) {
+	return patchAll(m, s, func() error {
+		return fmt.Errorf("could not find %q%s", m.find, suggest(s, m.find, line, normalizeLine))
+	})
+}
+
+func (m *containsMatcher) describe() string {
+	return strconv.Quote(m.find)
+}
+
+func (m *containsMatcher) match(s string) ([]int, func([]int) (string, error)) {
+	offsets, _ := (&stringMatcher{find: m.find}).match(s)
+	return offsets, func([]int) (string, error) {
+		return s + "\n", nil // hack to be able to use in InMethod
+	}
+}
+
+// methodMatcher applies patchers to the body of a smali method.
+type methodMatcher struct {
+	class  string // if not empty, the class descriptor must match too
+	method string
+	pt     []StringPatcher
+}
+
+func (m *methodMatcher) PatchString(s string) (string, error) {
+	return patchAll(m, s, func() error {
+		return fmt.Errorf("could not find method %q", m.name())
+	})
+}
+
+func (m *methodMatcher) name() string {
+	if m.class != "" {
+		return m.class + "->" + m.method
+	}
+	return m.method
+}
+
+func (m *methodMatcher) describe() string {
+	return "method " + strconv.Quote(m.name())
+}
+
+// bodies finds the offsets of the matching method declarations, and the
+// offsets of their bodies (the lines between the declaration and the end).
+func (m *methodMatcher) bodies(smali string) (decl []int, body [][2]int) {
+	var (
+		class   string
+		cmethod bool
+		start   int
+	)
+	for off := 0; off < len(smali); {
+		l := smali[off:]
+		if i := strings.IndexByte(l, '\n'); i != -1 {
+			l = l[:i+1]
+		}
+		next := off + len(l)
+
+		lf := strings.Fields(l)
+		if len(lf) >= 1 && lf[0] == ".class" {
+			class = lf[len(lf)-1]
+		} else if len(lf) >= 1 && lf[0] == ".method" {
+			if cmethod = lf[len(lf)-1] == m.method && (m.class == "" || m.class == class); cmethod {
+				decl = append(decl, off)
+				start = next
+			}
+		} else if len(lf) >= 2 && lf[0] == ".end" && lf[1] == "method" {
+			if cmethod {
+				body = append(body, [2]int{start, off})
+			}
+			cmethod = false
+		}
+		off = next
+	}
+	if len(body) != len(decl) {
+		decl = decl[:len(body)] // unterminated method
+	}
+	return decl, body
+}
+
+func (m *methodMatcher) match(smali string) ([]int, func([]int) (string, error)) {
+	decl, _ := m.bodies(smali)
+	return decl, func(idx []int) (string, error) {
+		var errs []error
+		for _, x := range m.pt {
+			decl, body := m.bodies(smali) // the offsets change after each patcher
+			if len(body) != len(decl) {
+				errs = append(errs, fmt.Errorf("could not find method %q", m.name()))
+				continue
+			}
+			var (
+				out   = smali
+				shift int
+				crepl bool
+				err   error
+			)
+			for _, i := range idx {
+				if i >= len(body) {
+					err = fmt.Errorf("could not find method %q", m.name())
+					break
+				}
+				var (
+					b      = body[i]
+					chunk  = smali[b[0]:b[1]]
+					nchunk string
+				)
+				if lp, ok := x.(linePatcher); ok {
+					nchunk, err = lp.patchLines(chunk, 1+strings.Count(smali[:b[0]], "\n"))
+				} else {
+					nchunk, err = x.PatchString(chunk)
+				}
+				if err != nil {
+					err = fmt.Errorf("could not run patcher in method %q (line %d): %w", m.name(), lineAt(smali, decl[i]), err)
+					break
+				}
+				if chunk != nchunk {
+					crepl = true
+				}
+				out = out[:b[0]+shift] + nchunk + out[b[1]+shift:]
+				shift += len(nchunk) - len(chunk)
+			}
+			if err == nil && !crepl {
+				err = fmt.Errorf("identical output") // NOTE: this may not be an error in some cases, change this?
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			smali = out
+		}
+		return smali, errors.Join(errs...)
+	}
+}
+
+// Once applies pt to the only match, returning an error if it matches more or
+// less than once.
+func Once(pt StringPatcher) StringPatcher {
+	return Times(1, pt)
+}
+
+// Times applies pt to all matches, returning an error if it does not match
+// exactly n times.
+func Times(n int, pt StringPatcher) StringPatcher {
+	return countPatcher(pt, func(c int) (bool, string) {
+		if n == 1 {
+			return c == n, "exactly 1 match"
+		}
+		return c == n, fmt.Sprintf("exactly %d matches", n)
+	}, nil)
+}
+
+// AtLeast applies pt to all matches, returning an error if there are less than
+// n matches.
+func AtLeast(n int, pt StringPatcher) StringPatcher {
+	return countPatcher(pt, func(c int) (bool, string) {
+		if n == 1 {
+			return c >= n, "at least 1 match"
+		}
+		return c >= n, fmt.Sprintf("at least %d matches", n)
+	}, nil)
+}
+
+// Nth applies pt to the nth match (starting from 1, or from the end if
+// negative), returning an error if there aren't enough matches.
+func Nth(n int, pt StringPatcher) StringPatcher {
+	if n == 0 {
+		panic("Nth: n must not be zero")
+	}
+	abs := max(n, -n)
+	return countPatcher(pt, func(c int) (bool, string) {
+		if abs == 1 {
+			return c >= abs, "at least 1 match"
+		}
+		return c >= abs, fmt.Sprintf("at least %d matches", abs)
+	}, func(c int) int {
+		if n < 0 {
+			return c + n
+		}
+		return n - 1
+	})
+}
+
+// countPatcher checks the number of matches of pt, then patches all matches,
+// or only the one with the index returned by sel if it is not nil.
+func countPatcher(pt StringPatcher, ok func(c int) (bool, string), sel func(c int) int) StringPatcher {
+	m, isMatcher := pt.(matcher)
+	if !isMatcher {
+		panic(fmt.Sprintf("%T does not support match counts", pt))
+	}
+	return &countMatcher{m, ok, sel}
+}
+
+type countMatcher struct {
+	m   matcher
+	ok  func(c int) (bool, string)
+	sel func(c int) int
+}
+
+func (c *countMatcher) PatchString(s string) (string, error) {
+	return c.patchLines(s, 1)
+}
+
+// patchLines patches s, which starts at the specified line number of the file
+// for error messages.
+func (c *countMatcher) patchLines(s string, line int) (string, error) {
+	offsets, patch := c.m.match(s)
+	if good, want := c.ok(len(offsets)); !good {
+		if len(offsets) == 0 {
+			return s, fmt.Errorf("expected %s of %s, found none%s", want, c.m.describe(), c.suggest(s, line))
+		}
+		lines := make([]string, len(offsets))
+		for i, off := range offsets {
+			lines[i] = strconv.Itoa(lineAt(s, off) + line - 1)
+		}
+		if len(lines) == 1 {
+			return s, fmt.Errorf("expected %s of %s, found 1 (line %s)", want, c.m.describe(), lines[0])
+		}
+		return s, fmt.Errorf("expected %s of %s, found %d (lines %s)", want, c.m.describe(), len(offsets), strings.Join(lines, ", "))
+	}
+	if c.sel != nil {
+		return patch([]int{c.sel(len(offsets))})
+	}
+	idx := make([]int, len(offsets))
+	for i := range idx {
+		idx[i] = i
+	}
+	return patch(idx)
+}
+
+// suggest describes the closest match if nothing was found.
+func (c *countMatcher) suggest(s string, line int) string {
+	switch m := c.m.(type) {
+	case *stringMatcher:
+		return suggest(s, m.find, line, normalizeLine)
+	case *containsMatcher:
+		return suggest(s, m.find, line, normalizeLine)
+	case *patternMatcher:
+		return suggest(s, m.find, line, m.lineKey)
+	}
+	return ""
+}
+
+// lineAt gets the line number of the first non-newline character at or after
+// off in s.
+func lineAt(s string, off int) int {
+	for off < len(s) && s[off] == '\n' {
+		off++
+	}
+	return 1 + strings.Count(s[:off], "\n")
+}
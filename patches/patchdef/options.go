@@ -0,0 +1,187 @@
+package patchdef
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// option is a patch option.
+//
+// An instruction which is a pointer to a struct with fields tagged with
+// `option:"name"` defines options for the patch it is registered with. The
+// instruction should read the fields when it is applied rather than when it is
+// registered. A `help` tag describes the option. Options are converted to and
+// from JSON-compatible values, so structs and slices of structs can be used.
+//
+// If the instruction has a `Validate() error` method, it is called after an
+// option is set, and the option is reverted if it returns an error. The
+// defaults must be valid.
+type option struct {
+	Name  string
+	Help  string
+	Inst  Instruction     // the instruction defining the option
+	Value reflect.Value   // the field
+	Def   json.RawMessage // the initial value
+}
+
+// OptionInfo describes a patch option.
+type OptionInfo struct {
+	Name    string
+	Help    string
+	Value   any // the current value as a JSON-compatible value
+	Default any // the default value as a JSON-compatible value
+}
+
+// findOptions gets the options defined by an instruction, including ones
+// defined by instructions wrapped by [ForVersion].
+func findOptions(inst Instruction) []*option {
+	if vi, ok := inst.(*versionInst); ok {
+		var opts []*option
+		for _, x := range vi.Inst {
+			opts = append(opts, findOptions(x)...)
+		}
+		return opts
+	}
+	v := reflect.ValueOf(inst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	if err := validate(inst); err != nil {
+		panic(fmt.Sprintf("invalid default options: %v", err))
+	}
+	v = v.Elem()
+
+	var opts []*option
+	for i := range v.NumField() {
+		f := v.Type().Field(i)
+		name, ok := f.Tag.Lookup("option")
+		if !ok {
+			continue
+		}
+		if !f.IsExported() {
+			panic(fmt.Sprintf("option %q: field %s is not exported", name, f.Name))
+		}
+		def, err := json.Marshal(v.Field(i).Interface())
+		if err != nil {
+			panic(fmt.Sprintf("option %q: %v", name, err))
+		}
+		opts = append(opts, &option{
+			Name:  name,
+			Help:  f.Tag.Get("help"),
+			Inst:  inst,
+			Value: v.Field(i),
+			Def:   def,
+		})
+	}
+	return opts
+}
+
+// Options gets the patch options, in the order they were defined.
+func (p Patch) Options() []OptionInfo {
+	var xs []OptionInfo
+	for _, o := range p.options {
+		cur, err := json.Marshal(o.Value.Interface())
+		if err != nil {
+			panic(err) // it could be marshaled when it was registered
+		}
+		xs = append(xs, OptionInfo{
+			Name:    o.Name,
+			Help:    o.Help,
+			Value:   jsonValue(cur),
+			Default: jsonValue(o.Def),
+		})
+	}
+	return xs
+}
+
+func jsonValue(buf []byte) any {
+	var v any
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// SetOption sets a patch option from a JSON-compatible value (e.g., one decoded
+// from a config file). It should be called before the patch is applied.
+func SetOption(patch, name string, value any) error {
+	o, err := findOption(patch, name)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("set %s.%s: %w", patch, name, err)
+	}
+	if err := o.set(buf); err != nil {
+		return fmt.Errorf("set %s.%s: %w", patch, name, err)
+	}
+	return nil
+}
+
+// SetOptionString is like [SetOption], but takes a string (e.g., from the
+// command line). If the option is a string, the value is used as-is. Otherwise,
+// it is parsed as JSON.
+func SetOptionString(patch, name, value string) error {
+	o, err := findOption(patch, name)
+	if err != nil {
+		return err
+	}
+	buf := []byte(value)
+	if o.Value.Kind() == reflect.String {
+		buf, _ = json.Marshal(value)
+	}
+	if err := o.set(buf); err != nil {
+		return fmt.Errorf("set %s.%s: %w", patch, name, err)
+	}
+	return nil
+}
+
+func findOption(patch, name string) (*option, error) {
+	x, ok := patches.Load(patch)
+	if !ok {
+		return nil, fmt.Errorf("unknown patch %q", patch)
+	}
+	p := x.(*Patch)
+	i := slices.IndexFunc(p.options, func(o *option) bool {
+		return o.Name == name
+	})
+	if i == -1 {
+		return nil, fmt.Errorf("patch %q does not have option %q", patch, name)
+	}
+	return p.options[i], nil
+}
+
+// set decodes buf into the option, then validates the instruction.
+func (o *option) set(buf []byte) error {
+	v := reflect.New(o.Value.Type())
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v.Interface()); err != nil {
+		return err
+	}
+	if d.More() {
+		return fmt.Errorf("invalid character after top-level value")
+	}
+	old := reflect.New(o.Value.Type()).Elem()
+	old.Set(o.Value)
+	o.Value.Set(v.Elem())
+	if err := validate(o.Inst); err != nil {
+		o.Value.Set(old)
+		return err
+	}
+	return nil
+}
+
+// validate calls the Validate method of an instruction, if it has one.
+func validate(inst Instruction) error {
+	if v, ok := inst.(interface{ Validate() error }); ok {
+		return v.Validate()
+	}
+	return nil
+}
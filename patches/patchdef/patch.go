@@ -4,18 +4,21 @@ package patchdef
 import (
 	"bufio"
 	"bytes"
-	"encoding/xml"
 	"errors"
 	"fmt"
+	"go/parser"
+	"go/token"
 	"io"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"path"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"text/template"
+	"time"
 	"unicode/utf8"
 
 	"github.com/hexops/gotextdiff"
@@ -25,16 +28,52 @@ import (
 
 var patches sync.Map
 
+var sources sync.Map
+
+// Register registers a patch. Any [Option] instructions set metadata on the
+// patch rather than being applied. Instructions may also define options which
+// can be changed before the patch is applied (see [SetOption]).
 func Register(name string, inst ...Instruction) {
 	if name == "" {
 		panic("missing patch name")
 	}
-	if _, exists := patches.LoadOrStore(name, &Patch{name, inst}); exists {
+	p := &Patch{name: name}
+	if _, file, _, ok := runtime.Caller(1); ok {
+		p.file = file
+	}
+	for _, x := range inst {
+		if o, ok := x.(Option); ok {
+			o(p)
+		} else {
+			p.inst = append(p.inst, x)
+			for _, o := range findOptions(x) {
+				if slices.ContainsFunc(p.options, func(x *option) bool { return x.Name == o.Name }) {
+					panic(fmt.Sprintf("patch %q: duplicate option %q", name, o.Name))
+				}
+				p.options = append(p.options, o)
+			}
+		}
+	}
+	if _, exists := patches.LoadOrStore(name, p); exists {
 		panic(fmt.Sprintf("duplicate patch %q", name))
 	}
 }
 
-func Patches() []*Patch {
+// RegisterSource makes the source code of the calling package available for
+// getting patch documentation. It should be called from the package containing
+// the patches with an [embed.FS] containing the package's Go files.
+func RegisterSource(fsys fs.FS) {
+	_, file, _, ok := runtime.Caller(1)
+	if !ok {
+		panic("could not determine caller")
+	}
+	sources.Store(path.Dir(file), fsys)
+}
+
+// Patches gets the registered patches in the order they should be applied.
+// Patches are sorted by name, then reordered to satisfy [After] and [Before]
+// constraints.
+func Patches() ([]*Patch, error) {
 	var ps []*Patch
 	patches.Range(func(key, value any) bool {
 		ps = append(ps, value.(*Patch))
@@ -43,12 +82,225 @@ func Patches() []*Patch {
 	sort.Slice(ps, func(i, j int) bool {
 		return ps[i].name < ps[j].name
 	})
-	return ps
+
+	idx := map[string]int{}
+	for i, p := range ps {
+		idx[p.name] = i
+	}
+
+	after := make([][]int, len(ps)) // after[i] contains the patches which must be applied before i
+	for i, p := range ps {
+		for _, n := range p.requires {
+			if _, ok := idx[n]; !ok {
+				return nil, fmt.Errorf("patch %q requires unknown patch %q", p.name, n)
+			}
+		}
+		for _, n := range p.conflicts {
+			if _, ok := idx[n]; !ok {
+				return nil, fmt.Errorf("patch %q conflicts with unknown patch %q", p.name, n)
+			}
+		}
+		for _, n := range p.after {
+			js, err := orderPatches(ps, idx, i, n)
+			if err != nil {
+				return nil, fmt.Errorf("patch %q is after %w", p.name, err)
+			}
+			after[i] = append(after[i], js...)
+		}
+		for _, n := range p.before {
+			js, err := orderPatches(ps, idx, i, n)
+			if err != nil {
+				return nil, fmt.Errorf("patch %q is before %w", p.name, err)
+			}
+			for _, j := range js {
+				after[j] = append(after[j], i)
+			}
+		}
+	}
+
+	// depth-first topological sort, visiting in name order so unconstrained
+	// patches stay sorted by name
+	var (
+		sorted = make([]*Patch, 0, len(ps))
+		state  = make([]int, len(ps)) // 0=unvisited 1=visiting 2=done
+		stack  []int
+		visit  func(i int) error
+	)
+	visit = func(i int) error {
+		switch state[i] {
+		case 1:
+			var cycle []string
+			for k := slices.Index(stack, i); k < len(stack); k++ {
+				cycle = append(cycle, ps[stack[k]].name)
+			}
+			cycle = append(cycle, ps[i].name)
+			return fmt.Errorf("patch ordering cycle: %s", strings.Join(cycle, " after "))
+		case 2:
+			return nil
+		}
+		state[i] = 1
+		stack = append(stack, i)
+		deps := slices.Clone(after[i])
+		slices.Sort(deps)
+		for _, j := range deps {
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = 2
+		sorted = append(sorted, ps[i])
+		return nil
+	}
+	for i := range ps {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// orderPatches resolves an [After] or [Before] name for patch i. If the name is
+// a glob pattern, it matches every other patch matching it (possibly none).
+func orderPatches(ps []*Patch, idx map[string]int, i int, name string) ([]int, error) {
+	if !strings.ContainsAny(name, `*?[\`) {
+		j, ok := idx[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown patch %q", name)
+		}
+		return []int{j}, nil
+	}
+	var js []int
+	for j, p := range ps {
+		ok, err := path.Match(name, p.name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid patch pattern %q: %w", name, err)
+		}
+		if ok && j != i {
+			js = append(js, j)
+		}
+	}
+	return js, nil
+}
+
+// Select gets the patches to apply. If enable is not empty, only patches
+// matching at least one of the patterns are selected. Patches matching any of
+// the disable patterns are not selected. Patterns are matched against the patch
+// name using [path.Match], and must match at least one patch. [Disabled] patches
+// are only selected if an enable pattern is exactly their name, and those
+// patterns do not restrict the other patches. Required patches
+// are always selected, and it is an error to disable one by name. It is also an
+// error if a selected patch requires a patch which was not selected, or
+// conflicts with another selected patch. Patches which don't support the
+// [TargetVersion] are not selected, and it is an error to enable one by name or
+// if one is required.
+func Select(enable, disable []string) ([]*Patch, error) {
+	ps, err := Patches()
+	if err != nil {
+		return nil, err
+	}
+
+	// patches which are disabled by default are enabled by name in addition
+	// to the other patches
+	var extra []string
+	enable = slices.DeleteFunc(slices.Clone(enable), func(pat string) bool {
+		if slices.ContainsFunc(ps, func(p *Patch) bool { return p.disabled && p.name == pat }) {
+			extra = append(extra, pat)
+			return true
+		}
+		return false
+	})
+
+	en, err := matchPatches(ps, enable)
+	if err != nil {
+		return nil, err
+	}
+	dis, err := matchPatches(ps, disable)
+	if err != nil {
+		return nil, err
+	}
+	for _, pat := range disable {
+		for _, p := range ps {
+			if p.required && p.name == pat {
+				return nil, fmt.Errorf("patch %q is required and cannot be disabled", p.name)
+			}
+		}
+	}
+
+	var sel []*Patch
+	for _, p := range ps {
+		var enabled bool
+		if p.disabled {
+			enabled = slices.Contains(extra, p.name)
+		} else {
+			enabled = len(enable) == 0 || en[p.name]
+		}
+		if p.required || (enabled && !dis[p.name]) {
+			if !p.Supports(TargetVersion) {
+				if p.required || slices.Contains(enable, p.name) || slices.Contains(extra, p.name) {
+					return nil, fmt.Errorf("patch %q does not support version %s (supports %s)", p.name, TargetVersion, strings.Join(p.versions, ", "))
+				}
+				continue
+			}
+			sel = append(sel, p)
+		}
+	}
+
+	selected := map[string]bool{}
+	for _, p := range sel {
+		selected[p.name] = true
+	}
+	for _, p := range sel {
+		for _, n := range p.requires {
+			if !selected[n] {
+				if q := slices.IndexFunc(ps, func(q *Patch) bool { return q.name == n }); q != -1 && !ps[q].Supports(TargetVersion) {
+					return nil, fmt.Errorf("patch %q requires %q, which does not support version %s", p.name, n, TargetVersion)
+				}
+				return nil, fmt.Errorf("patch %q requires %q, which is disabled", p.name, n)
+			}
+		}
+		for _, n := range p.conflicts {
+			if selected[n] {
+				return nil, fmt.Errorf("patch %q conflicts with %q", p.name, n)
+			}
+		}
+	}
+	return sel, nil
+}
+
+func matchPatches(ps []*Patch, patterns []string) (map[string]bool, error) {
+	m := map[string]bool{}
+	for _, pat := range patterns {
+		var found bool
+		for _, p := range ps {
+			ok, err := path.Match(pat, p.name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid patch pattern %q: %w", pat, err)
+			}
+			if ok {
+				m[p.name] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown patch %q", pat)
+		}
+	}
+	return m, nil
 }
 
 type Patch struct {
-	name string
-	inst []Instruction
+	name      string
+	inst      []Instruction
+	file      string
+	required  bool
+	requires  []string
+	conflicts []string
+	after     []string
+	before    []string
+	versions  []string
+	options   []*option
+	disabled  bool
 }
 
 func (p Patch) String() string {
@@ -59,17 +311,212 @@ func (p Patch) Name() string {
 	return p.name
 }
 
+// Required returns true if the patch cannot be disabled.
+func (p Patch) Required() bool {
+	return p.required
+}
+
+// Disabled returns true if the patch is only applied if it is enabled by name.
+func (p Patch) Disabled() bool {
+	return p.disabled
+}
+
+// Requires gets the names of the patches which must also be selected.
+func (p Patch) Requires() []string {
+	return slices.Clone(p.requires)
+}
+
+// Conflicts gets the names of the patches which cannot also be selected.
+func (p Patch) Conflicts() []string {
+	return slices.Clone(p.conflicts)
+}
+
+// Doc gets the package doc comment from the file the patch was registered in,
+// if available.
+func (p Patch) Doc() string {
+	if p.file == "" {
+		return ""
+	}
+	fsys, ok := sources.Load(path.Dir(p.file))
+	if !ok {
+		return ""
+	}
+	buf, err := fs.ReadFile(fsys.(fs.FS), path.Base(p.file))
+	if err != nil {
+		return ""
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), p.file, buf, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil || f.Doc == nil {
+		return ""
+	}
+	return f.Doc.Text()
+}
+
+// Summary gets the title of the patch doc comment, if available.
+func (p Patch) Summary() string {
+	s, _, _ := strings.Cut(p.Doc(), "\n")
+	return strings.TrimPrefix(s, "# ")
+}
+
+// Apply applies the patch. The changes are staged, and are only written to the
+// APK (and the diff) if every instruction succeeds.
 func (p Patch) Apply(apk string, diffwriter io.Writer) error {
+	_, err := p.ApplyReport(apk, diffwriter)
+	return err
+}
+
+// ApplyReport is like Apply, but also returns a report.
+func (p Patch) ApplyReport(apk string, diffwriter io.Writer) (*Report, error) {
+	r, err := p.run(apk, diffwriter, false)
+	if err != nil {
+		return r, wrapJoined(err, fmt.Sprintf("apply patch %q", p.name))
+	}
+	return r, nil
+}
+
+// Check is like Apply, but continues with the remaining instructions after an
+// error, returning all errors. The changes are only written if there were no
+// errors.
+func (p Patch) Check(apk string, diffwriter io.Writer) error {
+	_, err := p.CheckReport(apk, diffwriter)
+	return err
+}
+
+// CheckReport is like Check, but also returns a report.
+func (p Patch) CheckReport(apk string, diffwriter io.Writer) (*Report, error) {
+	return p.run(apk, diffwriter, true)
+}
+
+func (p Patch) run(apk string, diffwriter io.Writer, all bool) (*Report, error) {
+	var (
+		errs  []error
+		t     = newTx(apk, p.name)
+		r     = &Report{Patch: p.name, Instructions: []*InstructionReport{}}
+		start = time.Now()
+	)
 	for i, inst := range p.inst {
-		if err := inst.Do(apk, diffwriter); err != nil {
-			return fmt.Errorf("apply patch %q: inst %d: %w", p.name, i, err)
+		var (
+			ir    = &InstructionReport{Index: i, Name: instName(inst)}
+			n     = len(t.stats)
+			start = time.Now()
+			err   = inst.Do(apk, t)
+		)
+		ir.Duration = time.Since(start)
+		ir.Files = mergeFileReports(t.stats[n:])
+		for _, f := range ir.Files {
+			ir.Added += f.Added
+			ir.Removed += f.Removed
+		}
+		r.Instructions = append(r.Instructions, ir)
+		if err != nil {
+			err = wrapJoined(err, fmt.Sprintf("inst %d", i))
+			ir.Error = NewErrorReport(err)
+			errs = append(errs, err)
+			if !all {
+				break
+			}
+		} else {
+			ir.OK = true
 		}
 	}
+	err := errors.Join(errs...)
+	if err == nil {
+		err = t.commit(diffwriter)
+	}
+	r.Duration = time.Since(start)
+	for _, ir := range r.Instructions {
+		r.Files = append(r.Files, ir.Files...)
+		r.Added += ir.Added
+		r.Removed += ir.Removed
+	}
+	r.Files = mergeFileReports(r.Files)
+	r.OK = err == nil
+	r.Error = NewErrorReport(err)
+	return r, err
+}
+
+// wrapJoined wraps err with prefix. If err was created by [errors.Join], each
+// error is wrapped individually so every line of the message has the prefix.
+func wrapJoined(err error, prefix string) error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, e := range j.Unwrap() {
+			errs = append(errs, wrapJoined(e, prefix))
+		}
+		return errors.Join(errs...)
+	}
+	return fmt.Errorf("%s: %w", prefix, err)
+}
+
+// Instruction is a step of a patch. The diffwriter passed to Do stages the
+// changes made by the patch so they are only written to the APK if every
+// instruction succeeds.
+//
+// Instructions must not read or write files in the APK directly, since the
+// changes made by earlier instructions of the patch aren't on disk yet, and
+// direct writes can't be rolled back if the patch fails. Instead, use
+// [ReadFile] to read files, and apply the built-in instructions (e.g.,
+// [WriteFile], [PatchFile], [DeleteFile]) with the same apk and diffwriter to
+// change them.
+type Instruction interface {
+	Do(apk string, diffwriter io.Writer) error
+}
+
+// Option is a pseudo-instruction which sets patch metadata when passed to
+// [Register]. It does nothing if applied.
+type Option func(*Patch)
+
+func (Option) Do(apk string, diffwriter io.Writer) error {
 	return nil
 }
 
-type Instruction interface {
-	Do(apk string, diffwriter io.Writer) error
+// Required marks the patch as required so it cannot be disabled.
+func Required() Option {
+	return func(p *Patch) {
+		p.required = true
+	}
+}
+
+// Disabled marks the patch as disabled by default. It is only applied if it is
+// enabled by name (not by a glob pattern), and enabling it does not prevent
+// other patches from being applied.
+func Disabled() Option {
+	return func(p *Patch) {
+		p.disabled = true
+	}
+}
+
+// Requires declares that the patch can only be applied if the named patches
+// are also selected. It does not affect the order the patches are applied in.
+func Requires(name ...string) Option {
+	return func(p *Patch) {
+		p.requires = append(p.requires, name...)
+	}
+}
+
+// Conflicts declares that the patch cannot be applied if any of the named
+// patches are also selected.
+func Conflicts(name ...string) Option {
+	return func(p *Patch) {
+		p.conflicts = append(p.conflicts, name...)
+	}
+}
+
+// After declares that the patch must be applied after the named patches if
+// they are selected. Names may be glob patterns (e.g., "*" to be applied after
+// every other patch).
+func After(name ...string) Option {
+	return func(p *Patch) {
+		p.after = append(p.after, name...)
+	}
+}
+
+// Before declares that the patch must be applied before the named patches if
+// they are selected. Names may be glob patterns.
+func Before(name ...string) Option {
+	return func(p *Patch) {
+		p.before = append(p.before, name...)
+	}
 }
 
 type writeInst struct {
@@ -85,29 +532,45 @@ func WriteFileString(to, content string) Instruction {
 	return &writeInst{to, []byte(content)}
 }
 
+func (w *writeInst) String() string {
+	return "WriteFile(" + w.To + ")"
+}
+
 func (w *writeInst) Do(apk string, diffwriter io.Writer) error {
-	if utf8.Valid(w.Data) {
+	if t := txFor(apk, diffwriter); t != nil {
+		if by := createdBy(w.To); by != "" && by != t.patch {
+			return fmt.Errorf("write %q: file was already created by patch %q", w.To, by)
+		}
+	}
+
+	from := "/dev/null"
+	old, err := readFile(apk, diffwriter, w.To)
+	if err == nil {
+		from = "a/" + w.To
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("write %q: %w", w.To, err)
+	}
+
+	if isText(old) && isText(w.Data) {
 		diff := gotextdiff.ToUnified(
-			"/dev/null", "b/"+w.To, "",
-			myers.ComputeEdits(span.URIFromPath(w.To), "", string(w.Data)),
+			from, "b/"+w.To, string(old),
+			myers.ComputeEdits(span.URIFromPath(w.To), string(old), string(w.Data)),
 		)
 		if _, err := fmt.Fprint(diffwriter, diff); err != nil {
 			return fmt.Errorf("write diff: %w", err)
 		}
 	} else {
-		if _, err := fmt.Fprintf(diffwriter, "--- /dev/null\n+++ b/%s\nBinary file\n", w.To); err != nil {
+		if _, err := fmt.Fprintf(diffwriter, "--- %s\n+++ b/%s\nBinary file\n", from, w.To); err != nil {
 			return fmt.Errorf("write diff: %w", err)
 		}
 	}
 
-	p := filepath.Join(apk, filepath.Clean(filepath.FromSlash(w.To)))
-	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
-		return err
-	}
-	if err := os.WriteFile(p, []byte(w.Data), 0666); err != nil {
-		return err
-	}
-	return nil
+	return writeFile(apk, diffwriter, w.To, w.Data)
+}
+
+// isText checks if data should be shown as text in diffs.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) == -1
 }
 
 type deleteInst struct {
@@ -118,13 +581,16 @@ func DeleteFile(name string) Instruction {
 	return &deleteInst{name}
 }
 
+func (d *deleteInst) String() string {
+	return "DeleteFile(" + d.Name + ")"
+}
+
 func (d *deleteInst) Do(apk string, diffwriter io.Writer) error {
 	if _, err := fmt.Fprintf(diffwriter, "--- a/%s\n+++ /dev/null\nBinary file\n", d.Name); err != nil {
 		return fmt.Errorf("write diff: %w", err)
 	}
 
-	p := filepath.Join(apk, filepath.Clean(filepath.FromSlash(d.Name)))
-	return os.Remove(p)
+	return removeFile(apk, diffwriter, d.Name)
 }
 
 type patchInst struct {
@@ -140,41 +606,57 @@ func PatchFiles(src []string, pt ...StringPatcher) *patchInst {
 	return &patchInst{src, pt}
 }
 
+func (p *patchInst) String() string {
+	return "PatchFile(" + strings.Join(p.Sources, ", ") + ")"
+}
+
 func (p *patchInst) Do(apk string, diffwriter io.Writer) error {
+	var errs []error
 	for _, source := range p.Sources {
-		srcp := filepath.Join(apk, filepath.Clean(filepath.FromSlash(source)))
-
-		buf, err := os.ReadFile(srcp)
-		if err != nil {
-			return fmt.Errorf("patch %q: read %q: %w", source, srcp, err)
+		if err := p.patch(apk, source, diffwriter); err != nil {
+			errs = append(errs, err)
 		}
+	}
+	return errors.Join(errs...)
+}
 
-		// normalize line endings (apktool will emit crlf on windows)
-		// note: we don't need to do this in the replacements since go/scanner normalizes raw literals
-		buf = bytes.ReplaceAll(buf, []byte{'\r', '\n'}, []byte{'\n'})
+func (p *patchInst) patch(apk, source string, diffwriter io.Writer) error {
+	buf, err := readFile(apk, diffwriter, source)
+	if err != nil {
+		return fmt.Errorf("patch %q: read: %w", source, err)
+	}
 
-		obuf := string(buf)
-		sbuf := string(buf)
+	// normalize line endings (apktool will emit crlf on windows)
+	// note: we don't need to do this in the replacements since go/scanner normalizes raw literals
+	buf = bytes.ReplaceAll(buf, []byte{'\r', '\n'}, []byte{'\n'})
 
-		for i, x := range p.Patchers {
-			out, err := x.PatchString(sbuf)
-			if err != nil {
-				return fmt.Errorf("patch %q: patcher %d: %w", source, i, err)
-			}
-			sbuf = out
-		}
+	obuf := string(buf)
+	sbuf := string(buf)
 
-		diff := gotextdiff.ToUnified(
-			"a/"+source, "b/"+source, obuf,
-			myers.ComputeEdits(span.URIFromPath(source), obuf, sbuf),
-		)
-		if _, err := fmt.Fprint(diffwriter, diff); err != nil {
-			return fmt.Errorf("patch %q: could not write diff: %w", source, err)
+	// keep going so all errors are reported
+	var errs []error
+	for i, x := range p.Patchers {
+		out, err := x.PatchString(sbuf)
+		if err != nil {
+			errs = append(errs, wrapJoined(err, fmt.Sprintf("patch %q: patcher %d", source, i)))
+			continue
 		}
+		sbuf = out
+	}
+	if len(errs) != 0 {
+		return errors.Join(errs...)
+	}
 
-		if err := os.WriteFile(srcp, []byte(sbuf), 0666); err != nil {
-			return fmt.Errorf("patch %q: could not write output: %w", source, err)
-		}
+	diff := gotextdiff.ToUnified(
+		"a/"+source, "b/"+source, obuf,
+		myers.ComputeEdits(span.URIFromPath(source), obuf, sbuf),
+	)
+	if _, err := fmt.Fprint(diffwriter, diff); err != nil {
+		return fmt.Errorf("patch %q: could not write diff: %w", source, err)
+	}
+
+	if err := writeFile(apk, diffwriter, source, []byte(sbuf)); err != nil {
+		return fmt.Errorf("patch %q: could not write output: %w", source, err)
 	}
 	return nil
 }
@@ -189,13 +671,10 @@ func (fn StringPatcherFunc) PatchString(s string) (string, error) {
 	return fn(s)
 }
 
+// ReplaceString replaces all occurrences of find. Use [Once], [Times],
+// [AtLeast], or [Nth] to control which occurrences are replaced.
 func ReplaceString(find, replace string) StringPatcher {
-	return StringPatcherFunc(func(s string) (string, error) {
-		if !strings.Contains(s, find) {
-			return s, fmt.Errorf("could not find %q", find)
-		}
-		return strings.ReplaceAll(s, find, replace), nil
-	})
+	return &stringMatcher{find, replace}
 }
 
 func ReplaceStringAppend(find, replace string) StringPatcher {
@@ -207,21 +686,11 @@ func ReplaceStringPrepend(find, replace string) StringPatcher {
 }
 
 func ReplaceStringRe(find *regexp.Regexp, replace string) StringPatcher {
-	return StringPatcherFunc(func(x string) (string, error) {
-		if !find.MatchString(x) {
-			return x, fmt.Errorf("could not find %q", find.String())
-		}
-		return find.ReplaceAllString(x, replace), nil
-	})
+	return &regexpMatcher{find, replace, false}
 }
 
 func ReplaceStringReLiteral(find *regexp.Regexp, replace string) StringPatcher {
-	return StringPatcherFunc(func(x string) (string, error) {
-		if !find.MatchString(x) {
-			return x, fmt.Errorf("could not find %q", find.String())
-		}
-		return find.ReplaceAllLiteralString(x, replace), nil
-	})
+	return &regexpMatcher{find, replace, true}
 }
 
 func AppendString(s string) StringPatcher {
@@ -237,72 +706,20 @@ func ReplaceWith(s string) StringPatcher {
 }
 
 func MustContain(s string) StringPatcher {
-	return StringPatcherFunc(func(x string) (string, error) {
-		if !strings.Contains(x, s) {
-			return x, fmt.Errorf("could not find %q", s)
-		}
-		return x + "\n", nil // hack to be able to use in InMethod
-	})
-}
-
-func inMethod(method string, pt StringPatcher) StringPatcher {
-	return StringPatcherFunc(func(smali string) (string, error) {
-		lines := strings.Split(smali, "\n")
-
-		var cmethod string
-		var chunk string
-		var chunks []string
-		for _, l := range lines {
-			lf := strings.Fields(l)
-			if len(lf) >= 1 && lf[0] == ".method" {
-				cmethod = lf[len(lf)-1]
-			} else if len(lf) >= 2 && lf[0] == ".end" && lf[1] == "method" {
-				cmethod = ""
-				if chunk != "" {
-					chunks = append(chunks, chunk)
-					chunk = ""
-				}
-			} else if cmethod == method {
-				chunk += l + "\n"
-			}
-		}
-		if len(chunks) == 0 {
-			return smali, fmt.Errorf("could not find method %q", method)
-		}
-
-		var crepl bool
-		for _, chunk := range chunks {
-			nchunk, err := pt.PatchString(chunk)
-			if err != nil {
-				return smali, fmt.Errorf("could not run patcher in method %q: %w", method, err)
-			}
-			ns := strings.Replace(smali, chunk, nchunk, 1)
-			if chunk != nchunk {
-				if ns == smali {
-					panic("chunk but not smali changed")
-				}
-				crepl = true
-			}
-			smali = ns
-		}
-		if !crepl {
-			return smali, fmt.Errorf("identical output") // NOTE: this may not be an error in some cases, change this?
-		}
-
-		return smali, nil
-	})
+	return &containsMatcher{s}
 }
 
+// InMethod applies patchers to the body of every smali method with the
+// specified name and descriptor (e.g., "update()V"). The method can also be
+// qualified with the class descriptor (e.g.,
+// "Lcom/faultexception/reader/Foo;->update()V"). Use [Once] to ensure only a
+// single method matches.
 func InMethod(method string, pt ...StringPatcher) StringPatcher {
-	return StringPatcherFunc(func(smali string) (string, error) {
-		var err error
-		for _, x := range pt {
-			if smali, err = inMethod(method, x).PatchString(smali); err != nil {
-				return smali, err
-			}
-		}
-		return smali, nil
-	})
+	m := &methodMatcher{method: method, pt: pt}
+	if class, name, ok := strings.Cut(method, "->"); ok {
+		m.class, m.method = class, name
+	}
+	return m
 }
 
 func InConstant(constant string, pt StringPatcher) StringPatcher {
@@ -396,69 +813,3 @@ func ExecuteTemplate(tmpl string, data any) string {
 	}
 	return b.String()
 }
-
-type resIDInst struct {
-	Path string
-	Type string
-	Name string
-}
-
-func (r *resIDInst) Do(apk string, diffwriter io.Writer) error {
-	var nid string
-	if err := PatchFile("res/values/public.xml", StringPatcherFunc(func(s string) (string, error) {
-		var obj struct {
-			XMLName xml.Name `xml:"resources"`
-			Public  []struct {
-				Type string `xml:"type,attr"`
-				Name string `xml:"name,attr"`
-				ID   string `xml:"id,attr"`
-			} `xml:"public"`
-		}
-		if err := xml.Unmarshal([]byte(s), &obj); err != nil {
-			return "", fmt.Errorf("parse existing resources: %w", err)
-		}
-
-		var last uint64
-		for _, x := range obj.Public {
-			if x.Type == r.Type {
-				if x.Name == r.Name {
-					return s, nil
-				}
-				v, err := strconv.ParseUint(x.ID, 0, 32)
-				if err != nil {
-					return "", fmt.Errorf("parse existing resources: %w", err)
-				}
-				if v >= last {
-					last = v
-				}
-			}
-		}
-		if last == 0 {
-			return "", fmt.Errorf("no existing resources found with type %q", r.Type)
-		}
-		nid = "0x" + strconv.FormatUint(last+1, 16)
-
-		return ReplaceStringPrepend(
-			"\n</resources>",
-			"\n    <public type=\""+r.Type+"\" name=\""+r.Name+"\" id=\""+nid+"\" />",
-		).PatchString(s)
-	})).Do(apk, diffwriter); err != nil {
-		return err
-	}
-	if nid != "" {
-		if err := PatchFile(r.Path+"/R$"+r.Type+".smali", StringPatcherFunc(func(s string) (string, error) {
-			return s + "\n\n.field public static final " + r.Name + ":I = " + nid + "\n", nil
-		})).Do(apk, diffwriter); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func DefineR(path, typ, name string) Instruction {
-	return &resIDInst{
-		Path: path,
-		Type: typ,
-		Name: name,
-	}
-}
@@ -0,0 +1,190 @@
+package patchdef
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// placeholderRe matches smali pattern placeholders.
+var placeholderRe = regexp.MustCompile(`\{\{(reg|label):([A-Za-z0-9_]+)\}\}`)
+
+// placeholderPatterns contains the regexp for each kind of placeholder.
+var placeholderPatterns = map[string]string{
+	"reg":   `\b[vp][0-9]+\b`,
+	"label": `:[A-Za-z0-9_]+\b`,
+}
+
+var (
+	smaliRegisterRe = regexp.MustCompile(placeholderPatterns["reg"])
+	smaliLabelRe    = regexp.MustCompile(placeholderPatterns["label"])
+)
+
+// ReplaceSmali is like [ReplaceString], but find is a smali pattern where
+// {{reg:NAME}} matches any register (e.g., v0 or p1) and {{label:NAME}} matches
+// any label (e.g., :cond_0), so it keeps working when the registers or labels
+// change. Placeholders with the same kind and name must match the same text,
+// and can be used in replace to insert it.
+func ReplaceSmali(find, replace string) StringPatcher {
+	return newPatternMatcher(find, replace, true, false)
+}
+
+func ReplaceSmaliAppend(find, replace string) StringPatcher {
+	return ReplaceSmali(find, find+replace)
+}
+
+func ReplaceSmaliPrepend(find, replace string) StringPatcher {
+	return ReplaceSmali(find, replace+find)
+}
+
+// patternMatcher replaces a string, optionally containing placeholders and
+// ignoring debug directives.
+type patternMatcher struct {
+	find, replace string
+	placeholders  bool
+	ignoreDebug   bool
+	re            *regexp.Regexp
+	names         []string // the placeholder for each capture group
+}
+
+// newPatternMatcher creates a patternMatcher, returning nil if ignoreDebug is
+// true and find only contains debug directives. It panics if replace contains
+// placeholders not in find.
+func newPatternMatcher(find, replace string, placeholders, ignoreDebug bool) *patternMatcher {
+	m := &patternMatcher{
+		find:         find,
+		replace:      replace,
+		placeholders: placeholders,
+		ignoreDebug:  ignoreDebug,
+	}
+	quote := regexp.QuoteMeta
+	if placeholders {
+		quote = func(s string) string {
+			var b strings.Builder
+			var last int
+			for _, x := range placeholderRe.FindAllStringSubmatchIndex(s, -1) {
+				b.WriteString(regexp.QuoteMeta(s[last:x[0]]))
+				b.WriteString("(" + placeholderPatterns[s[x[2]:x[3]]] + ")")
+				m.names = append(m.names, s[x[0]:x[1]])
+				last = x[1]
+			}
+			b.WriteString(regexp.QuoteMeta(s[last:]))
+			return b.String()
+		}
+	}
+	if ignoreDebug {
+		if m.re = fuzzyRegexp(find, quote); m.re == nil {
+			return nil
+		}
+	} else {
+		m.re = regexp.MustCompile(quote(find))
+	}
+	if placeholders {
+		for _, x := range placeholderRe.FindAllString(replace, -1) {
+			if !strings.Contains(find, x) {
+				panic(fmt.Sprintf("replacement placeholder %s is not in the pattern", x))
+			}
+		}
+	}
+	return m
+}
+
+func (m *patternMatcher) PatchString(s string) (string, error) {
+	return m.patchLines(s, 1)
+}
+
+func (m *patternMatcher) patchLines(s string, line int) (string, error) {
+	return patchAll(m, s, func() error {
+		return fmt.Errorf("could not find %s%s", m.describe(), suggest(s, m.find, line, m.lineKey))
+	})
+}
+
+func (m *patternMatcher) describe() string {
+	if m.ignoreDebug {
+		return strconv.Quote(m.find) + " (ignoring debug directives)"
+	}
+	return strconv.Quote(m.find)
+}
+
+func (m *patternMatcher) match(s string) ([]int, func([]int) (string, error)) {
+	var (
+		ms       [][]int
+		bindings []map[string]string
+	)
+	for off := 0; off <= len(s); {
+		x := m.re.FindStringSubmatchIndex(s[off:])
+		if x == nil {
+			break
+		}
+		for i := range x {
+			if x[i] != -1 {
+				x[i] += off
+			}
+		}
+		if b, ok := m.bind(s, x); ok {
+			ms = append(ms, x)
+			bindings = append(bindings, b)
+			off = max(x[1], x[0]+1)
+		} else {
+			off = x[0] + 1 // try again for a match with consistent placeholders
+		}
+	}
+	offsets := make([]int, len(ms))
+	for i, x := range ms {
+		offsets[i] = x[0]
+	}
+	return offsets, func(idx []int) (string, error) {
+		var b strings.Builder
+		var last int
+		for _, i := range idx {
+			expand := func(r string) string {
+				if !m.placeholders {
+					return r
+				}
+				return placeholderRe.ReplaceAllStringFunc(r, func(p string) string {
+					return bindings[i][p]
+				})
+			}
+			orig := s[ms[i][0]:ms[i][1]]
+			b.WriteString(s[last:ms[i][0]])
+			switch {
+			case strings.HasPrefix(m.replace, m.find): // append
+				b.WriteString(orig + expand(m.replace[len(m.find):]))
+			case strings.HasSuffix(m.replace, m.find): // prepend
+				b.WriteString(expand(m.replace[:len(m.replace)-len(m.find)]) + orig)
+			default:
+				b.WriteString(expand(m.replace))
+			}
+			last = ms[i][1]
+		}
+		b.WriteString(s[last:])
+		return b.String(), nil
+	}
+}
+
+// lineKey normalizes a line for suggest, replacing placeholders, registers,
+// and labels with the kind of placeholder which would match them.
+func (m *patternMatcher) lineKey(l string) string {
+	l = normalizeLine(l)
+	if m.placeholders {
+		l = placeholderRe.ReplaceAllString(l, "{{$1}}")
+		l = smaliRegisterRe.ReplaceAllString(l, "{{reg}}")
+		l = smaliLabelRe.ReplaceAllString(l, "{{label}}")
+	}
+	return l
+}
+
+// bind gets the text matched by each placeholder for the submatch x of s,
+// returning false if the same placeholder matched different text.
+func (m *patternMatcher) bind(s string, x []int) (map[string]string, bool) {
+	b := make(map[string]string, len(m.names))
+	for i, name := range m.names {
+		v := s[x[2+i*2]:x[3+i*2]]
+		if p, ok := b[name]; ok && p != v {
+			return nil, false
+		}
+		b[name] = v
+	}
+	return b, true
+}
@@ -0,0 +1,105 @@
+package patchdef
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Report describes the result of applying a patch.
+type Report struct {
+	Patch        string               `json:"patch"`
+	OK           bool                 `json:"ok"`
+	Skipped      bool                 `json:"skipped,omitempty"` // set by the caller
+	Files        []*FileReport        `json:"files"`
+	Added        int                  `json:"added"`
+	Removed      int                  `json:"removed"`
+	Duration     time.Duration        `json:"duration"` // nanoseconds
+	Error        *ErrorReport         `json:"error,omitempty"`
+	Instructions []*InstructionReport `json:"instructions"`
+}
+
+// InstructionReport describes the result of applying a single instruction of a
+// patch.
+type InstructionReport struct {
+	Index    int           `json:"index"`
+	Name     string        `json:"name"`
+	OK       bool          `json:"ok"`
+	Files    []*FileReport `json:"files"`
+	Added    int           `json:"added"`
+	Removed  int           `json:"removed"`
+	Duration time.Duration `json:"duration"` // nanoseconds
+	Error    *ErrorReport  `json:"error,omitempty"`
+}
+
+// FileReport describes the changes to a file.
+type FileReport struct {
+	Name    string `json:"name"`
+	Created bool   `json:"created,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Binary  bool   `json:"binary,omitempty"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+}
+
+// ErrorReport is an error and the errors it wraps.
+type ErrorReport struct {
+	Message string         `json:"message"`
+	Wrapped []*ErrorReport `json:"wrapped,omitempty"`
+}
+
+// NewErrorReport converts an error chain (including ones created by
+// [errors.Join]) into a tree. Levels which don't add anything to the message
+// are omitted.
+func NewErrorReport(err error) *ErrorReport {
+	if err == nil {
+		return nil
+	}
+	var wrapped []error
+	switch x := err.(type) {
+	case interface{ Unwrap() []error }:
+		wrapped = x.Unwrap()
+	default:
+		if e := errors.Unwrap(err); e != nil {
+			wrapped = []error{e}
+		}
+	}
+	if len(wrapped) == 1 && wrapped[0].Error() == err.Error() {
+		return NewErrorReport(wrapped[0])
+	}
+	r := &ErrorReport{Message: err.Error()}
+	for _, e := range wrapped {
+		r.Wrapped = append(r.Wrapped, NewErrorReport(e))
+	}
+	return r
+}
+
+// instName describes an instruction for a report.
+func instName(inst Instruction) string {
+	if s, ok := inst.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", inst), "*")
+}
+
+// mergeFileReports merges the reports for the same file.
+func mergeFileReports(fs []*FileReport) []*FileReport {
+	m := []*FileReport{}
+	for _, f := range fs {
+		if i := slices.IndexFunc(m, func(x *FileReport) bool { return x.Name == f.Name }); i != -1 {
+			x := *m[i]
+			x.Added += f.Added
+			x.Removed += f.Removed
+			x.Binary = x.Binary || f.Binary
+			x.Deleted = f.Deleted
+			x.Created = x.Created && !f.Deleted
+			m[i] = &x
+		} else {
+			x := *f
+			m = append(m, &x)
+		}
+	}
+	return m
+}
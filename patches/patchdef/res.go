@@ -0,0 +1,310 @@
+package patchdef
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type resAllocInst struct {
+	Path   string
+	Define []resKey // if set, only allocate these
+}
+
+// AllocateResourceIDs scans the resources for ones without an entry in
+// public.xml (i.e., ones added by patches), then allocates IDs for them and
+// adds them to the R classes in the specified smali directory. It should be
+// applied after all other patches.
+//
+// It is an error if a resource added by a patch is defined more than once for
+// the same configuration (e.g., if two patches add the same id).
+func AllocateResourceIDs(path string) Instruction {
+	return &resAllocInst{Path: path}
+}
+
+// DefineR allocates an ID for a single resource (which doesn't need to be
+// defined in the res directory) and adds it to the R class in the specified
+// smali directory. It does nothing if the resource already has an ID.
+//
+// Deprecated: Add the resource and let AllocateResourceIDs allocate the ID.
+func DefineR(path, typ, name string) Instruction {
+	return &resAllocInst{Path: path, Define: []resKey{{typ, name}}}
+}
+
+type resKey struct {
+	Type string
+	Name string
+}
+
+type resDef struct {
+	File   string // relative to the apk
+	Config string // e.g., "", "night-v31"
+}
+
+func (r *resAllocInst) String() string {
+	if len(r.Define) != 0 {
+		return "DefineR(" + r.Path + ", " + r.Define[0].Type + ", " + r.Define[0].Name + ")"
+	}
+	return "AllocateResourceIDs(" + r.Path + ")"
+}
+
+func (r *resAllocInst) Do(apk string, diffwriter io.Writer) error {
+	buf, err := readFile(apk, diffwriter, "res/values/public.xml")
+	if err != nil {
+		return fmt.Errorf("read public resources: %w", err)
+	}
+	pub, err := parseXML(string(buf))
+	if err != nil {
+		return fmt.Errorf("parse public resources: %w", err)
+	}
+	es, err := pub.Select(`resources > public`)
+	if err != nil {
+		return err
+	}
+
+	var (
+		pkg      uint64
+		existing = map[resKey]bool{}
+		types    = map[string]uint64{} // type id
+		last     = map[string]uint64{} // last entry id
+	)
+	for _, e := range es {
+		typ, _ := e.Attr("type")
+		name, _ := e.Attr("name")
+		idstr, _ := e.Attr("id")
+		id, err := strconv.ParseUint(idstr, 0, 32)
+		if err != nil {
+			return fmt.Errorf("parse public resources: %s/%s: %w", typ, name, err)
+		}
+		existing[resKey{typ, name}] = true
+		pkg = id >> 24
+		types[typ] = id >> 16 & 0xff
+		last[typ] = max(last[typ], id&0xffff)
+	}
+	if pkg == 0 {
+		return errors.New("parse public resources: no existing resources found")
+	}
+
+	defs, err := r.scan(apk, diffwriter)
+	if err != nil {
+		return err
+	}
+
+	if len(r.Define) != 0 {
+		only := map[resKey][]resDef{}
+		for _, k := range r.Define {
+			only[k] = defs[k]
+		}
+		defs = only
+	}
+
+	var (
+		errs  []error
+		added []resKey
+	)
+	for k, ds := range defs {
+		if existing[k] {
+			continue // only check the ones added during this run
+		}
+		added = append(added, k)
+		seen := map[string]string{}
+		for _, d := range ds {
+			if f, ok := seen[d.Config]; ok {
+				errs = append(errs, fmt.Errorf("resource %s/%s is defined multiple times: %s, %s", k.Type, k.Name, resDescribe(f), resDescribe(d.File)))
+				break
+			}
+			seen[d.Config] = d.File
+		}
+	}
+	if len(errs) != 0 {
+		slices.SortFunc(errs, func(a, b error) int {
+			return strings.Compare(a.Error(), b.Error())
+		})
+		return errors.Join(errs...)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	slices.SortFunc(added, func(a, b resKey) int {
+		return cmp.Or(strings.Compare(a.Type, b.Type), strings.Compare(a.Name, b.Name))
+	})
+
+	var (
+		public strings.Builder
+		fields = map[string][]string{}
+	)
+	for _, k := range added {
+		tid, ok := types[k.Type]
+		if !ok {
+			for _, x := range types {
+				tid = max(tid, x)
+			}
+			tid++
+			if tid > 0xff {
+				return fmt.Errorf("allocate resource %s/%s: too many resource types", k.Type, k.Name)
+			}
+			types[k.Type] = tid
+			last[k.Type] = 0
+		} else {
+			last[k.Type]++
+		}
+		if last[k.Type] > 0xffff {
+			return fmt.Errorf("allocate resource %s/%s: too many resources", k.Type, k.Name)
+		}
+		id := "0x" + strconv.FormatUint(pkg<<24|tid<<16|last[k.Type], 16)
+		public.WriteString("\n    <public type=\"" + k.Type + "\" name=\"" + xmlEscape(k.Name) + "\" id=\"" + id + "\" />")
+		fields[k.Type] = append(fields[k.Type], ".field public static final "+strings.NewReplacer(".", "_", "-", "_").Replace(k.Name)+":I = "+id)
+	}
+
+	if err := PatchFile("res/values/public.xml",
+		ReplaceStringPrepend("\n</resources>", public.String()),
+	).Do(apk, diffwriter); err != nil {
+		return err
+	}
+	for _, typ := range slices.Sorted(maps.Keys(fields)) {
+		name := r.Path + "/R$" + typ + ".smali"
+		if _, err := readFile(apk, diffwriter, name); errors.Is(err, os.ErrNotExist) {
+			cls, err := r.class(typ, fields[typ])
+			if err != nil {
+				return err
+			}
+			if err := WriteFileString(name, cls).Do(apk, diffwriter); err != nil {
+				return err
+			}
+			continue
+		}
+		var pt []StringPatcher
+		for _, f := range fields[typ] {
+			pt = append(pt, AddField(f))
+		}
+		if err := PatchFile(name, pt...).Do(apk, diffwriter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan finds the resources defined in the res directory, including any staged
+// changes.
+func (r *resAllocInst) scan(apk string, diffwriter io.Writer) (map[resKey][]resDef, error) {
+	var files []string
+	dirs, err := os.ReadDir(filepath.Join(apk, "res"))
+	if err != nil {
+		return nil, fmt.Errorf("scan resources: %w", err)
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		ents, err := os.ReadDir(filepath.Join(apk, "res", dir.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("scan resources: %w", err)
+		}
+		for _, ent := range ents {
+			if !ent.IsDir() {
+				files = append(files, path.Join("res", dir.Name(), ent.Name()))
+			}
+		}
+	}
+	t := txFor(apk, diffwriter)
+	if t != nil {
+		for _, f := range t.order {
+			if strings.Count(f, "/") == 2 && strings.HasPrefix(f, "res/") && !slices.Contains(files, f) {
+				files = append(files, f)
+			}
+		}
+	}
+	slices.Sort(files)
+
+	defs := map[resKey][]resDef{}
+	for _, name := range files {
+		if t != nil {
+			if data, ok := t.files[name]; ok && data == nil {
+				continue // deleted
+			}
+		}
+		dir, file := path.Split(strings.TrimPrefix(name, "res/"))
+		typ, config, _ := strings.Cut(strings.TrimSuffix(dir, "/"), "-")
+		if typ != "values" {
+			k := resKey{typ, strings.Split(file, ".")[0]}
+			defs[k] = append(defs[k], resDef{name, config})
+			continue
+		}
+		buf, err := readFile(apk, diffwriter, name)
+		if err != nil {
+			return nil, fmt.Errorf("scan resources: %w", err)
+		}
+		d, err := parseXML(string(buf))
+		if err != nil {
+			return nil, fmt.Errorf("scan resources: parse %s: %w", name, err)
+		}
+		es, err := d.Select(`resources > *`)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range es {
+			var k resKey
+			k.Name, _ = e.Attr("name")
+			switch e.Name {
+			case "public", "declare-styleable", "eat-comment", "skip":
+				continue
+			case "item":
+				k.Type, _ = e.Attr("type")
+			case "string-array", "integer-array":
+				k.Type = "array"
+			default:
+				k.Type = e.Name
+			}
+			if k.Type == "" || k.Name == "" {
+				return nil, fmt.Errorf("scan resources: %s: invalid %s element", name, e.Name)
+			}
+			defs[k] = append(defs[k], resDef{name, config})
+		}
+	}
+	return defs, nil
+}
+
+// class generates a new R class for typ.
+func (r *resAllocInst) class(typ string, fields []string) (string, error) {
+	_, pkg, ok := strings.Cut(r.Path, "/")
+	if !ok {
+		return "", fmt.Errorf("generate R class: %q is not a smali directory", r.Path)
+	}
+	cls := "L" + pkg + "/R"
+
+	var b strings.Builder
+	b.WriteString(".class public final " + cls + "$" + typ + ";\n")
+	b.WriteString(".super Ljava/lang/Object;\n")
+	b.WriteString(".source \"R.java\"\n")
+	b.WriteString("\n\n# annotations\n")
+	b.WriteString(".annotation system Ldalvik/annotation/EnclosingClass;\n")
+	b.WriteString("    value = " + cls + ";\n")
+	b.WriteString(".end annotation\n")
+	b.WriteString("\n")
+	b.WriteString(".annotation system Ldalvik/annotation/InnerClass;\n")
+	b.WriteString("    accessFlags = 0x19\n")
+	b.WriteString("    name = \"" + typ + "\"\n")
+	b.WriteString(".end annotation\n")
+	b.WriteString("\n\n# static fields")
+	for _, f := range fields {
+		b.WriteString("\n" + f + "\n")
+	}
+	return b.String(), nil
+}
+
+// resDescribe describes a resource file, including the patches which changed
+// it during this run.
+func resDescribe(name string) string {
+	if ps := changedBy(name); len(ps) != 0 {
+		return name + " (" + strings.Join(ps, ", ") + ")"
+	}
+	return name
+}
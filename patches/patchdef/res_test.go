@@ -0,0 +1,74 @@
+package patchdef
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testAPKDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestAllocateResourceIDs(t *testing.T) {
+	apk := testAPKDir(t, map[string]string{
+		"res/values/public.xml": "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<resources>\n" +
+			"    <public type=\"string\" name=\"a\" id=\"0x7f010000\" />\n" +
+			"</resources>",
+		"res/values/strings.xml": "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<resources>\n" +
+			"    <string name=\"a\">A</string>\n" +
+			"</resources>",
+		"res/values/ids.xml": "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<resources>\n" +
+			"    <item type=\"id\" name=\"deleted\" />\n" +
+			"</resources>",
+	})
+	tx := newTx(apk, "test")
+	for _, inst := range []Instruction{
+		WriteFileString("res/values-night/extra.xml", "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<resources>\n"+
+			"    <string name=\"b\">B</string>\n"+
+			"</resources>"),
+		WriteFileString("res/layout/staged.xml", "<LinearLayout />"),
+		PatchFile("res/values/strings.xml",
+			ReplaceStringPrepend("\n</resources>", "\n    <string name=\"c\">C</string>"),
+		),
+		DeleteFile("res/values/ids.xml"),
+		AllocateResourceIDs("smali/com/example"),
+	} {
+		if err := inst.Do(apk, tx); err != nil {
+			t.Fatalf("%s: %v", inst, err)
+		}
+	}
+
+	pub := string(tx.files["res/values/public.xml"])
+	for _, exp := range []string{
+		`<public type="string" name="b" id="0x7f010001" />`,
+		`<public type="string" name="c" id="0x7f010002" />`,
+		`<public type="layout" name="staged" id="0x7f020000" />`,
+	} {
+		if !strings.Contains(pub, exp) {
+			t.Errorf("expected public.xml to contain %s, got:\n%s", exp, pub)
+		}
+	}
+	if strings.Contains(pub, `name="deleted"`) {
+		t.Errorf("expected resources in deleted files to be ignored, got:\n%s", pub)
+	}
+	if cls := string(tx.files["smali/com/example/R$layout.smali"]); !strings.Contains(cls, ".class public final Lcom/example/R$layout;") || !strings.Contains(cls, ".field public static final staged:I = 0x7f020000") {
+		t.Errorf("unexpected R class:\n%s", cls)
+	}
+
+	if err := AllocateResourceIDs("smali").Do(apk, newTx(apk, "test")); err == nil {
+		t.Errorf("expected error for invalid R class path")
+	}
+}
@@ -0,0 +1,701 @@
+package patchdef
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Smali is a parsed smali class. Lines which aren't modified are preserved
+// exactly as they were.
+type Smali struct {
+	Class   string // e.g., Lcom/faultexception/reader/BooksAdapter;
+	Super   string // e.g., Ljava/lang/Object;
+	Fields  []*SmaliField
+	Methods []*SmaliMethod
+
+	items []any // []string, *SmaliField, or *SmaliMethod, in file order
+}
+
+// SmaliField is a field definition, including any annotations.
+type SmaliField struct {
+	Name   string   // e.g., mCursor:Landroid/database/Cursor;
+	Access []string // e.g., private, static
+	Lines  []string // the .field line, plus any annotations and the .end field
+}
+
+// SmaliMethod is a method definition.
+type SmaliMethod struct {
+	Name   string   // e.g., onCreate(Landroid/os/Bundle;)V
+	Access []string // e.g., public, static
+	Header string   // the .method line
+	Body   []string // the lines between the .method and .end method
+}
+
+// SmaliInstruction is an instruction within a method.
+type SmaliInstruction struct {
+	Index    int    // the index of the line in the method body
+	Opcode   string // e.g., invoke-virtual
+	Operands string // e.g., {v0, v1}, Ljava/lang/Object;->equals(Ljava/lang/Object;)Z
+}
+
+// ParseSmali parses a smali class.
+func ParseSmali(s string) (*Smali, error) {
+	var (
+		c     Smali
+		raw   []string
+		field *SmaliField
+		meth  *SmaliMethod
+	)
+	flush := func() {
+		if raw != nil {
+			c.items = append(c.items, raw)
+			raw = nil
+		}
+	}
+	lines := strings.Split(s, "\n")
+	for n, l := range lines {
+		lf := strings.Fields(l)
+		switch {
+		case meth != nil:
+			if len(lf) >= 2 && lf[0] == ".end" && lf[1] == "method" {
+				meth = nil
+				continue
+			}
+			meth.Body = append(meth.Body, l)
+		case field != nil:
+			field.Lines = append(field.Lines, l)
+			if len(lf) >= 2 && lf[0] == ".end" && lf[1] == "field" {
+				field = nil
+			}
+		case len(lf) >= 2 && lf[0] == ".class":
+			c.Class = lf[len(lf)-1]
+			raw = append(raw, l)
+		case len(lf) >= 2 && lf[0] == ".super":
+			c.Super = lf[len(lf)-1]
+			raw = append(raw, l)
+		case len(lf) >= 2 && lf[0] == ".method":
+			flush()
+			meth = &SmaliMethod{
+				Name:   lf[len(lf)-1],
+				Access: slices.Clone(lf[1 : len(lf)-1]),
+				Header: l,
+			}
+			c.Methods = append(c.Methods, meth)
+			c.items = append(c.items, meth)
+		case len(lf) >= 2 && lf[0] == ".field":
+			flush()
+			f := &SmaliField{
+				Lines: []string{l},
+			}
+			for _, x := range lf[1:] {
+				if x == "=" {
+					break
+				}
+				if strings.Contains(x, ":") {
+					f.Name = x
+					break
+				}
+				f.Access = append(f.Access, x)
+			}
+			if f.Name == "" {
+				return nil, fmt.Errorf("line %d: invalid field %q", n+1, l)
+			}
+			c.Fields = append(c.Fields, f)
+			c.items = append(c.items, f)
+			// fields only have an end directive if they have annotations
+			if i := slices.IndexFunc(lines[n+1:], func(l string) bool {
+				return strings.TrimSpace(l) != ""
+			}); i != -1 && strings.HasPrefix(strings.TrimSpace(lines[n+1+i]), ".annotation") {
+				field = f
+			}
+		default:
+			raw = append(raw, l)
+		}
+	}
+	if meth != nil {
+		return nil, fmt.Errorf("unterminated method %q", meth.Name)
+	}
+	if field != nil {
+		return nil, fmt.Errorf("unterminated field %q", field.Name)
+	}
+	flush()
+	return &c, nil
+}
+
+// String formats the class as smali.
+func (c *Smali) String() string {
+	var b strings.Builder
+	for i, x := range c.items {
+		if i != 0 {
+			b.WriteByte('\n')
+		}
+		switch x := x.(type) {
+		case []string:
+			b.WriteString(strings.Join(x, "\n"))
+		case *SmaliField:
+			b.WriteString(strings.Join(x.Lines, "\n"))
+		case *SmaliMethod:
+			b.WriteString(x.Header)
+			for _, l := range x.Body {
+				b.WriteByte('\n')
+				b.WriteString(l)
+			}
+			b.WriteString("\n.end method")
+		}
+	}
+	return b.String()
+}
+
+// Field gets a field by name, optionally including the type.
+func (c *Smali) Field(name string) (*SmaliField, error) {
+	for _, f := range c.Fields {
+		if f.Name == name || strings.HasPrefix(f.Name, name+":") {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("could not find field %q", name)
+}
+
+// Method gets a method by name and descriptor.
+func (c *Smali) Method(name string) (*SmaliMethod, error) {
+	for _, m := range c.Methods {
+		if m.Name == name {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("could not find method %q", name)
+}
+
+// AddField adds a field (e.g., ".field public foo:I") after the last existing
+// field.
+func (c *Smali) AddField(decl string) error {
+	lines := reindentLines(decl, "")
+	if len(lines) == 0 {
+		return errors.New("add field: empty declaration")
+	}
+	tmp, err := ParseSmali(strings.Join(lines, "\n"))
+	if err != nil {
+		return fmt.Errorf("add field: %w", err)
+	}
+	if len(tmp.Fields) != 1 || len(tmp.Methods) != 0 {
+		return fmt.Errorf("add field: expected exactly one field declaration")
+	}
+	f := tmp.Fields[0]
+	for _, x := range c.Fields {
+		if strings.Split(x.Name, ":")[0] == strings.Split(f.Name, ":")[0] {
+			return fmt.Errorf("add field: field %q already exists", x.Name)
+		}
+	}
+
+	switch {
+	case len(c.Fields) != 0:
+		f.Lines = slices.Insert(f.Lines, 0, "")
+		c.items = slices.Insert(c.items, slices.Index(c.items, any(c.Fields[len(c.Fields)-1]))+1, any(f))
+	default:
+		// put it after the class header, in a new section like apktool does
+		kind := "instance"
+		if slices.Contains(f.Access, "static") {
+			kind = "static"
+		}
+		f.Lines = slices.Insert(f.Lines, 0, "", "", "# "+kind+" fields")
+		raw, ok := c.items[0].([]string)
+		if !ok {
+			c.items = slices.Insert(c.items, 0, any(f))
+			break
+		}
+		at := len(raw)
+		for at > 0 && (strings.TrimSpace(raw[at-1]) == "" || strings.HasPrefix(strings.TrimSpace(raw[at-1]), "#")) {
+			at--
+		}
+		c.items = slices.Insert(c.items, 1, any(f), any(slices.Clone(raw[at:])))
+		c.items[0] = raw[:at]
+	}
+	c.Fields = append(c.Fields, f)
+	return nil
+}
+
+// AddMethod adds a method (including the .method and .end method directives)
+// to the end of the class.
+func (c *Smali) AddMethod(code string) error {
+	lines := reindentLines(code, "")
+	tmp, err := ParseSmali(strings.Join(lines, "\n"))
+	if err != nil {
+		return fmt.Errorf("add method: %w", err)
+	}
+	if len(tmp.Methods) != 1 || len(tmp.Fields) != 0 {
+		return fmt.Errorf("add method: expected exactly one method definition")
+	}
+	m := tmp.Methods[0]
+	if _, err := c.Method(m.Name); err == nil {
+		return fmt.Errorf("add method: method %q already exists", m.Name)
+	}
+	for i, l := range m.Body {
+		if strings.TrimSpace(l) != "" && !strings.HasPrefix(l, "    ") {
+			m.Body[i] = "    " + strings.TrimSpace(l)
+		}
+	}
+
+	// keep the trailing newline at the end of the file
+	at := len(c.items)
+	if raw, ok := c.items[at-1].([]string); ok && len(raw) != 0 && strings.TrimSpace(raw[len(raw)-1]) == "" {
+		at--
+	}
+	c.items = slices.Insert(c.items, at, any([]string{""}), any(m))
+	c.Methods = append(c.Methods, m)
+	return nil
+}
+
+// Static returns true if the method is static.
+func (m *SmaliMethod) Static() bool {
+	return slices.Contains(m.Access, "static")
+}
+
+// Params gets the number of registers used for the method parameters,
+// including the implicit this parameter for non-static methods.
+func (m *SmaliMethod) Params() (int, error) {
+	n, err := smaliParamRegisters(m.Name)
+	if err != nil {
+		return 0, err
+	}
+	if !m.Static() {
+		n++
+	}
+	return n, nil
+}
+
+// Locals gets the number of local (non-parameter) registers.
+func (m *SmaliMethod) Locals() (int, error) {
+	for _, l := range m.Body {
+		if lf := strings.Fields(l); len(lf) == 2 {
+			switch lf[0] {
+			case ".locals":
+				return strconv.Atoi(lf[1])
+			case ".registers":
+				r, err := strconv.Atoi(lf[1])
+				if err != nil {
+					return 0, err
+				}
+				p, err := m.Params()
+				if err != nil {
+					return 0, err
+				}
+				return r - p, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("could not find register count in method %q", m.Name)
+}
+
+// AddLocals increases the number of local registers by n, returning the names
+// of the new registers. Since parameter registers are referred to by name, the
+// existing code is unaffected. Note that instructions which only accept
+// registers below v16 may fail to assemble if the method now has too many
+// registers.
+func (m *SmaliMethod) AddLocals(n int) ([]string, error) {
+	for i, l := range m.Body {
+		if lf := strings.Fields(l); len(lf) == 2 && (lf[0] == ".locals" || lf[0] == ".registers") {
+			locals, err := m.Locals()
+			if err != nil {
+				return nil, err
+			}
+			v, err := strconv.Atoi(lf[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid register count %q: %w", lf[1], err)
+			}
+			m.Body[i] = l[:len(l)-len(strings.TrimLeft(l, " \t"))] + lf[0] + " " + strconv.Itoa(v+n)
+
+			regs := make([]string, n)
+			for j := range regs {
+				regs[j] = "v" + strconv.Itoa(locals+j)
+			}
+			return regs, nil
+		}
+	}
+	return nil, fmt.Errorf("could not find register count in method %q", m.Name)
+}
+
+// Labels gets the names of the labels (including the colon) in the method.
+func (m *SmaliMethod) Labels() []string {
+	var ls []string
+	for _, l := range m.Body {
+		if l = strings.TrimSpace(l); strings.HasPrefix(l, ":") {
+			ls = append(ls, l)
+		}
+	}
+	return ls
+}
+
+// Instructions gets the instructions in the method, excluding labels,
+// directives, and the contents of data blocks.
+func (m *SmaliMethod) Instructions() []SmaliInstruction {
+	var (
+		is    []SmaliInstruction
+		block string
+	)
+	for i, l := range m.Body {
+		l = strings.TrimSpace(l)
+		if c := strings.Index(l, "#"); c != -1 && !strings.Contains(l[:c], `"`) {
+			l = strings.TrimSpace(l[:c])
+		}
+		if l == "" || l[0] == ':' {
+			continue
+		}
+		if block != "" {
+			if l == ".end "+block {
+				block = ""
+			}
+			continue
+		}
+		if l[0] == '.' {
+			d, _, _ := strings.Cut(l, " ")
+			switch d = d[1:]; d {
+			case "annotation", "param", "packed-switch", "sparse-switch", "array-data":
+				if d != "param" || strings.Contains(l, ".annotation") {
+					block = d
+				}
+			}
+			continue
+		}
+		op, args, _ := strings.Cut(l, " ")
+		is = append(is, SmaliInstruction{
+			Index:    i,
+			Opcode:   op,
+			Operands: strings.TrimSpace(args),
+		})
+	}
+	return is
+}
+
+// Reference gets the method, field, type, or string referenced by the
+// instruction, if any.
+func (x SmaliInstruction) Reference() string {
+	ops := smaliOperands(x.Operands)
+	if last := ops[len(ops)-1]; len(ops) > 1 && !strings.HasPrefix(last, ":") && !strings.HasPrefix(last, "{") && !isSmaliRegister(last) {
+		return last
+	}
+	return ""
+}
+
+// smaliOperands splits instruction operands on the commas which aren't in a
+// register list or string literal.
+func smaliOperands(s string) []string {
+	var (
+		ops         []string
+		start, list int
+		quote, esc  bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case esc:
+			esc = false
+		case quote:
+			switch c {
+			case '\\':
+				esc = true
+			case '"':
+				quote = false
+			}
+		case c == '"':
+			quote = true
+		case c == '{':
+			list++
+		case c == '}':
+			list--
+		case c == ',' && list == 0:
+			ops = append(ops, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(ops, strings.TrimSpace(s[start:]))
+}
+
+// FindInvoke gets the first invoke instruction calling the specified method.
+// The method may include the class (e.g., Ljava/lang/Object;->toString), and
+// the descriptor may be omitted (e.g., toString).
+func (m *SmaliMethod) FindInvoke(method string) (SmaliInstruction, bool) {
+	for _, x := range m.Instructions() {
+		if !strings.HasPrefix(x.Opcode, "invoke-") {
+			continue
+		}
+		if ref := x.Reference(); smaliMethodMatches(ref, method) {
+			return x, true
+		}
+	}
+	return SmaliInstruction{}, false
+}
+
+// Insert inserts code before the specified line of the method body. The code
+// is re-indented to match the rest of the method, and is followed by a blank
+// line like the instructions apktool outputs.
+func (m *SmaliMethod) Insert(index int, code string) {
+	m.Body = slices.Insert(m.Body, index, append(reindentLines(code, "    "), "")...)
+}
+
+// invokeCode replaces the placeholders in code to be inserted next to the
+// invoke instruction x. {{reg:N}} is the Nth register passed to the method
+// (starting from 0), {{reg:result}} is the register the result is moved to,
+// and {{label:NAME}} is a new label which doesn't conflict with the existing
+// ones. It is an error if code defines a label which already exists.
+func (m *SmaliMethod) invokeCode(x SmaliInstruction, result, code string) (string, error) {
+	var (
+		errs   []error
+		args   = smaliRegisters(x.Operands)
+		labels = m.Labels()
+		added  = map[string]string{}
+	)
+	for _, l := range strings.Split(code, "\n") {
+		if l = strings.TrimSpace(l); strings.HasPrefix(l, ":") && slices.Contains(labels, l) {
+			errs = append(errs, fmt.Errorf("label %s already exists", l))
+		}
+	}
+	code = placeholderRe.ReplaceAllStringFunc(code, func(p string) string {
+		switch sm := placeholderRe.FindStringSubmatch(p); sm[1] {
+		case "reg":
+			if sm[2] == "result" {
+				if result == "" {
+					errs = append(errs, fmt.Errorf("%s: the result of %s is not used", p, x.Reference()))
+				}
+				return result
+			}
+			if i, err := strconv.Atoi(sm[2]); err == nil && i >= 0 && i < len(args) {
+				return args[i]
+			}
+			errs = append(errs, fmt.Errorf("%s: %s only has %d registers", p, x.Reference(), len(args)))
+		case "label":
+			l, ok := added[sm[2]]
+			if !ok {
+				l = ":" + sm[2]
+				for i := 1; slices.Contains(labels, l); i++ {
+					l = ":" + sm[2] + "_" + strconv.Itoa(i)
+				}
+				labels = append(labels, l)
+				added[sm[2]] = l
+			}
+			return l
+		}
+		return p
+	})
+	return code, errors.Join(errs...)
+}
+
+// EditSmali parses the smali, then calls fn to modify it.
+func EditSmali(fn func(c *Smali) error) StringPatcher {
+	return StringPatcherFunc(func(s string) (string, error) {
+		c, err := ParseSmali(s)
+		if err != nil {
+			return s, fmt.Errorf("parse smali: %w", err)
+		}
+		if err := fn(c); err != nil {
+			return s, err
+		}
+		return c.String(), nil
+	})
+}
+
+// AddField adds a field declaration after the existing fields.
+func AddField(decl string) StringPatcher {
+	return EditSmali(func(c *Smali) error {
+		return c.AddField(decl)
+	})
+}
+
+// AddMethod adds a method definition to the end of the class.
+func AddMethod(code string) StringPatcher {
+	return EditSmali(func(c *Smali) error {
+		return c.AddMethod(code)
+	})
+}
+
+// InsertBeforeInvoke inserts code before the first call to target (see
+// [SmaliMethod.FindInvoke]) in method. In code, {{reg:N}} is replaced with the
+// Nth register passed to target (starting from 0), and {{label:NAME}} is
+// replaced with a new label.
+func InsertBeforeInvoke(method, target, code string) StringPatcher {
+	return EditSmali(func(c *Smali) error {
+		m, err := c.Method(method)
+		if err != nil {
+			return err
+		}
+		x, ok := m.FindInvoke(target)
+		if !ok {
+			return fmt.Errorf("could not find invoke of %q in method %q", target, method)
+		}
+		code, err := m.invokeCode(x, "", code)
+		if err != nil {
+			return fmt.Errorf("method %q: %w", method, err)
+		}
+		m.Insert(x.Index, code)
+		return nil
+	})
+}
+
+// InsertAfterInvoke inserts code after the first call to target in method (and
+// the move-result instruction following it, if any). It supports the same
+// placeholders as [InsertBeforeInvoke], plus {{reg:result}} for the register
+// the result was moved to.
+func InsertAfterInvoke(method, target, code string) StringPatcher {
+	return EditSmali(func(c *Smali) error {
+		m, err := c.Method(method)
+		if err != nil {
+			return err
+		}
+		x, ok := m.FindInvoke(target)
+		if !ok {
+			return fmt.Errorf("could not find invoke of %q in method %q", target, method)
+		}
+		var (
+			at     = x.Index + 1
+			result string
+		)
+		for _, y := range m.Instructions() {
+			if y.Index > x.Index {
+				if strings.HasPrefix(y.Opcode, "move-result") {
+					at, result = y.Index+1, y.Operands
+				}
+				break
+			}
+		}
+		if at < len(m.Body) && strings.TrimSpace(m.Body[at]) == "" {
+			at++
+		}
+		code, err := m.invokeCode(x, result, code)
+		if err != nil {
+			return fmt.Errorf("method %q: %w", method, err)
+		}
+		m.Insert(at, code)
+		return nil
+	})
+}
+
+// AddLocals adds n local registers to method, then runs the patchers returned
+// by fn (which is passed the new register names) on the method body.
+func AddLocals(method string, n int, fn func(regs []string) []StringPatcher) StringPatcher {
+	return EditSmali(func(c *Smali) error {
+		m, err := c.Method(method)
+		if err != nil {
+			return err
+		}
+		regs, err := m.AddLocals(n)
+		if err != nil {
+			return fmt.Errorf("method %q: %w", method, err)
+		}
+		body := strings.Join(m.Body, "\n") + "\n"
+		var errs []error
+		for _, pt := range fn(regs) {
+			out, err := pt.PatchString(body)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not run patcher in method %q: %w", method, err))
+				continue
+			}
+			body = out
+		}
+		if len(errs) != 0 {
+			return errors.Join(errs...)
+		}
+		m.Body = strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+		return nil
+	})
+}
+
+// reindentLines splits code into lines, removing the common indentation and
+// leading/trailing blank lines, then indents it with indent.
+func reindentLines(code, indent string) []string {
+	lines := strings.Split(strings.ReplaceAll(code, "\t", "    "), "\n")
+	for len(lines) != 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) != 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	common := -1
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			if n := len(l) - len(strings.TrimLeft(l, " ")); common == -1 || n < common {
+				common = n
+			}
+		}
+	}
+	for i, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			lines[i] = ""
+		} else {
+			lines[i] = indent + l[common:]
+		}
+	}
+	return lines
+}
+
+// smaliMethodMatches checks if ref (e.g., Lcls;->name(desc)ret) matches the
+// method spec, which may omit the class and/or descriptor.
+func smaliMethodMatches(ref, spec string) bool {
+	if ref == spec {
+		return true
+	}
+	_, name, ok := strings.Cut(ref, "->")
+	if !ok {
+		return false
+	}
+	if strings.Contains(spec, "->") {
+		return !strings.Contains(spec, "(") && strings.HasPrefix(ref, spec+"(")
+	}
+	if strings.Contains(spec, "(") {
+		return name == spec
+	}
+	return strings.HasPrefix(name, spec+"(")
+}
+
+// smaliParamRegisters gets the number of registers used by the parameters in
+// a method descriptor, excluding the implicit this parameter.
+func smaliParamRegisters(method string) (int, error) {
+	i, j := strings.IndexByte(method, '('), strings.IndexByte(method, ')')
+	if i == -1 || j < i {
+		return 0, fmt.Errorf("invalid method descriptor %q", method)
+	}
+	var n int
+	for d := method[i+1 : j]; d != ""; {
+		switch d[0] {
+		case 'J', 'D':
+			n += 2
+			d = d[1:]
+		case 'L':
+			e := strings.IndexByte(d, ';')
+			if e == -1 {
+				return 0, fmt.Errorf("invalid method descriptor %q", method)
+			}
+			n++
+			d = d[e+1:]
+		case '[':
+			d = strings.TrimLeft(d, "[")
+			if d == "" {
+				return 0, fmt.Errorf("invalid method descriptor %q", method)
+			}
+			if d[0] == 'L' {
+				e := strings.IndexByte(d, ';')
+				if e == -1 {
+					return 0, fmt.Errorf("invalid method descriptor %q", method)
+				}
+				d = d[e+1:]
+			} else {
+				d = d[1:]
+			}
+			n++
+		case 'Z', 'B', 'S', 'C', 'I', 'F':
+			n++
+			d = d[1:]
+		default:
+			return 0, fmt.Errorf("invalid method descriptor %q", method)
+		}
+	}
+	return n, nil
+}
+
+func isSmaliRegister(s string) bool {
+	if len(s) < 2 || (s[0] != 'v' && s[0] != 'p') {
+		return false
+	}
+	_, err := strconv.Atoi(s[1:])
+	return err == nil
+}
@@ -0,0 +1,174 @@
+package patchdef
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSmali = `.class public Lcom/example/Test;
+.super Ljava/lang/Object;
+.source "Test.java"
+
+
+# virtual methods
+.method public test(Ljava/lang/String;)Ljava/lang/String;
+    .locals 2
+
+    if-eqz p1, :skip
+
+    .line 10
+    invoke-virtual {p0, p1}, Lcom/example/Test;->check(Ljava/lang/String;)V
+
+    :skip
+    invoke-virtual {p1}, Ljava/lang/String;->trim()Ljava/lang/String;
+
+    move-result-object v0
+
+    invoke-virtual {p0}, Ljava/lang/Object;->toString()Ljava/lang/String;
+
+    return-object v0
+.end method
+`
+
+func TestFindInvoke(t *testing.T) {
+	c, err := ParseSmali(testSmali)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	m, err := c.Method("test(Ljava/lang/String;)Ljava/lang/String;")
+	if err != nil {
+		t.Fatalf("get method: %v", err)
+	}
+	for _, tc := range []struct {
+		spec  string
+		index int // -1 if not found
+	}{
+		{"Lcom/example/Test;->check(Ljava/lang/String;)V", 5},
+		{"Lcom/example/Test;->check", 5},
+		{"check(Ljava/lang/String;)V", 5},
+		{"check", 5},
+		{"trim", 8},
+		{"toString", 12},
+		{"Ljava/lang/String;->toString", -1},
+		{"check(I)V", -1},
+		{"chec", -1},
+	} {
+		x, ok := m.FindInvoke(tc.spec)
+		if tc.index == -1 {
+			if ok {
+				t.Errorf("FindInvoke(%q): expected no match, got line %d", tc.spec, x.Index)
+			}
+			continue
+		}
+		if !ok || x.Index != tc.index {
+			t.Errorf("FindInvoke(%q): expected line %d, got %d (found=%t)", tc.spec, tc.index, x.Index, ok)
+		}
+	}
+}
+
+func TestInsertInvoke(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		pt   StringPatcher
+		exp  string // the changed lines of the method body
+		err  string
+	}{
+		{
+			name: "Before",
+			pt:   InsertBeforeInvoke("test(Ljava/lang/String;)Ljava/lang/String;", "check", "invoke-static {{{reg:1}}}, Lcom/example/Log;->log(Ljava/lang/String;)V"),
+			exp: `
+    .line 10
+    invoke-static {p1}, Lcom/example/Log;->log(Ljava/lang/String;)V
+
+    invoke-virtual {p0, p1}, Lcom/example/Test;->check(Ljava/lang/String;)V
+`,
+		},
+		{
+			name: "BeforeLabel",
+			pt: InsertBeforeInvoke("test(Ljava/lang/String;)Ljava/lang/String;", "Ljava/lang/String;->trim", `
+				if-nez {{reg:0}}, {{label:skip}}
+				const-string {{reg:0}}, ""
+				{{label:skip}}
+			`),
+			exp: `
+    :skip
+    if-nez p1, :skip_1
+    const-string p1, ""
+    :skip_1
+
+    invoke-virtual {p1}, Ljava/lang/String;->trim()Ljava/lang/String;
+`,
+		},
+		{
+			name: "After",
+			pt:   InsertAfterInvoke("test(Ljava/lang/String;)Ljava/lang/String;", "trim", "invoke-static {{{reg:result}}}, Lcom/example/Log;->log(Ljava/lang/String;)V"),
+			exp: `
+    move-result-object v0
+
+    invoke-static {v0}, Lcom/example/Log;->log(Ljava/lang/String;)V
+
+    invoke-virtual {p0}, Ljava/lang/Object;->toString()Ljava/lang/String;
+`,
+		},
+		{
+			name: "AfterNoResult",
+			pt:   InsertAfterInvoke("test(Ljava/lang/String;)Ljava/lang/String;", "toString", "const/4 v1, 0x0"),
+			exp: `
+    invoke-virtual {p0}, Ljava/lang/Object;->toString()Ljava/lang/String;
+
+    const/4 v1, 0x0
+
+    return-object v0
+`,
+		},
+		{
+			name: "MissingInvoke",
+			pt:   InsertBeforeInvoke("test(Ljava/lang/String;)Ljava/lang/String;", "missing", "nop"),
+			err:  `could not find invoke of "missing"`,
+		},
+		{
+			name: "MissingMethod",
+			pt:   InsertAfterInvoke("missing()V", "check", "nop"),
+			err:  `missing()V`,
+		},
+		{
+			name: "ResultBefore",
+			pt:   InsertBeforeInvoke("test(Ljava/lang/String;)Ljava/lang/String;", "trim", "invoke-static {{{reg:result}}}, Lcom/example/Log;->log(Ljava/lang/String;)V"),
+			err:  `{{reg:result}}: the result of Ljava/lang/String;->trim()Ljava/lang/String; is not used`,
+		},
+		{
+			name: "UnusedResult",
+			pt:   InsertAfterInvoke("test(Ljava/lang/String;)Ljava/lang/String;", "toString", "invoke-static {{{reg:result}}}, Lcom/example/Log;->log(Ljava/lang/String;)V"),
+			err:  `{{reg:result}}: the result of Ljava/lang/Object;->toString()Ljava/lang/String; is not used`,
+		},
+		{
+			name: "RegisterRange",
+			pt:   InsertBeforeInvoke("test(Ljava/lang/String;)Ljava/lang/String;", "check", "invoke-static {{{reg:2}}}, Lcom/example/Log;->log(Ljava/lang/String;)V"),
+			err:  `{{reg:2}}: Lcom/example/Test;->check(Ljava/lang/String;)V only has 2 registers`,
+		},
+		{
+			name: "ExistingLabel",
+			pt:   InsertBeforeInvoke("test(Ljava/lang/String;)Ljava/lang/String;", "check", "goto :skip\n:skip"),
+			err:  `label :skip already exists`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := tc.pt.PatchString(testSmali)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("patch: %v", err)
+			}
+			if !strings.Contains(out, tc.exp) {
+				t.Errorf("expected output to contain:\n%s\ngot:\n%s", tc.exp, out)
+			}
+			if _, err := ParseSmali(out); err != nil {
+				t.Errorf("parse output: %v", err)
+			}
+		})
+	}
+}
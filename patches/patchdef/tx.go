@@ -0,0 +1,233 @@
+package patchdef
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/hexops/gotextdiff"
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+)
+
+// tx stages the changes made by the instructions of a patch so they can be
+// committed all at once after every instruction succeeds. It is passed to the
+// instructions as the diff writer, and the built-in instructions read and write
+// files through it.
+type tx struct {
+	apk   string
+	patch string
+	diff  bytes.Buffer
+	files map[string][]byte // nil if deleted
+	order []string
+	stats []*FileReport // for each staged change
+}
+
+func newTx(apk, patch string) *tx {
+	return &tx{
+		apk:   apk,
+		patch: patch,
+		files: map[string][]byte{},
+	}
+}
+
+// history records the files changed by each patch during this run.
+var history struct {
+	sync.Mutex
+	created map[string]string   // file -> patch which created it
+	changed map[string][]string // file -> patches which changed it
+	orig    map[string][]byte   // smali file -> original contents, nil if created
+}
+
+// createdBy gets the patch which created the file during this run, if any.
+func createdBy(name string) string {
+	history.Lock()
+	defer history.Unlock()
+	return history.created[path.Clean(filepath.ToSlash(name))]
+}
+
+// changedBy gets the patches which changed the file during this run.
+func changedBy(name string) []string {
+	history.Lock()
+	defer history.Unlock()
+	return slices.Clone(history.changed[path.Clean(filepath.ToSlash(name))])
+}
+
+// original gets the contents of a smali file before it was first changed during
+// this run, and whether it was changed.
+func original(name string) ([]byte, bool) {
+	history.Lock()
+	defer history.Unlock()
+	buf, ok := history.orig[path.Clean(filepath.ToSlash(name))]
+	return buf, ok
+}
+
+// snapshot saves the original contents of a smali file before it is changed
+// for the first time.
+func (t *tx) snapshot(name string) {
+	if path.Ext(name) != ".smali" {
+		return
+	}
+	history.Lock()
+	defer history.Unlock()
+	if history.orig == nil {
+		history.orig = map[string][]byte{}
+	}
+	if _, ok := history.orig[name]; !ok {
+		buf, _ := os.ReadFile(filepath.Join(t.apk, filepath.FromSlash(name)))
+		history.orig[name] = buf
+	}
+}
+
+func (t *tx) record(name string, created bool) {
+	history.Lock()
+	defer history.Unlock()
+	if history.created == nil {
+		history.created = map[string]string{}
+		history.changed = map[string][]string{}
+	}
+	if created {
+		history.created[name] = t.patch
+	} else if t.files[name] == nil {
+		delete(history.created, name)
+	}
+	if ps := history.changed[name]; !slices.Contains(ps, t.patch) {
+		history.changed[name] = append(ps, t.patch)
+	}
+}
+
+func (t *tx) Write(b []byte) (int, error) {
+	return t.diff.Write(b)
+}
+
+func (t *tx) stage(name string, data []byte) {
+	old, ok := t.files[name]
+	if !ok {
+		old, _ = os.ReadFile(filepath.Join(t.apk, filepath.FromSlash(name)))
+		t.order = append(t.order, name)
+	}
+	t.files[name] = data
+	t.stats = append(t.stats, fileStats(name, old, data))
+}
+
+// fileStats describes the change to a file from old to new, where nil means the
+// file doesn't exist.
+func fileStats(name string, old, new []byte) *FileReport {
+	f := &FileReport{
+		Name:    name,
+		Created: old == nil && new != nil,
+		Deleted: new == nil,
+		Binary:  !isText(old) || !isText(new),
+	}
+	if !f.Binary {
+		for _, h := range gotextdiff.ToUnified("", "", string(old), myers.ComputeEdits(span.URIFromPath(name), string(old), string(new))).Hunks {
+			for _, l := range h.Lines {
+				switch l.Kind {
+				case gotextdiff.Insert:
+					f.Added++
+				case gotextdiff.Delete:
+					f.Removed++
+				}
+			}
+		}
+	}
+	return f
+}
+
+// commit writes the staged files to the APK and the diff to diffwriter.
+func (t *tx) commit(diffwriter io.Writer) error {
+	for _, name := range t.order {
+		p := filepath.Join(t.apk, filepath.FromSlash(name))
+		t.snapshot(name)
+		if data := t.files[name]; data == nil {
+			if err := os.Remove(p); err != nil {
+				return fmt.Errorf("commit: %w", err)
+			}
+			t.record(name, false)
+		} else {
+			_, err := os.Stat(p)
+			created := errors.Is(err, fs.ErrNotExist)
+			if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+				return fmt.Errorf("commit: %w", err)
+			}
+			if err := os.WriteFile(p, data, 0666); err != nil {
+				return fmt.Errorf("commit: %w", err)
+			}
+			t.record(name, created)
+		}
+	}
+	if _, err := t.diff.WriteTo(diffwriter); err != nil {
+		return fmt.Errorf("commit: write diff: %w", err)
+	}
+	return nil
+}
+
+// txFor gets the transaction the file operations for apk should be staged in,
+// if any.
+func txFor(apk string, diffwriter io.Writer) *tx {
+	if t, ok := diffwriter.(*tx); ok && t.apk == apk {
+		return t
+	}
+	return nil
+}
+
+// ReadFile reads a file from the APK for an [Instruction], including any
+// changes staged by earlier instructions of the patch.
+func ReadFile(apk string, diffwriter io.Writer, name string) ([]byte, error) {
+	return readFile(apk, diffwriter, name)
+}
+
+// readFile reads a file from the APK, including any staged changes.
+func readFile(apk string, diffwriter io.Writer, name string) ([]byte, error) {
+	name = path.Clean(filepath.ToSlash(name))
+	if t := txFor(apk, diffwriter); t != nil {
+		if data, ok := t.files[name]; ok {
+			if data == nil {
+				return nil, &fs.PathError{Op: "open", Path: filepath.Join(apk, filepath.FromSlash(name)), Err: fs.ErrNotExist}
+			}
+			return bytes.Clone(data), nil
+		}
+	}
+	return os.ReadFile(filepath.Join(apk, filepath.FromSlash(name)))
+}
+
+// writeFile writes a file to the APK, creating parent directories as needed.
+func writeFile(apk string, diffwriter io.Writer, name string, data []byte) error {
+	name = path.Clean(filepath.ToSlash(name))
+	if t := txFor(apk, diffwriter); t != nil {
+		if data == nil {
+			data = []byte{}
+		}
+		t.stage(name, bytes.Clone(data))
+		return nil
+	}
+	p := filepath.Join(apk, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0666)
+}
+
+// removeFile removes a file from the APK.
+func removeFile(apk string, diffwriter io.Writer, name string) error {
+	name = path.Clean(filepath.ToSlash(name))
+	if t := txFor(apk, diffwriter); t != nil {
+		if data, ok := t.files[name]; !ok {
+			if _, err := os.Stat(filepath.Join(apk, filepath.FromSlash(name))); err != nil {
+				return err
+			}
+		} else if data == nil {
+			return &fs.PathError{Op: "remove", Path: filepath.Join(apk, filepath.FromSlash(name)), Err: fs.ErrNotExist}
+		}
+		t.stage(name, nil)
+		return nil
+	}
+	return os.Remove(filepath.Join(apk, filepath.FromSlash(name)))
+}
@@ -0,0 +1,79 @@
+package patchdef
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testReadInst struct {
+	Name string
+	Data string
+}
+
+func (x *testReadInst) Do(apk string, diffwriter io.Writer) error {
+	buf, err := ReadFile(apk, diffwriter, x.Name)
+	if err != nil {
+		return err
+	}
+	if string(buf) != x.Data {
+		return errors.New("unexpected contents " + string(buf))
+	}
+	return nil
+}
+
+func TestTx(t *testing.T) {
+	apk := testAPKDir(t, map[string]string{
+		"a.txt": "a\n",
+		"b.txt": "b\n",
+	})
+	for _, tc := range []struct {
+		name string
+		inst []Instruction
+		err  bool
+		exp  map[string]string // nil if deleted
+	}{
+		{
+			name: "Rollback",
+			inst: []Instruction{
+				WriteFileString("c.txt", "c\n"),
+				PatchFile("a.txt", ReplaceString("a", "x")),
+				&testReadInst{"a.txt", "x\n"},
+				DeleteFile("b.txt"),
+				&testReadInst{"b.txt", ""},
+			},
+			err: true,
+			exp: map[string]string{"a.txt": "a\n", "b.txt": "b\n"},
+		},
+		{
+			name: "Commit",
+			inst: []Instruction{
+				WriteFileString("c/c.txt", "c\n"),
+				&testReadInst{"c/c.txt", "c\n"},
+				PatchFile("a.txt", ReplaceString("a", "x")),
+				DeleteFile("b.txt"),
+			},
+			exp: map[string]string{"a.txt": "x\n", "c/c.txt": "c\n"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Patch{name: "test", inst: tc.inst}.run(apk, io.Discard, false)
+			if tc.err != (err != nil) {
+				t.Fatalf("expected error=%t, got %v", tc.err, err)
+			}
+			for _, name := range []string{"a.txt", "b.txt", "c.txt", "c/c.txt"} {
+				buf, err := os.ReadFile(filepath.Join(apk, filepath.FromSlash(name)))
+				if exp, ok := tc.exp[name]; !ok {
+					if !errors.Is(err, fs.ErrNotExist) {
+						t.Errorf("expected %s to not exist, got %v", name, err)
+					}
+				} else if string(buf) != exp {
+					t.Errorf("expected %s to contain %q, got %q (%v)", name, exp, buf, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,450 @@
+package patchdef
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// frameworkPackages are the packages containing classes from the Android
+// framework, which are only checked if android.jar is available.
+var frameworkPackages = []string{
+	"java/",
+	"javax/",
+	"android/",
+	"dalvik/",
+	"libcore/",
+	"sun/",
+	"com/android/",
+	"org/apache/http/",
+	"org/json/",
+	"org/w3c/",
+	"org/xml/",
+	"org/xmlpull/",
+}
+
+// objectClass is used for java/lang/Object if android.jar is not available,
+// since every class inherits from it.
+var objectClass = &stubClass{
+	Name: "java/lang/Object",
+	Methods: []stubMember{
+		{Name: "<init>", Desc: "()V"},
+		{Name: "clone", Desc: "()Ljava/lang/Object;"},
+		{Name: "equals", Desc: "(Ljava/lang/Object;)Z"},
+		{Name: "finalize", Desc: "()V"},
+		{Name: "getClass", Desc: "()Ljava/lang/Class;"},
+		{Name: "hashCode", Desc: "()I"},
+		{Name: "notify", Desc: "()V"},
+		{Name: "notifyAll", Desc: "()V"},
+		{Name: "toString", Desc: "()Ljava/lang/String;"},
+		{Name: "wait", Desc: "()V"},
+		{Name: "wait", Desc: "(J)V"},
+		{Name: "wait", Desc: "(JI)V"},
+	},
+}
+
+// VerifySmali checks the methods added or changed in the smali files changed
+// during this run for references to classes, methods, and fields which don't
+// exist in the decompiled APK or android.jar (if [JavaTools].AndroidJar is
+// set), invalid method descriptors, and registers outside the range declared by
+// .locals or .registers.
+func VerifySmali(apk string) error {
+	cp, err := newClasspath(apk, JavaTools.AndroidJar)
+	if err != nil {
+		return err
+	}
+	defer cp.Close()
+
+	history.Lock()
+	names := make([]string, 0, len(history.orig))
+	for name := range history.orig {
+		names = append(names, name)
+	}
+	history.Unlock()
+	slices.Sort(names)
+
+	var errs []error
+	for _, name := range names {
+		if dir, _, _ := strings.Cut(name, "/"); !isSmaliDir(dir) {
+			continue
+		}
+		buf, err := os.ReadFile(filepath.Join(apk, filepath.FromSlash(name)))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue // deleted
+			}
+			return err
+		}
+		orig, _ := original(name)
+		for _, err := range verifySmaliFile(cp, string(buf), string(orig)) {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", name, strings.Join(changedBy(name), ", "), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// verifySmaliFile checks the methods in s which are not identical in orig.
+func verifySmaliFile(cp *classpath, s, orig string) []error {
+	c, err := ParseSmali(s)
+	if err != nil {
+		return []error{err}
+	}
+	unchanged := map[string]string{}
+	if o, err := ParseSmali(orig); err == nil {
+		for _, m := range o.Methods {
+			unchanged[m.Name] = m.Header + "\n" + strings.Join(m.Body, "\n")
+		}
+	}
+	var (
+		errs []error
+		line = 1
+	)
+	for _, x := range c.items {
+		switch x := x.(type) {
+		case []string:
+			line += len(x)
+		case *SmaliField:
+			line += len(x.Lines)
+		case *SmaliMethod:
+			if u, ok := unchanged[x.Name]; !ok || u != x.Header+"\n"+strings.Join(x.Body, "\n") {
+				for _, err := range verifySmaliMethod(cp, x) {
+					var le *lineError
+					if errors.As(err, &le) {
+						errs = append(errs, fmt.Errorf("line %d: method %q: %w", line+1+le.Index, x.Name, le.Err))
+					} else {
+						errs = append(errs, fmt.Errorf("line %d: method %q: %w", line, x.Name, err))
+					}
+				}
+			}
+			line += 2 + len(x.Body)
+		}
+	}
+	return errs
+}
+
+// lineError is an error for a line of a method body.
+type lineError struct {
+	Index int
+	Err   error
+}
+
+func (e *lineError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *lineError) Unwrap() error {
+	return e.Err
+}
+
+// verifySmaliMethod checks the descriptor, registers, and references of a
+// method.
+func verifySmaliMethod(cp *classpath, m *SmaliMethod) []error {
+	if _, desc, _ := strings.Cut(m.Name, "("); !validMethodDescriptor("(" + desc) {
+		return []error{fmt.Errorf("invalid method descriptor")}
+	}
+	is := m.Instructions()
+	if len(is) == 0 {
+		return nil // abstract or native
+	}
+	params, err := m.Params()
+	if err != nil {
+		return []error{err}
+	}
+	locals, err := m.Locals()
+	if err != nil {
+		return []error{err}
+	}
+	if locals < 0 {
+		return []error{fmt.Errorf("not enough registers for the parameters")}
+	}
+
+	var errs []error
+	for _, x := range is {
+		fail := func(format string, a ...any) {
+			errs = append(errs, &lineError{x.Index, fmt.Errorf(format, a...)})
+		}
+		for _, r := range smaliRegisters(x.Operands) {
+			n, _ := strconv.Atoi(r[1:])
+			switch {
+			case r[0] == 'p' && n >= params:
+				fail("register %s out of range (%d parameter registers)", r, params)
+			case r[0] == 'v' && n >= locals+params:
+				fail("register %s out of range (%d local and %d parameter registers)", r, locals, params)
+			}
+		}
+		if err := cp.verifyReference(x); err != nil {
+			errs = append(errs, &lineError{x.Index, err})
+		}
+	}
+	return errs
+}
+
+// smaliRegisters gets the registers used by the instruction operands.
+func smaliRegisters(operands string) []string {
+	var rs []string
+	if rest, ok := strings.CutPrefix(operands, "{"); ok {
+		list, _, _ := strings.Cut(rest, "}")
+		if a, b, ok := strings.Cut(list, " .. "); ok {
+			if isSmaliRegister(a) && isSmaliRegister(b) && a[0] == b[0] {
+				x, _ := strconv.Atoi(a[1:])
+				y, _ := strconv.Atoi(b[1:])
+				for i := x; i <= y; i++ {
+					rs = append(rs, a[:1]+strconv.Itoa(i))
+				}
+			}
+			return rs
+		}
+		for r := range strings.SplitSeq(list, ",") {
+			if r = strings.TrimSpace(r); isSmaliRegister(r) {
+				rs = append(rs, r)
+			}
+		}
+		return rs
+	}
+	for r := range strings.SplitSeq(operands, ", ") {
+		if !isSmaliRegister(r) {
+			break
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// verifyReference checks that the class, method, or field referenced by an
+// instruction exists.
+func (cp *classpath) verifyReference(x SmaliInstruction) error {
+	ref := x.Reference()
+	if ref == "" || strings.HasPrefix(ref, `"`) {
+		return nil
+	}
+	owner, member, isMember := strings.Cut(ref, "->")
+	if !isMember {
+		switch x.Opcode {
+		case "new-instance", "check-cast", "instance-of", "const-class", "new-array", "filled-new-array", "filled-new-array/range":
+			if !validTypeDescriptor(ref, false) {
+				return fmt.Errorf("invalid type descriptor %q", ref)
+			}
+			if c, known := cp.class(ref); c == nil && known {
+				return fmt.Errorf("class %s not found", ref)
+			}
+		}
+		return nil
+	}
+	if !validTypeDescriptor(owner, false) {
+		return fmt.Errorf("invalid class %q", owner)
+	}
+	if owner[0] == '[' {
+		return nil // array methods (e.g., clone)
+	}
+	c, known := cp.class(owner)
+	if c == nil {
+		if known {
+			return fmt.Errorf("class %s not found", owner)
+		}
+		return nil
+	}
+	if name, desc, ok := strings.Cut(member, "("); ok {
+		if !validMethodDescriptor("(" + desc) {
+			return fmt.Errorf("invalid method descriptor in %s", ref)
+		}
+		if found, known := cp.member(c, false, name, "("+desc); !found && known {
+			return fmt.Errorf("method %s not found", ref)
+		}
+		return nil
+	}
+	if name, typ, ok := strings.Cut(member, ":"); ok {
+		if !validTypeDescriptor(typ, false) {
+			return fmt.Errorf("invalid field type in %s", ref)
+		}
+		if found, known := cp.member(c, true, name, typ); !found && known {
+			return fmt.Errorf("field %s not found", ref)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid reference %q", ref)
+}
+
+// validTypeDescriptor checks if s is a valid type descriptor.
+func validTypeDescriptor(s string, void bool) bool {
+	n, ok := typeDescriptorLen(s, void)
+	return ok && n == len(s)
+}
+
+// validMethodDescriptor checks if s is a valid method descriptor (including
+// the parentheses and return type).
+func validMethodDescriptor(s string) bool {
+	s, ok := strings.CutPrefix(s, "(")
+	if !ok {
+		return false
+	}
+	for !strings.HasPrefix(s, ")") {
+		n, ok := typeDescriptorLen(s, false)
+		if !ok {
+			return false
+		}
+		s = s[n:]
+	}
+	return validTypeDescriptor(s[1:], true)
+}
+
+// typeDescriptorLen gets the length of the type descriptor at the start of s.
+func typeDescriptorLen(s string, void bool) (int, bool) {
+	dims := len(s) - len(strings.TrimLeft(s, "["))
+	if dims > 255 || dims == len(s) {
+		return 0, false
+	}
+	switch s[dims] {
+	case 'Z', 'B', 'S', 'C', 'I', 'J', 'F', 'D':
+		return dims + 1, true
+	case 'V':
+		return dims + 1, void && dims == 0
+	case 'L':
+		i := strings.IndexByte(s[dims:], ';')
+		if i <= 1 || strings.ContainsAny(s[dims:dims+i], ".[()") {
+			return 0, false
+		}
+		return dims + i + 1, true
+	}
+	return 0, false
+}
+
+// classpath looks up the declarations of classes in the decompiled APK and
+// android.jar.
+type classpath struct {
+	apk     string
+	jar     *zip.ReadCloser
+	jarFile map[string]*zip.File
+	cache   map[string]*stubClass
+}
+
+func newClasspath(apk, androidJar string) (*classpath, error) {
+	cp := &classpath{
+		apk:   apk,
+		cache: map[string]*stubClass{},
+	}
+	if androidJar != "" {
+		zr, err := zip.OpenReader(androidJar)
+		if err != nil {
+			return nil, fmt.Errorf("open android.jar: %w", err)
+		}
+		cp.jar = zr
+		cp.jarFile = map[string]*zip.File{}
+		for _, zf := range zr.File {
+			if name, ok := strings.CutSuffix(zf.Name, ".class"); ok {
+				cp.jarFile[name] = zf
+			}
+		}
+	}
+	return cp, nil
+}
+
+func (cp *classpath) Close() error {
+	if cp.jar != nil {
+		return cp.jar.Close()
+	}
+	return nil
+}
+
+// class looks up a class by descriptor (e.g., Ljava/lang/Object;). If it isn't
+// found, known is false if it may be a framework class which can't be checked
+// without android.jar. Array and primitive types always exist.
+func (cp *classpath) class(desc string) (c *stubClass, known bool) {
+	desc = strings.TrimLeft(desc, "[")
+	if len(desc) == 1 {
+		return &stubClass{Name: desc}, true
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(desc, "L"), ";")
+	if c, ok := cp.cache[name]; ok {
+		return c, c != nil || cp.known(name)
+	}
+	if c = cp.load(name); c == nil && cp.jar == nil && name == objectClass.Name {
+		c = objectClass
+	}
+	cp.cache[name] = c
+	return c, c != nil || cp.known(name)
+}
+
+// known checks if the absence of a class can be relied on.
+func (cp *classpath) known(name string) bool {
+	return cp.jar != nil || !slices.ContainsFunc(frameworkPackages, func(p string) bool {
+		return strings.HasPrefix(name, p)
+	})
+}
+
+// load reads and parses a class from the decompiled APK or android.jar.
+func (cp *classpath) load(name string) *stubClass {
+	if ents, err := os.ReadDir(cp.apk); err == nil {
+		for _, ent := range ents {
+			if !ent.IsDir() || !isSmaliDir(ent.Name()) {
+				continue
+			}
+			buf, err := os.ReadFile(filepath.Join(cp.apk, ent.Name(), filepath.FromSlash(name)+".smali"))
+			if err != nil {
+				continue
+			}
+			if c, err := parseStubClass(string(buf)); err == nil && c.Name == name {
+				return c
+			}
+		}
+	}
+	if zf, ok := cp.jarFile[name]; ok {
+		rc, err := zf.Open()
+		if err != nil {
+			return nil
+		}
+		defer rc.Close()
+		buf, err := io.ReadAll(rc)
+		if err != nil {
+			return nil
+		}
+		if c, err := parseClassFile(buf); err == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// member checks if a field or method is declared by c or inherited from its
+// superclasses or interfaces. If it isn't found, known is false if one of
+// them could not be checked.
+func (cp *classpath) member(c *stubClass, field bool, name, desc string) (found, known bool) {
+	known = true
+	seen := map[string]bool{}
+	queue := []*stubClass{c}
+	for len(queue) != 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if seen[c.Name] {
+			continue
+		}
+		seen[c.Name] = true
+
+		ms := c.Methods
+		if field {
+			ms = c.Fields
+		}
+		if slices.ContainsFunc(ms, func(m stubMember) bool {
+			return m.Name == name && m.Desc == desc
+		}) {
+			return true, true
+		}
+		for _, s := range append([]string{c.Super}, c.Interfaces...) {
+			if s == "" {
+				continue
+			}
+			x, k := cp.class("L" + s + ";")
+			if x == nil {
+				known = known && k
+				continue
+			}
+			queue = append(queue, x)
+		}
+	}
+	return false, known
+}
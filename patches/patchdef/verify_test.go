@@ -0,0 +1,232 @@
+package patchdef
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testSeriesParser has the framework references made by the seriesmeta OPF
+// parser.
+const testSeriesParser = `.class public Lnet/pgaskin/seriesmeta/SeriesParser;
+.super Ljava/lang/Object;
+.source "SeriesParser.java"
+
+
+# instance fields
+.field public series:Ljava/lang/String;
+
+.field public seriesIndex:Ljava/lang/String;
+
+
+# direct methods
+.method public constructor <init>()V
+    .registers 1
+
+    invoke-direct {p0}, Ljava/lang/Object;-><init>()V
+
+    return-void
+.end method
+
+
+# virtual methods
+.method public parse(Ljava/io/InputStream;)Ljava/lang/String;
+    .registers 8
+
+    invoke-static {}, Landroid/util/Xml;->newPullParser()Lorg/xmlpull/v1/XmlPullParser;
+
+    move-result-object v0
+
+    const-string v1, "http://xmlpull.org/v1/doc/features.html#process-namespaces"
+
+    const/4 v2, 0x1
+
+    invoke-interface {v0, v1, v2}, Lorg/xmlpull/v1/XmlPullParser;->setFeature(Ljava/lang/String;Z)V
+
+    const/4 v1, 0x0
+
+    invoke-interface {v0, p1, v1}, Lorg/xmlpull/v1/XmlPullParser;->setInput(Ljava/io/InputStream;Ljava/lang/String;)V
+
+    new-instance v2, Ljava/util/LinkedHashMap;
+
+    invoke-direct {v2}, Ljava/util/LinkedHashMap;-><init>()V
+
+    invoke-virtual {v2, v1, v1}, Ljava/util/LinkedHashMap;->put(Ljava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object;
+
+    invoke-interface {v0}, Lorg/xmlpull/v1/XmlPullParser;->next()I
+
+    move-result v3
+
+    invoke-interface {v0}, Lorg/xmlpull/v1/XmlPullParser;->getText()Ljava/lang/String;
+
+    move-result-object v4
+
+    new-instance v5, Ljava/lang/StringBuilder;
+
+    invoke-direct {v5}, Ljava/lang/StringBuilder;-><init>()V
+
+    invoke-virtual {v5, v4}, Ljava/lang/StringBuilder;->append(Ljava/lang/String;)Ljava/lang/StringBuilder;
+
+    invoke-virtual {v5}, Ljava/lang/StringBuilder;->toString()Ljava/lang/String;
+
+    move-result-object v4
+
+    iput-object v4, p0, Lnet/pgaskin/seriesmeta/SeriesParser;->series:Ljava/lang/String;
+
+    return-object v4
+.end method
+`
+
+// testFramework gets stubs for the framework classes used by
+// testSeriesParser.
+func testFramework() []*stubClass {
+	return []*stubClass{
+		objectClass,
+		{Name: "java/lang/String", Super: "java/lang/Object"},
+		{Name: "java/lang/StringBuilder", Super: "java/lang/Object", Methods: []stubMember{
+			{Access: 0x0001, Name: "<init>", Desc: "()V"},
+			{Access: 0x0001, Name: "append", Desc: "(Ljava/lang/String;)Ljava/lang/StringBuilder;"},
+			{Access: 0x0001, Name: "toString", Desc: "()Ljava/lang/String;"},
+		}},
+		{Name: "java/io/InputStream", Super: "java/lang/Object"},
+		{Name: "java/util/HashMap", Super: "java/lang/Object", Methods: []stubMember{
+			{Access: 0x0001, Name: "put", Desc: "(Ljava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object;"},
+		}},
+		{Name: "java/util/LinkedHashMap", Super: "java/util/HashMap", Methods: []stubMember{
+			{Access: 0x0001, Name: "<init>", Desc: "()V"},
+		}},
+		{Name: "android/util/Xml", Super: "java/lang/Object", Methods: []stubMember{
+			{Access: 0x0009, Name: "newPullParser", Desc: "()Lorg/xmlpull/v1/XmlPullParser;"},
+		}},
+		{Name: "org/xmlpull/v1/XmlPullParser", Access: 0x0601, Super: "java/lang/Object", Methods: []stubMember{
+			{Access: 0x0401, Name: "setFeature", Desc: "(Ljava/lang/String;Z)V"},
+			{Access: 0x0401, Name: "setInput", Desc: "(Ljava/io/InputStream;Ljava/lang/String;)V"},
+			{Access: 0x0401, Name: "next", Desc: "()I"},
+			{Access: 0x0401, Name: "getText", Desc: "()Ljava/lang/String;"},
+		}},
+	}
+}
+
+func TestVerifySmali(t *testing.T) {
+	apk := testAPKDir(t, map[string]string{
+		"smali/net/pgaskin/seriesmeta/SeriesParser.smali": testSeriesParser,
+	})
+	for _, tc := range []struct {
+		name      string
+		framework func([]*stubClass) []*stubClass // nil for no android.jar
+		err       []string
+	}{
+		{
+			name: "NoFramework",
+		},
+		{
+			name:      "Framework",
+			framework: func(cs []*stubClass) []*stubClass { return cs },
+		},
+		{
+			name: "MissingClass",
+			framework: func(cs []*stubClass) []*stubClass {
+				return slices.DeleteFunc(cs, func(c *stubClass) bool { return c.Name == "org/xmlpull/v1/XmlPullParser" })
+			},
+			err: []string{
+				`line 34: method "parse(Ljava/io/InputStream;)Ljava/lang/String;": class Lorg/xmlpull/v1/XmlPullParser; not found`,
+				`line 38: method "parse(Ljava/io/InputStream;)Ljava/lang/String;": class Lorg/xmlpull/v1/XmlPullParser; not found`,
+				`line 46: method "parse(Ljava/io/InputStream;)Ljava/lang/String;": class Lorg/xmlpull/v1/XmlPullParser; not found`,
+				`line 50: method "parse(Ljava/io/InputStream;)Ljava/lang/String;": class Lorg/xmlpull/v1/XmlPullParser; not found`,
+			},
+		},
+		{
+			name: "MissingMethod",
+			framework: func(cs []*stubClass) []*stubClass {
+				for _, c := range cs {
+					if c.Name == "java/util/HashMap" {
+						c.Methods = nil
+					}
+				}
+				return cs
+			},
+			err: []string{
+				`line 44: method "parse(Ljava/io/InputStream;)Ljava/lang/String;": method Ljava/util/LinkedHashMap;->put(Ljava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object; not found`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var jar string
+			if tc.framework != nil {
+				var b bytes.Buffer
+				if err := writeStubJar(&b, tc.framework(testFramework())); err != nil {
+					t.Fatalf("write framework: %v", err)
+				}
+				jar = filepath.Join(t.TempDir(), "android.jar")
+				if err := os.WriteFile(jar, b.Bytes(), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cp, err := newClasspath(apk, jar)
+			if err != nil {
+				t.Fatalf("load classpath: %v", err)
+			}
+			defer cp.Close()
+
+			var act []string
+			for _, err := range verifySmaliFile(cp, testSeriesParser, "") {
+				act = append(act, err.Error())
+			}
+			if !slices.Equal(act, tc.err) {
+				t.Errorf("expected errors:\n\t%s\ngot:\n\t%s", strings.Join(tc.err, "\n\t"), strings.Join(act, "\n\t"))
+			}
+		})
+	}
+}
+
+// TestVerifyCompiledSmali compiles the seriesmeta OPF parser and checks it
+// with and without the framework. It requires javac, d8, android.jar (from
+// $ANDROID_HOME), and apktool (from lib).
+func TestVerifyCompiledSmali(t *testing.T) {
+	tools := JavaTools
+	defer func() { JavaTools = tools }()
+	for _, tool := range []string{JavaTools.Java, JavaTools.Javac, JavaTools.D8} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	jars, _ := filepath.Glob(filepath.Join(os.Getenv("ANDROID_HOME"), "platforms", "android-*", "android.jar"))
+	if len(jars) == 0 {
+		t.Skip("android.jar not found")
+	}
+	JavaTools.AndroidJar = jars[len(jars)-1]
+	if jars, _ := filepath.Glob(filepath.Join("..", "..", "lib", "apktool-*.jar")); len(jars) != 0 {
+		JavaTools.Baksmali = jars[len(jars)-1]
+	} else {
+		t.Skip("apktool not found")
+	}
+
+	apk := testAPKDir(t, map[string]string{
+		"apktool.yml": "sdkInfo:\n  minSdkVersion: '21'\n",
+	})
+	tx := newTx(apk, "test")
+	if err := CompileJava("smali", os.DirFS(filepath.Join("..", "seriesmeta"))).Do(apk, tx); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if err := tx.commit(io.Discard); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	for _, jar := range []string{"", JavaTools.AndroidJar} {
+		cp, err := newClasspath(apk, jar)
+		if err != nil {
+			t.Fatalf("load classpath: %v", err)
+		}
+		defer cp.Close()
+		for _, name := range tx.order {
+			if errs := verifySmaliFile(cp, string(tx.files[name]), ""); len(errs) != 0 {
+				t.Errorf("verify %s (android.jar %q): %v", name, jar, errors.Join(errs...))
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package patchdef
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+)
+
+// TargetVersion is the version of the app being patched. It is used to select
+// version-specific instructions, and should be set by the main program before
+// selecting and applying patches.
+var TargetVersion string
+
+// Versions declares the app versions the patch supports. Versions may be glob
+// patterns (e.g., "0.24.*"). If not specified, the patch is assumed to support
+// every version.
+func Versions(version ...string) Option {
+	return func(p *Patch) {
+		p.versions = append(p.versions, version...)
+	}
+}
+
+// Versions gets the app versions the patch supports, or nil if it supports
+// every version.
+func (p Patch) Versions() []string {
+	return slices.Clone(p.versions)
+}
+
+// Supports checks if the patch supports the specified app version. If the
+// version is unknown (empty), every patch is assumed to support it.
+func (p Patch) Supports(version string) bool {
+	return version == "" || len(p.versions) == 0 || matchVersion(p.versions, version)
+}
+
+func matchVersion(patterns []string, version string) bool {
+	for _, pat := range patterns {
+		if ok, _ := path.Match(pat, version); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type versionInst struct {
+	Versions []string
+	Inst     []Instruction
+}
+
+// ForVersion returns an instruction which only applies inst if the
+// [TargetVersion] matches one of the versions, which may be glob patterns. Use
+// it once for each variant of version-specific instructions, and use [Versions]
+// to prevent the patch from being applied to versions without a variant.
+func ForVersion(versions []string, inst ...Instruction) Instruction {
+	if len(versions) == 0 {
+		panic("ForVersion requires at least one version")
+	}
+	for _, x := range inst {
+		if _, ok := x.(Option); ok {
+			panic("ForVersion cannot contain options")
+		}
+	}
+	for _, v := range versions {
+		if _, err := path.Match(v, ""); err != nil {
+			panic(fmt.Sprintf("invalid version pattern %q: %v", v, err))
+		}
+	}
+	return &versionInst{slices.Clone(versions), inst}
+}
+
+func (v *versionInst) String() string {
+	return "ForVersion(" + strings.Join(v.Versions, ", ") + ")"
+}
+
+func (v *versionInst) Do(apk string, diffwriter io.Writer) error {
+	if !matchVersion(v.Versions, TargetVersion) {
+		return nil
+	}
+	var errs []error
+	for i, inst := range v.Inst {
+		if err := inst.Do(apk, diffwriter); err != nil {
+			errs = append(errs, wrapJoined(err, fmt.Sprintf("%s inst %d", TargetVersion, i)))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,65 @@
+package patchdef
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+type testInst struct {
+	Value string `option:"value" help:"test option"`
+	done  int
+}
+
+func (x *testInst) Do(apk string, diffwriter io.Writer) error {
+	x.done++
+	if x.Value == "fail" {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func TestForVersion(t *testing.T) {
+	defer func(v string) { TargetVersion = v }(TargetVersion)
+
+	a, b := &testInst{}, &testInst{}
+	inst := ForVersion([]string{"0.24.*", "0.25.1"}, a, b)
+	for _, tc := range []struct {
+		version string
+		apply   bool
+	}{
+		{"0.24.5", true},
+		{"0.25.1", true},
+		{"0.25.2", false},
+		{"", false},
+	} {
+		a.done, b.done = 0, 0
+		TargetVersion = tc.version
+		if err := inst.Do("", nil); err != nil {
+			t.Errorf("%q: %v", tc.version, err)
+		}
+		if exp := map[bool]int{true: 1}[tc.apply]; a.done != exp || b.done != exp {
+			t.Errorf("%q: expected instructions to be applied %d times, got %d and %d", tc.version, exp, a.done, b.done)
+		}
+	}
+
+	TargetVersion = "0.24.5"
+	a.Value = "fail"
+	if err := inst.Do("", nil); err == nil || b.done == 0 {
+		t.Errorf("expected error after applying all instructions")
+	}
+}
+
+func TestForVersionOptions(t *testing.T) {
+	inst := &testInst{Value: "default"}
+	opts := findOptions(ForVersion([]string{"0.24.5"}, inst))
+	if len(opts) != 1 || opts[0].Name != "value" || opts[0].Inst != inst {
+		t.Fatalf("expected the option of the wrapped instruction, got %v", opts)
+	}
+	if err := opts[0].set([]byte(`"x"`)); err != nil {
+		t.Fatalf("set option: %v", err)
+	}
+	if inst.Value != "x" {
+		t.Errorf("expected the option to be set on the wrapped instruction, got %q", inst.Value)
+	}
+}
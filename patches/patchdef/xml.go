@@ -0,0 +1,576 @@
+package patchdef
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// xmlDoc is a minimal XML document which tracks the position of each element
+// in the source so edits only touch the elements which are changed.
+type xmlDoc struct {
+	src   string
+	root  []*xmlElem
+	edits []xmlEdit
+}
+
+type xmlElem struct {
+	Name   string
+	Attrs  []xmlAttr
+	Parent *xmlElem
+	Child  []*xmlElem
+
+	start, startEnd int // start tag
+	close, closeEnd int // end tag (the same as startEnd if self-closing)
+	selfClosing     bool
+}
+
+type xmlAttr struct {
+	Name string
+	Raw  string // the escaped value
+}
+
+type xmlEdit struct {
+	start, end int
+	text       string
+}
+
+func parseXML(s string) (*xmlDoc, error) {
+	d := &xmlDoc{src: s}
+	var cur *xmlElem
+	for i := 0; i < len(s); {
+		if s[i] != '<' {
+			if j := strings.IndexByte(s[i:], '<'); j != -1 {
+				i += j
+			} else {
+				i = len(s)
+			}
+			continue
+		}
+		switch rest := s[i:]; {
+		case strings.HasPrefix(rest, "<!--"):
+			j := strings.Index(rest, "-->")
+			if j == -1 {
+				return nil, fmt.Errorf("offset %d: unterminated comment", i)
+			}
+			i += j + 3
+		case strings.HasPrefix(rest, "<![CDATA["):
+			j := strings.Index(rest, "]]>")
+			if j == -1 {
+				return nil, fmt.Errorf("offset %d: unterminated cdata", i)
+			}
+			i += j + 3
+		case strings.HasPrefix(rest, "<?"), strings.HasPrefix(rest, "<!"):
+			j := strings.IndexByte(rest, '>')
+			if j == -1 {
+				return nil, fmt.Errorf("offset %d: unterminated directive", i)
+			}
+			i += j + 1
+		case strings.HasPrefix(rest, "</"):
+			j := strings.IndexByte(rest, '>')
+			if j == -1 {
+				return nil, fmt.Errorf("offset %d: unterminated end tag", i)
+			}
+			if name := strings.TrimSpace(rest[2:j]); cur == nil || cur.Name != name {
+				return nil, fmt.Errorf("offset %d: unexpected end tag %q", i, name)
+			}
+			cur.close, cur.closeEnd = i, i+j+1
+			cur = cur.Parent
+			i += j + 1
+		default:
+			e, err := parseXMLTag(s, i)
+			if err != nil {
+				return nil, err
+			}
+			e.Parent = cur
+			if cur == nil {
+				d.root = append(d.root, e)
+			} else {
+				cur.Child = append(cur.Child, e)
+			}
+			if !e.selfClosing {
+				cur = e
+			}
+			i = e.startEnd
+		}
+	}
+	if cur != nil {
+		return nil, fmt.Errorf("unterminated element %q", cur.Name)
+	}
+	return d, nil
+}
+
+func parseXMLTag(s string, i int) (*xmlElem, error) {
+	e := &xmlElem{start: i}
+	j := i + 1
+	for j < len(s) && !strings.ContainsRune(" \t\r\n/>", rune(s[j])) {
+		j++
+	}
+	if e.Name = s[i+1 : j]; e.Name == "" {
+		return nil, fmt.Errorf("offset %d: invalid start tag", i)
+	}
+	for {
+		for j < len(s) && strings.ContainsRune(" \t\r\n", rune(s[j])) {
+			j++
+		}
+		if j >= len(s) {
+			return nil, fmt.Errorf("offset %d: unterminated start tag", i)
+		}
+		if s[j] == '>' {
+			e.startEnd = j + 1
+			return e, nil
+		}
+		if strings.HasPrefix(s[j:], "/>") {
+			e.selfClosing = true
+			e.startEnd = j + 2
+			e.close, e.closeEnd = e.startEnd, e.startEnd
+			return e, nil
+		}
+		k := strings.IndexByte(s[j:], '=')
+		if k == -1 || j+k+1 >= len(s) {
+			return nil, fmt.Errorf("offset %d: invalid attribute", j)
+		}
+		name := strings.TrimSpace(s[j : j+k])
+		j += k + 1
+		for j < len(s) && strings.ContainsRune(" \t\r\n", rune(s[j])) {
+			j++
+		}
+		if j >= len(s) || (s[j] != '"' && s[j] != '\'') {
+			return nil, fmt.Errorf("offset %d: unquoted attribute value", j)
+		}
+		q := strings.IndexByte(s[j+1:], s[j])
+		if q == -1 {
+			return nil, fmt.Errorf("offset %d: unterminated attribute value", j)
+		}
+		e.Attrs = append(e.Attrs, xmlAttr{name, s[j+1 : j+1+q]})
+		j += q + 2
+	}
+}
+
+// Attr gets the unescaped value of an attribute.
+func (e *xmlElem) Attr(name string) (string, bool) {
+	for _, a := range e.Attrs {
+		if a.Name == name {
+			return xmlUnescape(a.Raw), true
+		}
+	}
+	return "", false
+}
+
+// SetAttr sets or adds an attribute.
+func (e *xmlElem) SetAttr(name, value string) {
+	for i, a := range e.Attrs {
+		if a.Name == name {
+			e.Attrs[i].Raw = xmlEscape(value)
+			return
+		}
+	}
+	e.Attrs = append(e.Attrs, xmlAttr{name, xmlEscape(value)})
+}
+
+// startTag formats the start tag in apktool's style.
+func (e *xmlElem) startTag() string {
+	var b strings.Builder
+	b.WriteString("<")
+	b.WriteString(e.Name)
+	for _, a := range e.Attrs {
+		b.WriteString(" ")
+		b.WriteString(a.Name)
+		b.WriteString(`="`)
+		b.WriteString(a.Raw)
+		b.WriteString(`"`)
+	}
+	if e.selfClosing {
+		b.WriteString(" />")
+	} else {
+		b.WriteString(">")
+	}
+	return b.String()
+}
+
+// indent gets the indentation of the line the element starts on.
+func (d *xmlDoc) indent(e *xmlElem) string {
+	ls := strings.LastIndexByte(d.src[:e.start], '\n') + 1
+	return d.src[ls : ls+len(d.src[ls:e.start])-len(strings.TrimLeft(d.src[ls:e.start], " \t"))]
+}
+
+func (d *xmlDoc) edit(start, end int, text string) {
+	d.edits = append(d.edits, xmlEdit{start, end, text})
+}
+
+// updateStartTag replaces the start tag of the element with the current name
+// and attributes.
+func (d *xmlDoc) updateStartTag(e *xmlElem) {
+	d.edit(e.start, e.startEnd, e.startTag())
+}
+
+func (d *xmlDoc) String() (string, error) {
+	es := slices.Clone(d.edits)
+	slices.SortStableFunc(es, func(a, b xmlEdit) int {
+		return a.start - b.start
+	})
+	var b strings.Builder
+	var pos int
+	for _, e := range es {
+		if e.start < pos {
+			return "", errors.New("overlapping xml edits")
+		}
+		b.WriteString(d.src[pos:e.start])
+		b.WriteString(e.text)
+		pos = e.end
+	}
+	b.WriteString(d.src[pos:])
+	return b.String(), nil
+}
+
+func (d *xmlDoc) walk(fn func(e *xmlElem)) {
+	var walk func(es []*xmlElem)
+	walk = func(es []*xmlElem) {
+		for _, e := range es {
+			fn(e)
+			walk(e.Child)
+		}
+	}
+	walk(d.root)
+}
+
+// Select gets all elements matching a selector.
+func (d *xmlDoc) Select(sel string) ([]*xmlElem, error) {
+	s, err := parseXMLSelector(sel)
+	if err != nil {
+		return nil, err
+	}
+	var es []*xmlElem
+	d.walk(func(e *xmlElem) {
+		if s.match(e) {
+			es = append(es, e)
+		}
+	})
+	return es, nil
+}
+
+// xmlSelector is a simple CSS-like selector. Each compound selector is a tag
+// name (or *), optionally followed by any number of [attr] or [attr=value]
+// conditions. Compound selectors can be combined with whitespace (descendant)
+// or > (child).
+type xmlSelector struct {
+	compound []xmlCompound
+	child    []bool // whether compound[i+1] must be a direct child of compound[i]
+}
+
+type xmlCompound struct {
+	tag   string
+	attrs [][2]string // name, value
+	has   []bool      // whether a value is specified (otherwise, only check existence)
+}
+
+func parseXMLSelector(sel string) (*xmlSelector, error) {
+	var (
+		s     xmlSelector
+		child bool
+	)
+	for i := 0; i < len(sel); {
+		switch c := sel[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '>':
+			if len(s.compound) == 0 || child {
+				return nil, fmt.Errorf("invalid selector %q: unexpected >", sel)
+			}
+			child = true
+			i++
+		default:
+			var x xmlCompound
+			j := i
+			for j < len(sel) && !strings.ContainsRune(" \t\n>[", rune(sel[j])) {
+				j++
+			}
+			x.tag = sel[i:j]
+			for j < len(sel) && sel[j] == '[' {
+				k := strings.IndexByte(sel[j:], ']')
+				if k == -1 {
+					return nil, fmt.Errorf("invalid selector %q: unterminated [", sel)
+				}
+				name, value, ok := strings.Cut(sel[j+1:j+k], "=")
+				if name = strings.TrimSpace(name); name == "" {
+					return nil, fmt.Errorf("invalid selector %q: missing attribute name", sel)
+				}
+				if value = strings.TrimSpace(value); len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+					value = value[1 : len(value)-1]
+				}
+				x.attrs = append(x.attrs, [2]string{name, value})
+				x.has = append(x.has, ok)
+				j += k + 1
+			}
+			if x.tag == "" && len(x.attrs) == 0 {
+				return nil, fmt.Errorf("invalid selector %q: unexpected %q", sel, sel[j])
+			}
+			if len(s.compound) != 0 {
+				s.child = append(s.child, child)
+			}
+			s.compound = append(s.compound, x)
+			child = false
+			i = j
+		}
+	}
+	if len(s.compound) == 0 || child {
+		return nil, fmt.Errorf("invalid selector %q", sel)
+	}
+	return &s, nil
+}
+
+func (s *xmlSelector) match(e *xmlElem) bool {
+	return s.matchAt(e, len(s.compound)-1)
+}
+
+func (s *xmlSelector) matchAt(e *xmlElem, i int) bool {
+	if !s.compound[i].match(e) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	if s.child[i-1] {
+		return e.Parent != nil && s.matchAt(e.Parent, i-1)
+	}
+	for p := e.Parent; p != nil; p = p.Parent {
+		if s.matchAt(p, i-1) {
+			return true
+		}
+	}
+	return false
+}
+
+func (x xmlCompound) match(e *xmlElem) bool {
+	if x.tag != "" && x.tag != "*" && x.tag != e.Name {
+		return false
+	}
+	for i, a := range x.attrs {
+		if v, ok := e.Attr(a[0]); !ok || (x.has[i] && v != a[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// editXML parses the XML, then calls fn to modify it.
+func editXML(fn func(d *xmlDoc) error) StringPatcher {
+	return StringPatcherFunc(func(s string) (string, error) {
+		d, err := parseXML(s)
+		if err != nil {
+			return s, fmt.Errorf("parse xml: %w", err)
+		}
+		if err := fn(d); err != nil {
+			return s, err
+		}
+		return d.String()
+	})
+}
+
+// selectXML is like Select, but returns an error if nothing matches.
+func (d *xmlDoc) selectXML(sel string) ([]*xmlElem, error) {
+	es, err := d.Select(sel)
+	if err != nil {
+		return nil, err
+	}
+	if len(es) == 0 {
+		return nil, fmt.Errorf("could not find element matching %q", sel)
+	}
+	return es, nil
+}
+
+// selectOneXML is like Select, but returns an error unless exactly one element
+// matches.
+func (d *xmlDoc) selectOneXML(sel string) (*xmlElem, error) {
+	es, err := d.selectXML(sel)
+	if err != nil {
+		return nil, err
+	}
+	if len(es) != 1 {
+		return nil, fmt.Errorf("expected exactly one element matching %q, found %d", sel, len(es))
+	}
+	return es[0], nil
+}
+
+// SetAttr sets (or adds) an attribute on every element matching the selector
+// (e.g., item[android:title="@string/action_search"]). If any old values are
+// specified, the attribute must already be set to one of them, so changes in
+// new versions of the app are detected.
+func SetAttr(sel, attr, value string, old ...string) StringPatcher {
+	return editXML(func(d *xmlDoc) error {
+		es, err := d.selectXML(sel)
+		if err != nil {
+			return err
+		}
+		for _, e := range es {
+			if len(old) != 0 {
+				if cur, ok := e.Attr(attr); !ok {
+					return fmt.Errorf("set %s of %q: expected %s, found none", attr, sel, xmlExpected(old))
+				} else if !slices.Contains(old, cur) {
+					return fmt.Errorf("set %s of %q: expected %s, found %q", attr, sel, xmlExpected(old), cur)
+				}
+			}
+			e.SetAttr(attr, value)
+			d.updateStartTag(e)
+		}
+		return nil
+	})
+}
+
+// SetText replaces the text content of every element matching the selector.
+// The elements must not have any child elements. If any old values are
+// specified, the text must currently be one of them, so changes in new
+// versions of the app are detected.
+func SetText(sel, text string, old ...string) StringPatcher {
+	return editXML(func(d *xmlDoc) error {
+		es, err := d.selectXML(sel)
+		if err != nil {
+			return err
+		}
+		for _, e := range es {
+			if len(e.Child) != 0 {
+				return fmt.Errorf("set text of %q: element has children", sel)
+			}
+			if len(old) != 0 {
+				var cur string
+				if !e.selfClosing {
+					cur = xmlUnescape(d.src[e.startEnd:e.close])
+				}
+				if !slices.Contains(old, cur) {
+					return fmt.Errorf("set text of %q: expected %s, found %q", sel, xmlExpected(old), cur)
+				}
+			}
+			if e.selfClosing {
+				e.selfClosing = false
+				d.edit(e.start, e.startEnd, e.startTag()+xmlEscape(text)+"</"+e.Name+">")
+			} else {
+				d.edit(e.startEnd, e.close, xmlEscape(text))
+			}
+		}
+		return nil
+	})
+}
+
+// InsertAfter inserts XML after the element matching the selector, which must
+// be unique. The XML is re-indented to match the element.
+func InsertAfter(sel, xml string) StringPatcher {
+	return editXML(func(d *xmlDoc) error {
+		e, err := d.selectOneXML(sel)
+		if err != nil {
+			return err
+		}
+		xml, err := formatXML(xml)
+		if err != nil {
+			return fmt.Errorf("insert after %q: %w", sel, err)
+		}
+		d.edit(e.closeEnd, e.closeEnd, "\n"+xmlLines(xml, d.indent(e)))
+		return nil
+	})
+}
+
+// InsertAfterID inserts XML after the element with the specified android:id
+// (e.g., @id/settings).
+func InsertAfterID(id, xml string) StringPatcher {
+	return InsertAfter(`[android:id="`+id+`"]`, xml)
+}
+
+// AddValue adds a value (e.g., string, dimen, color) to a resource values
+// file. It is an error if a value with the same type and name already exists.
+// Ids are added as an empty item like apktool does, so value must be empty.
+func AddValue(typ, name, value string) StringPatcher {
+	return editXML(func(d *xmlDoc) error {
+		r, err := d.selectOneXML(`resources`)
+		if err != nil {
+			return err
+		}
+		for _, e := range r.Child {
+			if v, _ := e.Attr("name"); v == name && (e.Name == typ || e.Name == "item" && slices.ContainsFunc(e.Attrs, func(a xmlAttr) bool {
+				return a.Name == "type" && xmlUnescape(a.Raw) == typ
+			})) {
+				return fmt.Errorf("add value: %s %q already exists", typ, name)
+			}
+		}
+		if r.selfClosing {
+			return errors.New("add value: resources element is empty")
+		}
+		// put it on a new line before the end tag like apktool does
+		if typ == "id" {
+			if value != "" {
+				return fmt.Errorf("add value: id %q must not have a value", name)
+			}
+			d.edit(r.close, r.close, d.indent(r)+`    <item type="id" name="`+xmlEscape(name)+`" />`+"\n")
+			return nil
+		}
+		d.edit(r.close, r.close, d.indent(r)+"    <"+typ+` name="`+xmlEscape(name)+`">`+xmlEscape(value)+"</"+typ+">\n")
+		return nil
+	})
+}
+
+// AddPreference adds a preference to the start of the PreferenceCategory with
+// the specified android:key or android:title.
+func AddPreference(category, xml string) StringPatcher {
+	return editXML(func(d *xmlDoc) error {
+		es, err := d.Select(`PreferenceCategory[android:key="` + category + `"]`)
+		if err != nil {
+			return err
+		}
+		if len(es) == 0 {
+			if es, err = d.Select(`PreferenceCategory[android:title="` + category + `"]`); err != nil {
+				return err
+			}
+		}
+		if len(es) != 1 {
+			return fmt.Errorf("add preference: expected exactly one category %q, found %d", category, len(es))
+		}
+		xml, err := formatXML(xml)
+		if err != nil {
+			return fmt.Errorf("add preference: %w", err)
+		}
+		c := es[0]
+		if c.selfClosing {
+			return fmt.Errorf("add preference: category %q is empty", category)
+		}
+		d.edit(c.startEnd, c.startEnd, "\n"+xmlLines(xml, d.indent(c)+"    "))
+		return nil
+	})
+}
+
+// xmlExpected formats the expected old values for errors.
+func xmlExpected(old []string) string {
+	qs := make([]string, len(old))
+	for i, x := range old {
+		qs[i] = strconv.Quote(x)
+	}
+	return strings.Join(qs, " or ")
+}
+
+// formatXML re-formats the start tags in xml in apktool's style.
+func formatXML(xml string) (string, error) {
+	d, err := parseXML(xml)
+	if err != nil {
+		return "", err
+	}
+	d.walk(d.updateStartTag)
+	return d.String()
+}
+
+// xmlLines removes the common indentation from xml, converts tabs to 4 spaces,
+// and indents it with indent. There is no trailing newline.
+func xmlLines(xml, indent string) string {
+	return strings.Join(reindentLines(xml, indent), "\n")
+}
+
+var (
+	xmlEscaper   = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+	xmlUnescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'")
+)
+
+func xmlEscape(s string) string {
+	return xmlEscaper.Replace(s)
+}
+
+func xmlUnescape(s string) string {
+	return xmlUnescaper.Replace(s)
+}
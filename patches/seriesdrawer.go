@@ -5,14 +5,13 @@ package patches
 
 import . "github.com/pgaskin/lithiumpatch/patches/patchdef"
 
-func seriesdrawer() {
+func init() {
 	const filterId = "3" // one more than loadFolder
 	Register("seriesdrawer",
+		Requires("seriesmeta"),
+		After("seriesmeta"),
 		PatchFile("res/xml/preferences.xml",
-			ReplaceStringAppend(
-				"\n"+`    <PreferenceCategory android:title="@string/pref_category_advanced">`,
-				"\n"+`        <SwitchPreferenceCompat android:title="Show series in drawer" android:key="series_in_drawer" android:defaultValue="false" />`,
-			),
+			AddPreference(`@string/pref_category_advanced`, `<SwitchPreferenceCompat android:title="Show series in drawer" android:key="series_in_drawer" android:defaultValue="false" />`),
 		),
 
 		// based on empty_category
@@ -24,7 +23,6 @@ func seriesdrawer() {
 			<Button android:id="@id/empty_goto_all" android:paddingLeft="24.0dip" android:paddingRight="24.0dip" android:layout_width="wrap_content" android:layout_height="wrap_content" android:layout_marginTop="24.0dip" android:text="@string/no_books_goto_all" />
 		</LinearLayout>
 		`)),
-		DefineR("smali/com/faultexception/reader", "layout", "empty_series"),
 
 		PatchFile("smali/com/faultexception/reader/BooksFragment.smali",
 			ReplaceStringAppend(
@@ -280,7 +278,6 @@ func seriesdrawer() {
 			</vector>
 			`),
 		),
-		DefineR("smali/com/faultexception/reader", "drawable", "ic_drawer_collection"),
 
 		// based on FoldersAdapter, CategoriesAdapter
 		WriteFileString("smali/com/faultexception/reader/MainDrawerFragment$SeriesAdapter.smali", FixIndent(`
@@ -386,7 +383,6 @@ func seriesdrawer() {
 			<TextView android:textSize="14sp" android:textColor="?android:attr/textColorSecondary" android:gravity="center_vertical" android:paddingLeft="16dp" android:paddingRight="16dp" android:layout_width="match_parent" android:layout_height="48dp" android:text="Series" android:fontFamily="sans-serif-medium"/>
 		</LinearLayout>
 		`)),
-		DefineR("smali/com/faultexception/reader", "layout", "series_list_header"),
 
 		PatchFile("smali/com/faultexception/reader/MainDrawerFragment.smali",
 			ReplaceStringPrepend(
@@ -0,0 +1,297 @@
+// Package patchgen generates patch definitions from the changes made to a
+// decompiled APK.
+package patchgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxInline is the maximum size of a text file to write inline rather than
+// embedding it.
+const maxInline = 16 * 1024
+
+// Options configures the generated patch.
+type Options struct {
+	Package  string   // Go package name (default: patches)
+	Title    string   // documentation title (default: the patch name)
+	Versions []string // if not empty, restrict the patch to these versions
+}
+
+// Result is a generated patch.
+type Result struct {
+	Source []byte            // formatted Go source
+	Assets map[string][]byte // embedded files, relative to the source file
+	Files  []string          // the changed files in the decompiled APK
+}
+
+// Generate generates a Go source file registering a patch which makes the
+// changes between the original and edited decompiled APKs. Text files are
+// patched using the smallest unique anchors (relative to a smali method if
+// possible), new and binary files are written, and removed files are deleted.
+func Generate(name string, orig, edited fs.FS, opt Options) (*Result, error) {
+	if opt.Package == "" {
+		opt.Package = "patches"
+	}
+	if opt.Title == "" {
+		opt.Title = name
+	}
+
+	a, err := readTree(orig)
+	if err != nil {
+		return nil, fmt.Errorf("read original tree: %w", err)
+	}
+	b, err := readTree(edited)
+	if err != nil {
+		return nil, fmt.Errorf("read edited tree: %w", err)
+	}
+
+	g := &generator{
+		name:   name,
+		assets: map[string][]byte{},
+		vars:   map[string]bool{},
+	}
+	r := &Result{Assets: g.assets}
+	for _, p := range slices.Sorted(maps.Keys(mergeKeys(a, b))) {
+		x, inA := a[p]
+		y, inB := b[p]
+		switch {
+		case !inB:
+			g.printf("\t\tDeleteFile(%s),\n", g.quote(p))
+		case !inA:
+			g.writeFile(p, y)
+		case !bytes.Equal(x, y):
+			g.patchFile(p, x, y)
+		default:
+			continue
+		}
+		r.Files = append(r.Files, p)
+	}
+	if len(r.Files) == 0 {
+		return nil, fmt.Errorf("no changes")
+	}
+
+	var s bytes.Buffer
+	fmt.Fprintf(&s, "// # %s\n//\n// TODO: describe the patch.\npackage %s\n\n", opt.Title, opt.Package)
+	if len(g.embeds) != 0 {
+		fmt.Fprintf(&s, "import (\n\t_ \"embed\"\n\n\t. \"github.com/pgaskin/lithiumpatch/patches/patchdef\"\n)\n\n")
+		s.Write(g.embeds)
+		s.WriteString("\n")
+	} else {
+		fmt.Fprintf(&s, "import . \"github.com/pgaskin/lithiumpatch/patches/patchdef\"\n\n")
+	}
+	fmt.Fprintf(&s, "func init() {\n\tRegister(%s,\n", strconv.Quote(name))
+	if len(opt.Versions) != 0 {
+		var vs []string
+		for _, v := range opt.Versions {
+			vs = append(vs, strconv.Quote(v))
+		}
+		fmt.Fprintf(&s, "\t\tVersions(%s),\n", strings.Join(vs, ", "))
+	}
+	s.Write(g.body.Bytes())
+	s.WriteString("\t)\n}\n")
+
+	if r.Source, err = format.Source(s.Bytes()); err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return r, nil
+}
+
+type generator struct {
+	name   string
+	body   bytes.Buffer
+	embeds []byte
+	assets map[string][]byte
+	vars   map[string]bool
+}
+
+func (g *generator) printf(format string, a ...any) {
+	fmt.Fprintf(&g.body, format, a...)
+}
+
+// writeFile writes a file inline if it is small text, or embeds it otherwise.
+func (g *generator) writeFile(p string, buf []byte) {
+	if isText(buf) && len(buf) <= maxInline {
+		g.printf("\t\tWriteFileString(%s, %s),\n", g.quote(p), g.lit(string(buf), 2))
+		return
+	}
+	asset := g.name + "_files/" + p
+	v := g.varName(p)
+	g.assets[asset] = buf
+	g.embeds = fmt.Appendf(g.embeds, "//go:embed %s\nvar %s []byte\n", asset, v)
+	g.printf("\t\tWriteFile(%s, %s),\n", g.quote(p), v)
+}
+
+// patchFile patches a text file, or replaces it if it is binary or the changes
+// could not be anchored.
+func (g *generator) patchFile(p string, a, b []byte) {
+	if !isText(a) || !isText(b) {
+		g.writeFile(p, b)
+		return
+	}
+	cs, ok := diffText(p, string(a), string(b), path.Ext(p) == ".smali")
+	if !ok {
+		g.printf("\t\t// note: could not find unique anchors for the changes, so the file is replaced\n")
+		g.writeFile(p, b)
+		return
+	}
+	g.printf("\t\tPatchFile(%s,\n", g.quote(p))
+	for i := 0; i < len(cs); {
+		if m := cs[i].Method; m != "" {
+			g.printf("\t\t\tInMethod(%s,\n", g.quote(m))
+			for ; i < len(cs) && cs[i].Method == m; i++ {
+				g.change(cs[i], 4)
+			}
+			g.printf("\t\t\t),\n")
+		} else {
+			g.change(cs[i], 3)
+			i++
+		}
+	}
+	g.printf("\t\t),\n")
+}
+
+// change writes a StringPatcher at the specified indentation.
+func (g *generator) change(c change, depth int) {
+	fn := "ReplaceString"
+	switch c.Kind {
+	case appendTo:
+		fn = "ReplaceStringAppend"
+	case prependTo:
+		fn = "ReplaceStringPrepend"
+	}
+	ind := strings.Repeat("\t", depth)
+	g.printf("%s%s(\n", ind, fn)
+	g.printf("%s\t%s,\n", ind, g.lit(c.Find, depth+1))
+	g.printf("%s\t%s,\n", ind, g.lit(c.Repl, depth+1))
+	g.printf("%s),\n", ind)
+}
+
+// quote quotes a short string, preferring a raw string literal.
+func (g *generator) quote(s string) string {
+	if strconv.CanBackquote(s) {
+		return "`" + s + "`"
+	}
+	return strconv.Quote(s)
+}
+
+// lit formats a string as an expression starting on a line with the specified
+// indentation. Lines are formatted using FixIndent if possible.
+func (g *generator) lit(s string, depth int) string {
+	switch s {
+	case "":
+		return `""`
+	case "\n":
+		return `"\n"`
+	}
+	var nl string
+	if r, ok := strings.CutPrefix(s, "\n"); ok && r != "" {
+		nl, s = `"\n"+`, r
+	}
+	if !canFixIndent(s) {
+		if nl != "" {
+			s = "\n" + s
+		}
+		var b strings.Builder
+		for i, l := range splitLines(s) {
+			if i != 0 {
+				b.WriteString(" +\n" + strings.Repeat("\t", depth+1))
+			}
+			b.WriteString(strconv.Quote(l))
+		}
+		return b.String()
+	}
+	var b strings.Builder
+	b.WriteString("FixIndent(" + nl + "`\n")
+	for _, l := range splitLines(s) {
+		l = strings.TrimSuffix(l, "\n")
+		if l != "" {
+			t := strings.TrimLeft(l, " ")
+			b.WriteString(strings.Repeat("\t", depth+(len(l)-len(t))/4))
+			b.WriteString(strings.Repeat(" ", (len(l)-len(t))%4))
+			b.WriteString(t)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(strings.Repeat("\t", depth) + "`)")
+	return b.String()
+}
+
+// canFixIndent checks if s can be written as an indented raw string literal
+// passed to FixIndent.
+func canFixIndent(s string) bool {
+	if s == "" || !strings.HasSuffix(s, "\n") || strings.ContainsAny(s, "`\r") {
+		return false
+	}
+	for _, l := range splitLines(s) {
+		l = strings.TrimSuffix(l, "\n")
+		t := strings.TrimLeft(l, " ")
+		if strings.HasPrefix(t, "\t") || (l != "" && strings.TrimSpace(l) == "") {
+			return false // FixIndent converts leading tabs and removes whitespace-only lines
+		}
+	}
+	return true
+}
+
+// varName gets a unique variable name for an embedded file.
+func (g *generator) varName(p string) string {
+	var b strings.Builder
+	up := true
+	for _, r := range g.name + "_" + path.Base(p) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			up = true
+			continue
+		}
+		if up && b.Len() != 0 {
+			r = unicode.ToUpper(r)
+		}
+		b.WriteRune(r)
+		up = false
+	}
+	v := b.String()
+	for i := 2; g.vars[v]; i++ {
+		v = b.String() + strconv.Itoa(i)
+	}
+	g.vars[v] = true
+	return v
+}
+
+func isText(buf []byte) bool {
+	return utf8.Valid(buf) && bytes.IndexByte(buf, 0) == -1
+}
+
+// readTree reads all regular files in fsys.
+func readTree(fsys fs.FS) (map[string][]byte, error) {
+	m := map[string][]byte{}
+	return m, fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		buf, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		m[p] = buf
+		return nil
+	})
+}
+
+func mergeKeys(a, b map[string][]byte) map[string]bool {
+	m := map[string]bool{}
+	for k := range a {
+		m[k] = true
+	}
+	for k := range b {
+		m[k] = true
+	}
+	return m
+}
@@ -0,0 +1,226 @@
+package patchgen
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+	"github.com/pgaskin/lithiumpatch/patches/patchdef"
+)
+
+// maxContext is the maximum number of lines of context to use to make an
+// anchor unique.
+const maxContext = 32
+
+// mergeGap is the maximum number of unchanged lines between two changes for
+// them to be combined.
+const mergeGap = 2
+
+// replaceKind is the StringPatcher used for a change.
+type replaceKind int
+
+const (
+	replace   replaceKind = iota // ReplaceString(find, repl)
+	appendTo                     // ReplaceStringAppend(find, repl)
+	prependTo                    // ReplaceStringPrepend(find, repl)
+)
+
+// change is a single string replacement, optionally in a smali method.
+type change struct {
+	Method string // InMethod, if not empty
+	Kind   replaceKind
+	Find   string
+	Repl   string
+}
+
+// patcher gets the StringPatcher for the change.
+func (c change) patcher() patchdef.StringPatcher {
+	var pt patchdef.StringPatcher
+	switch c.Kind {
+	case appendTo:
+		pt = patchdef.ReplaceStringAppend(c.Find, c.Repl)
+	case prependTo:
+		pt = patchdef.ReplaceStringPrepend(c.Find, c.Repl)
+	default:
+		pt = patchdef.ReplaceString(c.Find, c.Repl)
+	}
+	if c.Method != "" {
+		pt = patchdef.InMethod(c.Method, pt)
+	}
+	return pt
+}
+
+// hunk replaces lines [I1, I2) with New.
+type hunk struct {
+	I1, I2 int
+	New    []string
+}
+
+// diffText gets the changes to transform a into b. The changes are anchored on
+// as few lines as possible, and are checked by applying them to a. If smali is
+// true, changes inside a single method are made relative to the method. It
+// returns false if a is empty or if unique anchors could not be found.
+func diffText(name, a, b string, smali bool) ([]change, bool) {
+	if a == "" {
+		return nil, false
+	}
+	var (
+		cur = splitLines(a)
+		cs  []change
+		off int
+	)
+	for _, h := range hunks(name, a, b) {
+		i, k := h.I1+off, h.I2+off
+		c, ok := anchor(cur, i, k, h.New, smali)
+		if !ok {
+			return nil, false
+		}
+		cs = append(cs, c)
+		cur = slices.Concat(cur[:i], h.New, cur[k:])
+		off += len(h.New) - (h.I2 - h.I1)
+	}
+
+	// make sure it actually works
+	s := a
+	for _, c := range cs {
+		var err error
+		if s, err = c.patcher().PatchString(s); err != nil {
+			return nil, false
+		}
+	}
+	if s != b {
+		return nil, false
+	}
+	return cs, true
+}
+
+// hunks gets the changed lines between a and b, combining changes which are
+// close together.
+func hunks(name, a, b string) []hunk {
+	var (
+		lines = splitLines(a)
+		hs    []hunk
+	)
+	for _, e := range myers.ComputeEdits(span.URIFromPath(name), a, b) {
+		h := hunk{
+			I1:  e.Span.Start().Line() - 1,
+			I2:  e.Span.End().Line() - 1,
+			New: splitLines(e.NewText),
+		}
+		if n := len(hs); n != 0 && h.I1-hs[n-1].I2 <= mergeGap {
+			p := &hs[n-1]
+			p.New = slices.Concat(p.New, lines[p.I2:h.I1], h.New)
+			p.I2 = h.I2
+			continue
+		}
+		hs = append(hs, h)
+	}
+	return hs
+}
+
+// anchor finds the smallest unique string containing lines [i, k) in lines (or
+// the lines surrounding i if i == k), and returns a change replacing them with
+// repl.
+func anchor(lines []string, i, k int, repl []string, smali bool) (change, bool) {
+	if smali {
+		if m, s, e, ok := findMethod(lines, i, k); ok && !slices.ContainsFunc(repl, isMethodBoundary) {
+			// InMethod patches every method with the name, and replaces the
+			// first occurrence of the method body
+			body := strings.Join(lines[s:e], "")
+			if countMethod(lines, m) == 1 && strings.Count(strings.Join(lines, ""), body) == 1 {
+				if c, ok := anchorIn(lines, s, e, i, k, repl); ok {
+					c.Method = m
+					return c, true
+				}
+			}
+		}
+	}
+	return anchorIn(lines, 0, len(lines), i, k, repl)
+}
+
+// anchorIn is like anchor, but only uses and matches lines [s, e).
+func anchorIn(lines []string, s, e, i, k int, repl []string) (change, bool) {
+	var (
+		scope = strings.Join(lines[s:e], "")
+		old   = strings.Join(lines[i:k], "")
+		rep   = strings.Join(repl, "")
+	)
+	for n := range maxContext + 1 {
+		for before := n; before >= 0; before-- {
+			after := n - before
+			if i-before < s || k+after > e {
+				continue
+			}
+			if old == "" && before == 0 && after == 0 {
+				continue
+			}
+			var (
+				pre  = strings.Join(lines[i-before:i], "")
+				post = strings.Join(lines[k:k+after], "")
+				nl   string
+			)
+			// anchor it to the start of a line if possible, except when
+			// prepending since the newline would be before the new lines
+			if i-before > s && !(old == "" && before == 0) {
+				nl = "\n"
+			}
+			find := nl + pre + old + post
+			if strings.Count(scope, find) != 1 {
+				continue
+			}
+			switch {
+			case old == "" && after == 0:
+				return change{Kind: appendTo, Find: nl + pre, Repl: rep}, true
+			case old == "" && before == 0:
+				return change{Kind: prependTo, Find: post, Repl: rep}, true
+			default:
+				return change{Kind: replace, Find: find, Repl: nl + pre + rep + post}, true
+			}
+		}
+	}
+	return change{}, false
+}
+
+// findMethod finds the smali method whose body contains the changed lines
+// [i, k), returning the method name and the body lines [s, e).
+func findMethod(lines []string, i, k int) (name string, s, e int, ok bool) {
+	for n, l := range lines {
+		f := strings.Fields(l)
+		switch {
+		case len(f) >= 1 && f[0] == ".method":
+			name, s = f[len(f)-1], n+1
+		case len(f) >= 2 && f[0] == ".end" && f[1] == "method":
+			if name != "" && s <= i && k <= n {
+				return name, s, n, true
+			}
+			name = ""
+		}
+	}
+	return "", 0, 0, false
+}
+
+// countMethod counts the smali methods with the specified name.
+func countMethod(lines []string, name string) int {
+	var n int
+	for _, l := range lines {
+		if f := strings.Fields(l); len(f) >= 1 && f[0] == ".method" && f[len(f)-1] == name {
+			n++
+		}
+	}
+	return n
+}
+
+func isMethodBoundary(l string) bool {
+	f := strings.Fields(l)
+	return (len(f) >= 1 && f[0] == ".method") || (len(f) >= 2 && f[0] == ".end" && f[1] == "method")
+}
+
+// splitLines splits s into lines, keeping the line endings.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pgaskin/lithiumpatch/apksign"
+	"github.com/pgaskin/lithiumpatch/dict"
+	"github.com/pgaskin/lithiumpatch/fonts"
+)
+
+// provenanceSchema is the version of the provenance.json schema. Fields may be
+// added without changing it, but it must be incremented if a field is removed
+// or changes meaning.
+const provenanceSchema = 1
+
+// provenance describes how a patched APK in an output bundle was built.
+type provenance struct {
+	Schema       int              `json:"schema"`
+	Tool         string           `json:"tool"` // lithiumpatch version
+	Input        provenanceInput  `json:"input"`
+	Output       provenanceFile   `json:"output"`         // the APK (or .apks file if --keep-splits was used) in the bundle
+	APKs         []provenanceFile `json:"apks,omitempty"` // the APKs in the .apks file
+	Diff         string           `json:"diff"`           // the diff in the bundle
+	Patches      []string         `json:"patches"`        // applied patches, in order
+	Skipped      []string         `json:"skipped"`        // optional patches which failed to apply
+	Fonts        []provenanceFont `json:"fonts"`          // fonts added to the APK (if extrafonts was applied)
+	Dictionaries []provenanceDict `json:"dictionaries"`   // dictionaries added to the APK (if dictionary was applied)
+	Certificate  provenanceCert   `json:"certificate"`    // signing certificate
+}
+
+type provenanceInput struct {
+	Name    string   `json:"name"`    // input filename
+	Version string   `json:"version"` // Lithium versionName
+	SHA256  string   `json:"sha256"`  // the APK which was patched (after merging splits)
+	Splits  []string `json:"splits"`  // config splits in the input
+}
+
+type provenanceFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type provenanceFont struct {
+	Name   string   `json:"name"`
+	Base   string   `json:"base"`
+	Script string   `json:"script"`
+	Styles []string `json:"styles"`
+}
+
+type provenanceDict struct {
+	Name    string `json:"name"`
+	Terms   int    `json:"terms"`
+	Entries int    `json:"entries"`
+}
+
+type provenanceCert struct {
+	Subject string `json:"subject"`
+	SHA1    string `json:"sha1"`
+	SHA256  string `json:"sha256"`
+}
+
+// newProvenance describes the current build. The output fields are filled in
+// by writeOutputBundle.
+func newProvenance(input, version, apkHash string, splits []string, applied, skipped []string, key *apksign.Key) provenance {
+	p := provenance{
+		Schema: provenanceSchema,
+		Tool:   toolVersion(),
+		Input: provenanceInput{
+			Name:    filepath.Base(input),
+			Version: version,
+			SHA256:  apkHash,
+			Splits:  append([]string{}, splits...),
+		},
+		Patches:      append([]string{}, applied...),
+		Skipped:      append([]string{}, skipped...),
+		Fonts:        []provenanceFont{},
+		Dictionaries: []provenanceDict{},
+		Certificate: provenanceCert{
+			Subject: key.Certificate.Subject.String(),
+			SHA1:    key.Fingerprint(),
+			SHA256:  key.FingerprintSHA256(),
+		},
+	}
+	if slices.Contains(applied, "extrafonts") {
+		for _, f := range fonts.All() {
+			pf := provenanceFont{
+				Name:   f.Name,
+				Base:   f.Base,
+				Script: f.Script.String(),
+				Styles: []string{},
+			}
+			for _, s := range []struct {
+				name string
+				data []byte
+			}{
+				{"Regular", f.Regular},
+				{"Bold", f.Bold},
+				{"Italic", f.Italic},
+				{"BoldItalic", f.BoldItalic},
+			} {
+				if s.data != nil {
+					pf.Styles = append(pf.Styles, s.name)
+				}
+			}
+			p.Fonts = append(p.Fonts, pf)
+		}
+	}
+	if slices.Contains(applied, "dictionary") {
+		for _, d := range dict.Dicts() {
+			if terms, entries, ok := dict.Size(d); ok {
+				p.Dictionaries = append(p.Dictionaries, provenanceDict{d, terms, entries})
+			}
+		}
+	}
+	return p
+}
+
+// describeAPKs describes the APKs in an .apks file.
+func describeAPKs(buf []byte) ([]provenanceFile, error) {
+	zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		return nil, err
+	}
+	var fs []provenanceFile
+	for _, zf := range zr.File {
+		if !strings.EqualFold(path.Ext(zf.Name), ".apk") {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", zf.Name, err)
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", zf.Name, err)
+		}
+		fs = append(fs, provenanceFile{
+			Name:   zf.Name,
+			Size:   n,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		})
+	}
+	return fs, nil
+}
+
+// writeOutputBundle writes the output APK, the diff, and provenance.json to a
+// directory, or a zip file if name ends with .zip.
+func writeOutputBundle(name, apk string, diff []byte, p provenance) error {
+	apkHash, err := hashFile(apk)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(apk)
+	if err != nil {
+		return err
+	}
+	p.Output = provenanceFile{
+		Name:   filepath.Base(apk),
+		Size:   fi.Size(),
+		SHA256: apkHash,
+	}
+	p.Diff = "patches.diff"
+
+	apkBuf, err := os.ReadFile(apk)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(apk), ".apks") {
+		if p.APKs, err = describeAPKs(apkBuf); err != nil {
+			return err
+		}
+	}
+
+	buf, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{p.Output.Name, apkBuf},
+		{p.Diff, diff},
+		{"provenance.json", buf},
+	}
+
+	if !strings.EqualFold(filepath.Ext(name), ".zip") {
+		if err := os.MkdirAll(name, 0777); err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := os.WriteFile(filepath.Join(name, f.name), f.data, 0666); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for i, x := range files {
+		method := zip.Deflate
+		if i == 0 {
+			method = zip.Store // already compressed
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   x.name,
+			Method: method,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(x.data); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pgaskin/lithiumpatch/patches/patchdef"
+	"github.com/pgaskin/lithiumpatch/patchgen"
+)
+
+// recordDir is the directory generated patches are written to.
+const recordDir = "patches"
+
+var patchNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// record decompiles the APK, waits for a copy of it to be edited by hand, then
+// generates a patch which makes the same changes.
+func record(ctx context.Context, name string) error {
+	if !patchNameRe.MatchString(name) {
+		return fmt.Errorf("record: invalid patch name %q (must be lowercase letters, digits, and underscores)", name)
+	}
+	ps, err := patchdef.Patches()
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(ps, func(p *patchdef.Patch) bool { return p.Name() == name }) {
+		return fmt.Errorf("record: patch %q already exists", name)
+	}
+	if fi, err := os.Stat(recordDir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("record: %q not found (record must be run from the root of the repository)", recordDir)
+	}
+	out := filepath.Join(recordDir, name+".go")
+	if _, err := os.Stat(out); err == nil {
+		return fmt.Errorf("record: %q already exists", out)
+	}
+
+	fmt.Printf("> Creating temp dirs\n")
+	tmp, err := os.MkdirTemp("", "lithiumpatch")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	defer cleanup(tmp, true)
+
+	pristine := filepath.Join(tmp, "pristine")
+	if err := os.Mkdir(pristine, 0777); err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	edit := filepath.Join(tmp, "edit")
+	fmt.Println()
+
+	apk, _, err := openInput(input, tmp)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("> Checking APK %q\n", apk)
+	if err := checkPatched(apk); err != nil {
+		return err
+	}
+	apkHash, err := hashFile(apk)
+	if err != nil {
+		return fmt.Errorf("hash apk: %w", err)
+	}
+	ver, known := detectVersion(apkHash)
+	fmt.Println()
+
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	apktoolHash, err := hashFile(*Apktool)
+	if err != nil {
+		return fmt.Errorf("hash apktool: %w", err)
+	}
+	if _, err := decompile(ctx, c, apk, apkHash, apktoolHash, pristine); err != nil {
+		return err
+	}
+	fmt.Println()
+
+	if !known {
+		if ver, err = detectVersionFromManifest(pristine); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("> Copying decompiled APK to %q\n", edit)
+	if err := os.CopyFS(edit, os.DirFS(pristine)); err != nil {
+		return fmt.Errorf("copy decompiled apk: %w", err)
+	}
+	fmt.Println()
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			lines <- strings.TrimSpace(sc.Text())
+		}
+		close(lines)
+	}()
+
+	for {
+		fmt.Printf("> Edit the files in %q (press enter to generate %s from the changes, or q to quit)\n", edit, out)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case l, ok := <-lines:
+			if !ok || l == "q" {
+				return nil
+			}
+		}
+
+		fmt.Printf("> Generating %s\n", out)
+		r, err := patchgen.Generate(name, os.DirFS(pristine), os.DirFS(edit), patchgen.Options{
+			Versions: []string{ver},
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			fmt.Println()
+			continue
+		}
+		if err := recordWrite(out, name, r); err != nil {
+			return err
+		}
+		for _, f := range r.Files {
+			fmt.Printf("... %s\n", f)
+		}
+		fmt.Println()
+	}
+}
+
+// recordWrite writes a generated patch and its embedded files, replacing any
+// previously generated ones.
+func recordWrite(out, name string, r *patchgen.Result) error {
+	if err := os.RemoveAll(filepath.Join(recordDir, name+"_files")); err != nil {
+		return fmt.Errorf("write patch: %w", err)
+	}
+	for p, buf := range r.Assets {
+		p = filepath.Join(recordDir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			return fmt.Errorf("write patch: %w", err)
+		}
+		if err := os.WriteFile(p, buf, 0666); err != nil {
+			return fmt.Errorf("write patch: %w", err)
+		}
+	}
+	if err := os.WriteFile(out, r.Source, 0666); err != nil {
+		return fmt.Errorf("write patch: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"archive/zip"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// spliceAPK builds an APK by updating the assets, native libraries, and
+// unknown files in the base APK from the ones in the decompiled APK, and
+// replacing the dex files (an empty path means the dex should be removed). It
+// removes the v1 signature, but does not zipalign or sign the output. The
+// resources in the decompiled APK must be the same as the ones in base.
+func spliceAPK(base, dis, out string, dex map[string]string) error {
+	noCompress, err := apktoolDoNotCompress(dis)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.OpenReader(base)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	seen := map[string]bool{}
+	for _, zf := range zr.File {
+		seen[zf.Name] = true
+		if isV1Signature(zf.Name) {
+			continue
+		}
+		if p, ok := dex[zf.Name]; ok {
+			if p != "" {
+				if err := spliceFile(zw, zf.Name, zf.Method, p); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if p, ok := spliceSource(dis, zf.Name); ok {
+			if fi, err := os.Stat(p); os.IsNotExist(err) {
+				continue // deleted
+			} else if err != nil {
+				return err
+			} else if same, err := sameFile(p, fi, zf); err != nil {
+				return err
+			} else if !same {
+				if err := spliceFile(zw, zf.Name, zf.Method, p); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		rc, err := zf.OpenRaw()
+		if err != nil {
+			return err
+		}
+		w, err := zw.CreateRaw(&zf.FileHeader)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, rc); err != nil {
+			return err
+		}
+	}
+
+	added := map[string]string{}
+	for _, dir := range []string{"assets", "lib", "unknown"} {
+		if err := filepath.WalkDir(filepath.Join(dis, dir), func(p string, d fs.DirEntry, err error) error {
+			if os.IsNotExist(err) {
+				return fs.SkipDir
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dis, p)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+			if dir == "unknown" {
+				name = strings.TrimPrefix(name, "unknown/")
+			}
+			if !seen[name] {
+				added[name] = p
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	for name, p := range dex {
+		if !seen[name] && p != "" {
+			added[name] = p
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(added)) {
+		method := zip.Deflate
+		if strings.HasSuffix(name, ".so") || slices.ContainsFunc(noCompress, func(x string) bool {
+			return x == name || x == strings.TrimPrefix(path.Ext(name), ".")
+		}) {
+			method = zip.Store
+		}
+		if err := spliceFile(zw, name, method, added[name]); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// spliceSource gets the file in the decompiled APK which the specified file in
+// the APK comes from, if it isn't built by apktool.
+func spliceSource(dis, name string) (string, bool) {
+	if strings.HasPrefix(name, "assets/") || strings.HasPrefix(name, "lib/") {
+		return filepath.Join(dis, filepath.FromSlash(name)), true
+	}
+	// apktool puts files it doesn't recognize here
+	p := filepath.Join(dis, "unknown", filepath.FromSlash(name))
+	if _, err := os.Stat(p); err == nil {
+		return p, true
+	}
+	return "", false
+}
+
+// spliceFile adds a file to the zip.
+func spliceFile(zw *zip.Writer, name string, method uint16, p string) error {
+	buf, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   name,
+		Method: method,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// sameFile checks if a file has the same contents as a file in a zip.
+func sameFile(p string, fi fs.FileInfo, zf *zip.File) (bool, error) {
+	if uint64(fi.Size()) != zf.UncompressedSize64 {
+		return false, nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return h.Sum32() == zf.CRC32, nil
+}
+
+// isV1Signature checks if name is part of a JAR signature.
+func isV1Signature(name string) bool {
+	dir, base := path.Split(name)
+	if dir != "META-INF/" {
+		return false
+	}
+	switch ext := strings.ToUpper(path.Ext(base)); {
+	case base == "MANIFEST.MF":
+		return true
+	case ext == ".SF", ext == ".RSA", ext == ".DSA", ext == ".EC":
+		return true
+	case strings.HasPrefix(strings.ToUpper(base), "SIG-"):
+		return true
+	}
+	return false
+}
+
+var apktoolYmlDoNotCompress = regexp.MustCompile(`(?m)^doNotCompress:\s*\n((?:-\s.*\n?)*)`)
+
+// apktoolDoNotCompress gets the files and extensions which apktool stores
+// uncompressed.
+func apktoolDoNotCompress(dis string) ([]string, error) {
+	buf, err := os.ReadFile(filepath.Join(dis, "apktool.yml"))
+	if err != nil {
+		return nil, err
+	}
+	var xs []string
+	if m := apktoolYmlDoNotCompress.FindSubmatch(buf); m != nil {
+		for _, l := range strings.Split(string(m[1]), "\n") {
+			if x, ok := strings.CutPrefix(strings.TrimSpace(l), "- "); ok {
+				xs = append(xs, strings.Trim(strings.TrimSpace(x), `'"`))
+			}
+		}
+	}
+	return xs, nil
+}
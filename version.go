@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pgaskin/lithiumpatch/app"
+)
+
+// detectVersion gets the Lithium version of the original APK from its hash,
+// and sets the default apktool path for it. If the hash is unknown, it returns
+// the latest version and false, and the version should be detected from the
+// manifest after decompiling the APK with the latest version's apktool.
+func detectVersion(apkHash string) (string, bool) {
+	v, known := app.ByHash(apkHash)
+	if known {
+		fmt.Printf("... Lithium %s (%d)\n", v.Name, v.Code)
+	} else {
+		v = app.Latest()
+		fmt.Fprintf(os.Stderr, "Warning: APK hash %s does not match any known version of Lithium, will detect the version from the manifest\n", apkHash)
+	}
+	if *Apktool == "" {
+		*Apktool = filepath.Join("lib", "apktool-"+v.Apktool+".jar")
+	}
+	return v.Name, known
+}
+
+// detectVersionFromManifest gets the versionName of a decompiled APK with an
+// unknown hash.
+func detectVersionFromManifest(dis string) (string, error) {
+	fmt.Printf("> Detecting version from manifest\n")
+	name, err := apktoolVersionName(dis)
+	if err != nil {
+		return "", fmt.Errorf("detect version: %w", err)
+	}
+	if v, ok := app.ByName(name); ok {
+		fmt.Fprintf(os.Stderr, "Warning: APK has version %s (%d), but does not match the original APK, so it may have been modified or re-signed\n", v.Name, v.Code)
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: APK has unknown version %s, so patches not tested with it may fail\n", name)
+	}
+	return name, nil
+}
+
+var apktoolYmlVersionName = regexp.MustCompile(`(?m)^\s*versionName:\s*(.+?)\s*$`)
+
+// apktoolVersionName gets the versionName from apktool.yml.
+func apktoolVersionName(dis string) (string, error) {
+	buf, err := os.ReadFile(filepath.Join(dis, "apktool.yml"))
+	if err != nil {
+		return "", err
+	}
+	m := apktoolYmlVersionName.FindSubmatch(buf)
+	if m == nil {
+		return "", fmt.Errorf("could not find versionName in apktool.yml")
+	}
+	return strings.Trim(string(m[1]), `'"`), nil
+}
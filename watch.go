@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/fs"
+	"iter"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// watchDirs are the directories containing code which is compiled into the
+// patches.
+var watchDirs = []string{"patches", filepath.Join("dict", "lib")}
+
+// watch decompiles the APK, then repeatedly re-applies the patches (by running
+// lithiumpatch with go run in check mode) to a fresh copy of it whenever the
+// patches change. The APK is only built when requested.
+func watch(ctx context.Context) error {
+	apk := input
+	for _, d := range watchDirs {
+		if fi, err := os.Stat(d); err != nil || !fi.IsDir() {
+			return fmt.Errorf("watch: %q not found (--watch must be run from the root of the repository)", d)
+		}
+	}
+
+	fmt.Printf("> Creating temp dirs\n")
+	tmp, err := os.MkdirTemp("", "lithiumpatch")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	defer cleanup(tmp, true)
+
+	pristine := filepath.Join(tmp, "pristine")
+	if err := os.Mkdir(pristine, 0777); err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	work := filepath.Join(tmp, "work")
+	fmt.Println()
+
+	apk, _, err = openInput(apk, tmp)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("> Checking APK %q\n", apk)
+	if err := checkPatched(apk); err != nil {
+		return err
+	}
+	apkHash, err := hashFile(apk)
+	if err != nil {
+		return fmt.Errorf("hash apk: %w", err)
+	}
+	detectVersion(apkHash)
+	fmt.Println()
+
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	apktoolHash, err := hashFile(*Apktool)
+	if err != nil {
+		return fmt.Errorf("hash apktool: %w", err)
+	}
+	if _, err := decompile(ctx, c, apk, apkHash, apktoolHash, pristine); err != nil {
+		return err
+	}
+	fmt.Println()
+
+	// the same arguments, but without --watch
+	var args []string
+	for _, a := range os.Args[1:] {
+		if a != "--watch" && !strings.HasPrefix(a, "--watch=") {
+			args = append(args, a)
+		}
+	}
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			lines <- strings.TrimSpace(sc.Text())
+		}
+		close(lines)
+	}()
+
+	var (
+		snap  = watchSnapshot()
+		apply = true
+		tick  = time.NewTicker(time.Second / 2)
+	)
+	defer tick.Stop()
+	for {
+		if apply {
+			fmt.Printf("> Applying patches to a copy of the decompiled APK\n")
+			if err := os.RemoveAll(work); err != nil {
+				return fmt.Errorf("copy decompiled apk: %w", err)
+			}
+			if err := os.CopyFS(work, os.DirFS(pristine)); err != nil {
+				return fmt.Errorf("copy decompiled apk: %w", err)
+			}
+			if err := watchRun(ctx, append([]string{"--check", "--watch-tree", work}, args...)...); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			}
+			fmt.Println()
+			apply = false
+		}
+		fmt.Printf("> Watching %s for changes (press enter to build the APK, or q to quit)\n", strings.Join(watchDirs, ", "))
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case l, ok := <-lines:
+				if !ok || l == "q" {
+					return nil
+				}
+				fmt.Printf("> Building APK\n")
+				if err := watchRun(ctx, args...); err != nil {
+					fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				}
+				fmt.Println()
+				break wait
+			case <-tick.C:
+				if cur := watchSnapshot(); !maps.Equal(cur, snap) {
+					for changed := range watchChanged(snap, cur) {
+						fmt.Printf("... %s changed\n", changed)
+					}
+					fmt.Println()
+					snap, apply = cur, true
+					break wait
+				}
+			}
+		}
+	}
+}
+
+// watchRun runs the current version of lithiumpatch with the specified
+// arguments.
+func watchRun(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "go", append([]string{"run", "."}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+type watchStat struct {
+	ModTime int64
+	Size    int64
+}
+
+// watchSnapshot gets the modification time and size of the files in the
+// watched directories.
+func watchSnapshot() map[string]watchStat {
+	m := map[string]watchStat{}
+	for _, d := range watchDirs {
+		filepath.WalkDir(d, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if fi, err := d.Info(); err == nil {
+				m[p] = watchStat{fi.ModTime().UnixNano(), fi.Size()}
+			}
+			return nil
+		})
+	}
+	return m
+}
+
+// watchChanged gets the files which were added, removed, or changed between two
+// snapshots.
+func watchChanged(a, b map[string]watchStat) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for _, p := range slices.Sorted(maps.Keys(b)) {
+			if x, ok := a[p]; !ok || x != b[p] {
+				if !yield(p) {
+					return
+				}
+			}
+		}
+		for _, p := range slices.Sorted(maps.Keys(a)) {
+			if _, ok := b[p]; !ok {
+				if !yield(p) {
+					return
+				}
+			}
+		}
+	}
+}
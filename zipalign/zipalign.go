@@ -0,0 +1,300 @@
+// Package zipalign aligns uncompressed files in APKs like the zipalign tool
+// from the Android build tools.
+package zipalign
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Alignment for uncompressed files.
+const (
+	Alignment       = 4
+	SharedAlignment = 4096 // for .so files, so they can be mapped directly
+)
+
+const (
+	sigLocal      = 0x04034b50
+	sigCentral    = 0x02014b50
+	sigEnd        = 0x06054b50
+	sigDescriptor = 0x08074b50
+
+	// extraAlign is the extra field used by Android tools for alignment
+	// padding (id, size, alignment, zero padding).
+	extraAlign    = 0xd935
+	extraAlignMin = 6
+)
+
+// entry is a file in a zip.
+type entry struct {
+	Name    string
+	Central []byte // central directory record
+	Local   int64  // local header offset
+	Method  uint16
+	Flags   uint16
+	CSize   int64
+}
+
+// alignment gets the required alignment for the entry, or zero if it doesn't
+// need to be aligned.
+func (e *entry) alignment() int64 {
+	if e.Method != 0 {
+		return 0
+	}
+	if strings.HasSuffix(e.Name, ".so") {
+		return SharedAlignment
+	}
+	return Alignment
+}
+
+// AlignFile aligns a zip file, writing the aligned zip to out.
+func AlignFile(out, in string) error {
+	buf, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := Align(f, buf); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Align rewrites a zip file so the data for uncompressed files is aligned,
+// replacing any existing alignment padding. Data between entries (e.g., an APK
+// signing block) is discarded.
+func Align(w io.Writer, buf []byte) error {
+	es, end, err := readZip(buf)
+	if err != nil {
+		return err
+	}
+
+	byOffset := slices.Clone(es)
+	slices.SortFunc(byOffset, func(a, b *entry) int {
+		return cmp.Compare(a.Local, b.Local)
+	})
+
+	var (
+		off    int64
+		local  = map[*entry]int64{}
+		header = make([]byte, 30)
+	)
+	for _, e := range byOffset {
+		hdr, extra, data, err := readLocal(buf, e)
+		if err != nil {
+			return err
+		}
+		copy(header, hdr)
+
+		extra = stripAlignment(extra)
+		if a := e.alignment(); a != 0 {
+			start := off + int64(len(hdr)) + int64(len(extra))
+			if pad := (a - start%a) % a; pad != 0 {
+				for pad < extraAlignMin {
+					pad += a
+				}
+				x := make([]byte, pad)
+				binary.LittleEndian.PutUint16(x[0:], extraAlign)
+				binary.LittleEndian.PutUint16(x[2:], uint16(pad-4))
+				binary.LittleEndian.PutUint16(x[4:], uint16(a))
+				extra = append(slices.Clip(extra), x...)
+			}
+		}
+		if len(extra) > 0xffff {
+			return fmt.Errorf("align %q: extra field too long", e.Name)
+		}
+		binary.LittleEndian.PutUint16(header[28:], uint16(len(extra)))
+
+		local[e] = off
+		for _, b := range [][]byte{header, hdr[30:], extra, data} {
+			n, err := w.Write(b)
+			off += int64(n)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	cdOff := off
+	for _, e := range es {
+		if local[e] > 0xffffffff {
+			return errors.New("align: zip64 is not supported")
+		}
+		binary.LittleEndian.PutUint32(e.Central[42:], uint32(local[e]))
+		n, err := w.Write(e.Central)
+		off += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	if off > 0xffffffff {
+		return errors.New("align: zip64 is not supported")
+	}
+	binary.LittleEndian.PutUint32(end[12:], uint32(off-cdOff))
+	binary.LittleEndian.PutUint32(end[16:], uint32(cdOff))
+	_, err = w.Write(end)
+	return err
+}
+
+// VerifyFile checks if a zip file is aligned.
+func VerifyFile(name string) error {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return Verify(buf)
+}
+
+// Verify checks that the data for all uncompressed files in a zip is aligned,
+// like zipalign -c -p 4.
+func Verify(buf []byte) error {
+	es, _, err := readZip(buf)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, e := range es {
+		hdr, extra, _, err := readLocal(buf, e)
+		if err != nil {
+			return err
+		}
+		if a := e.alignment(); a != 0 {
+			if start := e.Local + int64(len(hdr)+len(extra)); start%a != 0 {
+				errs = append(errs, fmt.Errorf("%s: data at offset %d is not aligned to %d bytes", e.Name, start, a))
+			}
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("zip is not aligned: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// readZip reads the central directory and a copy of the end of central
+// directory record of a zip file.
+func readZip(buf []byte) ([]*entry, []byte, error) {
+	off := -1
+	for i := len(buf) - 22; i >= 0 && i >= len(buf)-22-0xffff; i-- {
+		if binary.LittleEndian.Uint32(buf[i:]) == sigEnd && i+22+int(binary.LittleEndian.Uint16(buf[i+20:])) == len(buf) {
+			off = i
+			break
+		}
+	}
+	if off == -1 {
+		return nil, nil, errors.New("invalid zip: end of central directory not found")
+	}
+	end := slices.Clone(buf[off:])
+
+	var (
+		count  = int(binary.LittleEndian.Uint16(end[10:]))
+		cdSize = int64(binary.LittleEndian.Uint32(end[12:]))
+		cdOff  = int64(binary.LittleEndian.Uint32(end[16:]))
+	)
+	if count == 0xffff || cdSize == 0xffffffff || cdOff == 0xffffffff {
+		return nil, nil, errors.New("invalid zip: zip64 is not supported")
+	}
+	if binary.LittleEndian.Uint16(end[4:]) != 0 || binary.LittleEndian.Uint16(end[6:]) != 0 {
+		return nil, nil, errors.New("invalid zip: multi-disk zips are not supported")
+	}
+	if cdOff+cdSize > int64(off) {
+		return nil, nil, errors.New("invalid zip: central directory out of bounds")
+	}
+
+	var (
+		es = make([]*entry, 0, count)
+		cd = buf[cdOff : cdOff+cdSize]
+	)
+	for len(cd) != 0 {
+		if len(cd) < 46 || binary.LittleEndian.Uint32(cd) != sigCentral {
+			return nil, nil, errors.New("invalid zip: invalid central directory record")
+		}
+		n := 46 + int(binary.LittleEndian.Uint16(cd[28:])) + int(binary.LittleEndian.Uint16(cd[30:])) + int(binary.LittleEndian.Uint16(cd[32:]))
+		if len(cd) < n {
+			return nil, nil, errors.New("invalid zip: invalid central directory record")
+		}
+		e := &entry{
+			Name:    string(cd[46 : 46+int(binary.LittleEndian.Uint16(cd[28:]))]),
+			Central: slices.Clone(cd[:n]),
+			Local:   int64(binary.LittleEndian.Uint32(cd[42:])),
+			Method:  binary.LittleEndian.Uint16(cd[10:]),
+			Flags:   binary.LittleEndian.Uint16(cd[8:]),
+			CSize:   int64(binary.LittleEndian.Uint32(cd[20:])),
+		}
+		if e.Local == 0xffffffff || e.CSize == 0xffffffff {
+			return nil, nil, errors.New("invalid zip: zip64 is not supported")
+		}
+		es = append(es, e)
+		cd = cd[n:]
+	}
+	if len(es) != count {
+		return nil, nil, fmt.Errorf("invalid zip: expected %d entries, found %d", count, len(es))
+	}
+	return es, end, nil
+}
+
+// readLocal reads the local header (including the name), the local extra
+// field, and the file data (including the data descriptor, if any) for an
+// entry.
+func readLocal(buf []byte, e *entry) (hdr, extra, data []byte, err error) {
+	if e.Local+30 > int64(len(buf)) || binary.LittleEndian.Uint32(buf[e.Local:]) != sigLocal {
+		return nil, nil, nil, fmt.Errorf("invalid zip: %s: invalid local header", e.Name)
+	}
+	var (
+		nameLen  = int64(binary.LittleEndian.Uint16(buf[e.Local+26:]))
+		extraLen = int64(binary.LittleEndian.Uint16(buf[e.Local+28:]))
+		start    = e.Local + 30 + nameLen + extraLen
+		stop     = start + e.CSize
+	)
+	if e.Flags&0x8 != 0 {
+		if stop+4 <= int64(len(buf)) && binary.LittleEndian.Uint32(buf[stop:]) == sigDescriptor {
+			stop += 16
+		} else {
+			stop += 12
+		}
+	}
+	if stop > int64(len(buf)) {
+		return nil, nil, nil, fmt.Errorf("invalid zip: %s: data out of bounds", e.Name)
+	}
+	hdr = buf[e.Local : e.Local+30+nameLen]
+	extra = buf[e.Local+30+nameLen : start]
+	data = buf[start:stop]
+	return hdr, extra, data, nil
+}
+
+// stripAlignment removes alignment padding from an extra field. If the extra
+// field isn't well-formed (e.g., if it was padded with zeros by an old version
+// of zipalign), trailing zeros are removed instead.
+func stripAlignment(extra []byte) []byte {
+	var (
+		out []byte
+		x   = extra
+	)
+	for len(x) >= 4 {
+		id, n := binary.LittleEndian.Uint16(x), int(binary.LittleEndian.Uint16(x[2:]))
+		if len(x) < 4+n {
+			break
+		}
+		if id != extraAlign && (id != 0 || n != 0) {
+			out = append(out, x[:4+n]...)
+		}
+		x = x[4+n:]
+	}
+	if len(x) == 0 {
+		return out
+	}
+	i := len(extra)
+	for i > 0 && extra[i-1] == 0 {
+		i--
+	}
+	return extra[:i]
+}
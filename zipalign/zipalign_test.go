@@ -0,0 +1,220 @@
+package zipalign
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type testFile struct {
+	Name   string
+	Data   string
+	Method uint16
+	Raw    bool   // without a data descriptor
+	Extra  []byte // local and central extra field
+}
+
+func TestAlign(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files []testFile
+	}{
+		{"Empty", nil},
+		{"Stored", []testFile{
+			{Name: "a", Data: "a"},
+			{Name: "bb", Data: "bbb"},
+			{Name: "ccc", Data: "ccccc"},
+		}},
+		{"StoredRaw", []testFile{
+			{Name: "a", Data: "a", Raw: true},
+			{Name: "bb", Data: "bbb", Raw: true},
+			{Name: "ccc", Data: "ccccc", Raw: true},
+		}},
+		{"Deflated", []testFile{
+			{Name: "classes.dex", Data: strings.Repeat("dex", 100), Method: zip.Deflate},
+			{Name: "resources.arsc", Data: "arsc"},
+			{Name: "AndroidManifest.xml", Data: strings.Repeat("xml", 100), Method: zip.Deflate},
+		}},
+		{"Shared", []testFile{
+			{Name: "AndroidManifest.xml", Data: "xml"},
+			{Name: "lib/arm64-v8a/liba.so", Data: strings.Repeat("so", 3000)},
+			{Name: "lib/arm64-v8a/libbb.so", Data: "so", Raw: true},
+			{Name: "lib/arm64-v8a/libccc.so", Data: "so", Method: zip.Deflate},
+		}},
+		{"Extra", []testFile{
+			{Name: "a", Data: "a", Extra: []byte{0xfe, 0xca, 0x02, 0x00, 0x01, 0x02}},
+			{Name: "b", Data: "b", Raw: true, Extra: []byte{0xfe, 0xca, 0x00, 0x00}},
+			{Name: "c", Data: "c", Method: zip.Deflate, Extra: []byte{0xfe, 0xca, 0x01, 0x00, 0x01}},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in := testZip(t, tc.files)
+
+			var out bytes.Buffer
+			if err := Align(&out, in); err != nil {
+				t.Fatalf("align: %v", err)
+			}
+			if err := Verify(out.Bytes()); err != nil {
+				t.Fatalf("verify aligned: %v", err)
+			}
+			checkZip(t, out.Bytes(), tc.files)
+
+			var again bytes.Buffer
+			if err := Align(&again, out.Bytes()); err != nil {
+				t.Fatalf("re-align: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), again.Bytes()) {
+				t.Errorf("aligning an aligned zip changed it")
+			}
+		})
+	}
+}
+
+func TestAlignPadding(t *testing.T) {
+	// zipalign from old build tools padded with zeros instead of a proper
+	// extra field
+	in := testZip(t, []testFile{{Name: "a", Data: "a", Raw: true}})
+	padded := slices.Concat(in[:30+1], make([]byte, 5), in[30+1:])
+	binary.LittleEndian.PutUint16(padded[28:], 5)
+	end := padded[len(padded)-22:]
+	binary.LittleEndian.PutUint32(end[16:], binary.LittleEndian.Uint32(end[16:])+5)
+
+	if err := Verify(padded); err != nil {
+		t.Fatalf("verify padded: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Align(&out, padded); err != nil {
+		t.Fatalf("align: %v", err)
+	}
+	if err := Verify(out.Bytes()); err != nil {
+		t.Fatalf("verify aligned: %v", err)
+	}
+	if id := binary.LittleEndian.Uint16(out.Bytes()[30+1:]); id != extraAlign {
+		t.Errorf("expected zero padding to be replaced with an alignment extra field, got id %#x", id)
+	}
+	checkZip(t, out.Bytes(), []testFile{{Name: "a", Data: "a"}})
+}
+
+func TestVerify(t *testing.T) {
+	in := testZip(t, []testFile{
+		{Name: "a", Data: "a", Raw: true},
+		{Name: "b", Data: "b", Raw: true},
+		{Name: "c", Data: "c", Method: zip.Deflate, Raw: true},
+	})
+	if err := Verify(in); err == nil {
+		t.Errorf("expected unaligned zip to fail verification")
+	} else if s := err.Error(); !strings.Contains(s, "a: ") || !strings.Contains(s, "b: ") || strings.Contains(s, "c: ") {
+		t.Errorf("expected only the stored files to be unaligned, got %q", s)
+	}
+	if err := Verify([]byte("not a zip")); err == nil {
+		t.Errorf("expected invalid zip to fail verification")
+	}
+}
+
+// testZip creates a zip with archive/zip. The local extra fields are the same
+// as the central ones.
+func testZip(t *testing.T, files []testFile) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, f := range files {
+		fh := &zip.FileHeader{Name: f.Name, Method: f.Method, Extra: f.Extra}
+		var (
+			w   io.Writer
+			err error
+		)
+		if f.Raw {
+			var data bytes.Buffer
+			switch f.Method {
+			case zip.Store:
+				data.WriteString(f.Data)
+			case zip.Deflate:
+				data.Write(testDeflate(t, f.Data))
+			}
+			fh.CRC32 = crc32.ChecksumIEEE([]byte(f.Data))
+			fh.CompressedSize64 = uint64(data.Len())
+			fh.UncompressedSize64 = uint64(len(f.Data))
+			if w, err = zw.CreateRaw(fh); err == nil {
+				_, err = data.WriteTo(w)
+			}
+		} else {
+			if w, err = zw.CreateHeader(fh); err == nil {
+				_, err = io.WriteString(w, f.Data)
+			}
+		}
+		if err != nil {
+			t.Fatalf("create zip: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("create zip: %v", err)
+	}
+	return b.Bytes()
+}
+
+// testDeflate gets the raw deflate stream for data.
+func testDeflate(t *testing.T, data string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "x", Method: zip.Deflate})
+	if err != nil {
+		t.Fatalf("deflate: %v", err)
+	}
+	io.WriteString(w, data)
+	zw.Close()
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("deflate: %v", err)
+	}
+	rc, err := zr.File[0].OpenRaw()
+	if err != nil {
+		t.Fatalf("deflate: %v", err)
+	}
+	buf, _ := io.ReadAll(rc)
+	return buf
+}
+
+// checkZip checks that a zip contains the expected files, in the same order,
+// with the original local and central extra fields.
+func checkZip(t *testing.T, buf []byte, files []testFile) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		t.Fatalf("read zip: %v", err)
+	}
+	es, _, err := readZip(buf)
+	if err != nil {
+		t.Fatalf("read zip: %v", err)
+	}
+	if len(zr.File) != len(files) || len(es) != len(files) {
+		t.Fatalf("expected %d files, got %d", len(files), len(zr.File))
+	}
+	for i, f := range zr.File {
+		if f.Name != files[i].Name {
+			t.Errorf("file %d: expected name %q, got %q", i, files[i].Name, f.Name)
+			continue
+		}
+		if rc, err := f.Open(); err != nil {
+			t.Errorf("%s: open: %v", f.Name, err)
+		} else if data, err := io.ReadAll(rc); err != nil {
+			t.Errorf("%s: read: %v", f.Name, err)
+		} else if string(data) != files[i].Data {
+			t.Errorf("%s: data mismatch", f.Name)
+		}
+		if !bytes.Equal(f.Extra, files[i].Extra) {
+			t.Errorf("%s: expected central extra %x, got %x", f.Name, files[i].Extra, f.Extra)
+		}
+		if _, extra, _, err := readLocal(buf, es[i]); err != nil {
+			t.Errorf("%s: read local header: %v", f.Name, err)
+		} else if extra = stripAlignment(extra); !bytes.Equal(extra, files[i].Extra) {
+			t.Errorf("%s: expected local extra %x, got %x", f.Name, files[i].Extra, extra)
+		}
+	}
+}